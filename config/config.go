@@ -4,9 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"path"
 	"reflect"
+	"regexp"
+
+	"golang.org/x/exp/slices"
 )
 
 var (
@@ -15,6 +21,9 @@ var (
 	ForceReplace bool
 	Version      bool
 	ClearCache   bool
+	Resume       bool
+	ForceStage   string
+	ForceService string
 )
 
 type Configuration struct {
@@ -22,11 +31,15 @@ type Configuration struct {
 	Output_dir            string   `json:"output_dir"`
 	Service_list          []string `json:"service_list"`
 	Group_id              string   `json:"group_id"`
+	IncludeSubgroups      bool     `json:"include_subgroups"`
 	Branch                string   `json:"branch"`
+	FetchMode             string   `json:"fetch_mode"`
 	Archive_format        string   `json:"archive_format"`
 	MavenUrl              string   `json:"maven_url"`
 	PluginsUrl            string   `json:"plugins_url"`
+	MavenListings         bool     `json:"maven_listings"`
 	ReadmeTemplate        string   `json:"readme_template"`
+	MavenReadmeTemplate   string   `json:"maven_readme_template"`
 	MaxParallelism        int      `json:"max_parallelism"`
 	RtlSearchRepoId       string   `json:"rtl_search_repo_id"`
 	UploadToNexus         bool     `json:"upload_to_nexus"`
@@ -46,6 +59,160 @@ type Configuration struct {
 	FrankProjectID        string   `json:"frank_project_id"`
     FrankBranch           string   `json:"frank_branch"`
 	FrankServiceList      []string `json:"frank_service_list"`
+	BuildDescriptors      []string `json:"build_descriptors"`
+	BuildStagePattern     string   `json:"build_stage_pattern"`
+	ContainerRuntime      string   `json:"container_runtime"`
+	ContainerEndpoint     string   `json:"container_endpoint"`
+	VerifyBuild           bool     `json:"verify_build"`
+	ReproducibilityCheck  bool     `json:"reproducibility_check"`
+	Services              map[string]ServiceConfiguration `json:"services"`
+	Repositories          []Repository                    `json:"repositories"`
+	Downloads             Downloads                       `json:"downloads"`
+	Sources               []SourceMapping                 `json:"sources"`
+	ScmFallback           *ScmFallback                    `json:"scm_fallback"`
+}
+
+// SourceMapping Источник исходных кодов внутренних зависимостей. Group - шаблон groupId зависимости,
+// Match - способ сравнения: exact (по умолчанию), prefix или regex. Provider - хранилище: gitlab (по умолчанию),
+// gitea (адрес сервера Url, токен из переменной среды TokenEnv) или local (каталог bare репозиториев Url).
+// Groups - группы хранилища (id или полные пути групп gitlab, организации gitea, каталоги относительно Url),
+// в которых ищется проект зависимости, Subgroups - искать и во вложенных группах. GitlabGroups - прежнее имя Groups.
+// Projects - правила получения пути проекта из artifactId, без подходящего правила путь проекта совпадает с artifactId.
+type SourceMapping struct {
+	Group        string        `json:"group"`
+	Match        string        `json:"match"`
+	Provider     string        `json:"provider"`
+	Url          string        `json:"url"`
+	TokenEnv     string        `json:"token_env"`
+	Groups       []string      `json:"groups"`
+	GitlabGroups []string      `json:"gitlab_groups"`
+	Subgroups    bool          `json:"subgroups"`
+	Projects     []ProjectRule `json:"projects"`
+	RefLookup
+}
+
+// ProjectRule Правило получения пути проекта gitlab из artifactId: регулярное выражение Artifact
+// и шаблон Project, в котором $1, ${name} заменяются группами выражения
+type ProjectRule struct {
+	Artifact string `json:"artifact"`
+	Project  string `json:"project"`
+}
+
+// RefLookup Поиск ref исходных кодов внутренней зависимости в gitlab по ее версии.
+// В шаблонах подставляются {version}, {artifactId} и {groupId}. Сначала ищется тег по TagTemplates в порядке шаблонов,
+// затем ветка по BranchTemplates, затем коммит, сообщение которого содержит CommitTemplates (без учета регистра),
+// среди последних CommitSearchDepth коммитов проекта. Поиск веток и коммитов выполняется, только если их шаблоны заданы.
+type RefLookup struct {
+	TagTemplates      []string `json:"tag_templates"`
+	BranchTemplates   []string `json:"branch_templates"`
+	CommitTemplates   []string `json:"commit_templates"`
+	CommitSearchDepth int      `json:"commit_search_depth"`
+}
+
+// ScmFallback Получение исходных кодов сторонних зависимостей, у которых нет -sources.jar, по секции scm их pom
+// (с учетом родительских pom). Адрес репозитория сопоставляется с зеркалами Mirrors по порядку, в проекте зеркала
+// ищется тег версии: сначала tag из scm, затем по TagTemplates ({version}, {artifactId}, {groupId}).
+// Без секции scm_fallback получение отключено.
+type ScmFallback struct {
+	Mirrors      []ScmMirror `json:"mirrors"`
+	TagTemplates []string    `json:"tag_templates"`
+}
+
+// ScmMirror Хранилище с копиями репозиториев сторонних библиотек. Scm - регулярное выражение для адреса из pom,
+// приведенного к виду host/path (без схемы, префикса scm:git:, пользователя и суффикса .git), Project - шаблон полного
+// пути проекта в хранилище, в котором $1, ${name} заменяются группами выражения. Provider, Url и TokenEnv - как в sources.
+type ScmMirror struct {
+	Scm      string `json:"scm"`
+	Project  string `json:"project"`
+	Provider string `json:"provider"`
+	Url      string `json:"url"`
+	TokenEnv string `json:"token_env"`
+}
+
+// DefaultScmTagTemplates Шаблоны тегов версии сторонней библиотеки по умолчанию
+var DefaultScmTagTemplates = []string{"v{version}", "{version}", "{artifactId}-{version}", "release-{version}"}
+
+// Downloads Ограничения загрузки зависимостей, общие для всех обрабатываемых сервисов.
+// Rate - запросов в секунду к одному хосту (0 - без ограничения), Burst - допустимый всплеск запросов к хосту.
+// BackoffBase и BackoffMax - начальная и максимальная задержка перед повтором в секундах.
+type Downloads struct {
+	MaxConcurrency int     `json:"max_concurrency"`
+	PerHost        int     `json:"per_host"`
+	Rate           float64 `json:"rate"`
+	Burst          int     `json:"burst"`
+	Retries        *int    `json:"retries"`
+	BackoffBase    int     `json:"backoff_base"`
+	BackoffMax     int     `json:"backoff_max"`
+}
+
+// Repository Maven репозиторий для поиска зависимостей и их исходных кодов.
+// Пароль можно задать явно (password) или ссылкой на переменную окружения (password_env), аналогично логин.
+// Groups - шаблоны groupId, которые ищутся в репозитории (например "org.springframework*"), пустой список - все.
+// Timeout - таймаут ожидания ответа на запрос в секундах (загрузка тела файла не ограничивается), 0 - без таймаута.
+type Repository struct {
+	Name        string   `json:"name"`
+	Url         string   `json:"url"`
+	Username    string   `json:"username"`
+	UsernameEnv string   `json:"username_env"`
+	Password    string   `json:"password"`
+	PasswordEnv string   `json:"password_env"`
+	Groups      []string `json:"groups"`
+	Timeout     int      `json:"timeout"`
+}
+
+// Credentials Возвращает логин и пароль репозитория с учетом ссылок на переменные окружения
+func (r Repository) Credentials() (string, string) {
+	user, pass := r.Username, r.Password
+	if r.UsernameEnv != "" {
+		user = os.Getenv(r.UsernameEnv)
+	}
+	if r.PasswordEnv != "" {
+		pass = os.Getenv(r.PasswordEnv)
+	}
+	return user, pass
+}
+
+// ServiceConfiguration Настройки отдельного сервиса, переопределяющие общие настройки
+type ServiceConfiguration struct {
+	BuildDescriptors  []string `json:"build_descriptors"`
+	BuildStagePattern string   `json:"build_stage_pattern"`
+	FetchMode         string   `json:"fetch_mode"`
+}
+
+// DefaultBuildDescriptors Имена файлов сборки по умолчанию
+var DefaultBuildDescriptors = []string{"Dockerfile.pgs2"}
+
+// DefaultBuildStagePattern Шаблон имени стадии сборки по умолчанию
+const DefaultBuildStagePattern = "^(build|gradle_build)$"
+
+// Descriptors Возвращает имена файлов сборки сервиса в порядке приоритета
+func (c *Configuration) Descriptors(svc string) []string {
+	if sc, ok := c.Services[svc]; ok && len(sc.BuildDescriptors) > 0 {
+		return sc.BuildDescriptors
+	}
+	return c.BuildDescriptors
+}
+
+// StagePattern Возвращает шаблон (регулярное выражение) имени стадии сборки сервиса
+func (c *Configuration) StagePattern(svc string) string {
+	if sc, ok := c.Services[svc]; ok && sc.BuildStagePattern != "" {
+		return sc.BuildStagePattern
+	}
+	return c.BuildStagePattern
+}
+
+// FetchModes Способы получения исходных кодов сервиса: архив gitlab или клонирование с подмодулями и объектами Git LFS
+var FetchModes = []string{"archive", "clone"}
+
+// CloneArchiveFormats Форматы archive_format, в которых упаковываются клонированные исходные коды (fetch_mode clone)
+var CloneArchiveFormats = []string{"tgz", "tar.gz"}
+
+// ServiceFetchMode Возвращает способ получения исходных кодов сервиса
+func (c *Configuration) ServiceFetchMode(svc string) string {
+	if sc, ok := c.Services[svc]; ok && sc.FetchMode != "" {
+		return sc.FetchMode
+	}
+	return c.FetchMode
 }
 
 func Init() {
@@ -54,9 +221,15 @@ func Init() {
 	flag.BoolVar(&ForceReplace, "force", false, "Force replace temp work files")
 	flag.BoolVar(&Version, "v", false, "Show version")
 	flag.BoolVar(&ClearCache, "c", false, "Clear cache dir")
+	flag.BoolVar(&Resume, "resume", false, "Resume processing of each service from its last completed stage")
+	flag.StringVar(&ForceStage, "force-stage", "", "With -force, reprocess services starting from this stage instead of replacing temp work files")
+	flag.StringVar(&ForceService, "force-service", "", "With -force, reprocess only this service instead of replacing temp work files")
 	flag.Parse()
 }
 
+// DefaultMavenReadmeTemplate Шаблон документации сервисов, собираемых maven, по умолчанию
+const DefaultMavenReadmeTemplate = "readme_maven.md.tmpl"
+
 func ReadConfig(file string) (*Configuration, error) {
 	cBytes, err := ioutil.ReadFile(file)
 	if err != nil {
@@ -67,9 +240,143 @@ func ReadConfig(file string) (*Configuration, error) {
 	if err != nil {
 		log.Fatalf("Cannot parse configuration file: %v", err.Error())
 	}
+	if c.MavenReadmeTemplate == "" {
+		c.MavenReadmeTemplate = DefaultMavenReadmeTemplate
+	}
+	if len(c.BuildDescriptors) == 0 {
+		c.BuildDescriptors = DefaultBuildDescriptors
+	}
+	if c.BuildStagePattern == "" {
+		c.BuildStagePattern = DefaultBuildStagePattern
+	}
+	// Без списка repositories используются maven_url и plugins_url в этом порядке,
+	// со списком maven_url и plugins_url необязательны
+	if len(c.Repositories) == 0 {
+		c.Repositories = []Repository{{Name: "maven_url", Url: c.MavenUrl}, {Name: "plugins_url", Url: c.PluginsUrl}}
+	} else {
+		if c.MavenUrl == "" {
+			c.MavenUrl = c.Repositories[0].Url
+		}
+		if c.PluginsUrl == "" {
+			c.PluginsUrl = c.Repositories[0].Url
+		}
+	}
+	for _, r := range c.Repositories {
+		if r.Url == "" {
+			log.Fatalf("Cannot parse configuration file: repository %q without url", r.Name)
+		}
+	}
+	if c.Downloads.MaxConcurrency <= 0 {
+		c.Downloads.MaxConcurrency = 16
+	}
+	if c.Downloads.PerHost <= 0 {
+		c.Downloads.PerHost = 8
+	}
+	if c.Downloads.Retries == nil {
+		retries := 3
+		c.Downloads.Retries = &retries
+	}
+	if c.Downloads.BackoffBase <= 0 {
+		c.Downloads.BackoffBase = 2
+	}
+	if c.Downloads.BackoffMax <= 0 {
+		c.Downloads.BackoffMax = 60
+	}
+	if c.RtlSearchRepoId == "" {
+		c.RtlSearchRepoId = c.Group_id
+	}
+	// Без списка sources исходные коды sx.microservices ищутся в группе group_id, rtl.pgs - в группе rtl_search_repo_id
+	if c.Sources == nil {
+		c.Sources = []SourceMapping{
+			{Group: "sx.microservices", Groups: []string{c.Group_id}, RefLookup: RefLookup{TagTemplates: []string{"v{version}"}}},
+			{Group: "rtl.pgs", Groups: []string{c.RtlSearchRepoId}, RefLookup: RefLookup{TagTemplates: []string{"v{version}", "{version}"}}},
+		}
+	}
+	// Незаданные списки шаблонов заполняются значениями по умолчанию, пустой список отключает способ поиска
+	for i := range c.Sources {
+		m := &c.Sources[i]
+		if len(m.Groups) == 0 {
+			m.Groups = m.GitlabGroups
+		}
+		if m.Group == "" || len(m.Groups) == 0 {
+			log.Fatalf("Cannot parse configuration file: source mapping %q without group or groups", m.Group)
+		}
+		checkProvider(&m.Provider, m.Url, fmt.Sprintf("source mapping %q", m.Group))
+		switch m.Match {
+		case "":
+			m.Match = "exact"
+		case "exact", "prefix", "regex":
+		default:
+			log.Fatalf("Cannot parse configuration file: unknown match %q of source mapping %q, should be exact, prefix or regex", m.Match, m.Group)
+		}
+		if m.TagTemplates == nil {
+			m.TagTemplates = []string{"v{version}"}
+		}
+		if m.CommitSearchDepth <= 0 {
+			m.CommitSearchDepth = 500
+		}
+	}
+	if f := c.ScmFallback; f != nil {
+		if f.TagTemplates == nil {
+			f.TagTemplates = DefaultScmTagTemplates
+		}
+		for i := range f.Mirrors {
+			m := &f.Mirrors[i]
+			if m.Scm == "" || m.Project == "" {
+				log.Fatalf("Cannot parse configuration file: scm_fallback mirror %q without scm or project", m.Scm)
+			}
+			if _, err := regexp.Compile(m.Scm); err != nil {
+				log.Fatalf("Cannot parse configuration file: invalid scm pattern of scm_fallback mirror %q: %v", m.Scm, err)
+			}
+			checkProvider(&m.Provider, m.Url, fmt.Sprintf("scm_fallback mirror %q", m.Scm))
+		}
+	}
+	if c.FetchMode == "" {
+		c.FetchMode = "archive"
+	}
+	if !slices.Contains(FetchModes, c.FetchMode) {
+		log.Fatalf("Cannot parse configuration file: unknown fetch_mode %q, should be archive or clone", c.FetchMode)
+	}
+	for svc, sc := range c.Services {
+		if sc.FetchMode != "" && !slices.Contains(FetchModes, sc.FetchMode) {
+			log.Fatalf("Cannot parse configuration file: unknown fetch_mode %q of service %s, should be archive or clone", sc.FetchMode, svc)
+		}
+	}
+	for _, svc := range c.Service_list {
+		// Настройки services задаются путем проекта, в service_list может быть полный путь
+		svc = path.Base(svc)
+		if c.ServiceFetchMode(svc) == "clone" && !slices.Contains(CloneArchiveFormats, c.Archive_format) {
+			log.Fatalf("Cannot parse configuration file: archive_format %q is not supported with fetch_mode clone of service %s, should be tgz or tar.gz", c.Archive_format, svc)
+		}
+	}
+	if c.ContainerRuntime == "" {
+		c.ContainerRuntime = "docker"
+	}
+	if c.ContainerEndpoint == "" {
+		c.ContainerEndpoint = "/var/run/docker.sock"
+		if c.ContainerRuntime == "podman" {
+			c.ContainerEndpoint = "podman"
+		}
+	}
 	return c, nil
 }
 
+// checkProvider Проверяет тип хранилища provider источника name, пустой тип заменяется на gitlab.
+// Хранилищам gitea и local нужен url.
+func checkProvider(provider *string, url string, name string) {
+	switch *provider {
+	case "":
+		*provider = "gitlab"
+	case "gitlab":
+	case "gitea", "local":
+		if url == "" {
+			log.Fatalf("Cannot parse configuration file: %s with provider %s requires url", name, *provider)
+		}
+	default:
+		log.Fatalf("Cannot parse configuration file: unknown provider %q of %s, should be gitlab, gitea or local", *provider, name)
+	}
+}
+
 func CheckEmpty(c Configuration) error {
 	v := reflect.ValueOf(c)
 	typeOfS := v.Type()
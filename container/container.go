@@ -0,0 +1,92 @@
+// container Пакет для запуска сборок и работы с образами через контейнерный рантайм (Docker Engine API или Podman)
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrImageNotFound Образ отсутствует в локальном хранилище рантайма
+var ErrImageNotFound = errors.New("image not found")
+
+// Mount Каталог или файл хоста, монтируемый в контейнер
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// RunOptions Параметры запуска контейнера. Команда передается списком аргументов, без разбора shell на стороне хоста.
+// Network "none" отключает сеть в контейнере.
+type RunOptions struct {
+	Image   string
+	Cmd     []string
+	User    string
+	WorkDir string
+	Env     []string
+	Mounts  []Mount
+	Network string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// ImageInfo Сведения об образе из локального хранилища рантайма
+type ImageInfo struct {
+	ID          string
+	RepoTags    []string
+	RepoDigests []string
+	Size        int64
+	Created     string
+}
+
+// ExitError Контейнер завершился с ненулевым кодом
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container exited with code %d", e.Code)
+}
+
+// Runtime Контейнерный рантайм: запуск контейнера, загрузка, просмотр и выгрузка образов.
+// При отмене ctx операция прерывается, запущенный контейнер принудительно удаляется.
+type Runtime interface {
+	// Run Запускает контейнер, дожидается завершения и удаляет его. Ненулевой код выхода возвращается как *ExitError.
+	Run(ctx context.Context, opts RunOptions) error
+	// Pull Загружает образ из реестра
+	Pull(ctx context.Context, image string) error
+	// Inspect Возвращает сведения об образе или ErrImageNotFound
+	Inspect(ctx context.Context, image string) (*ImageInfo, error)
+	// Save Выгружает образ в tar архив file (формат docker image save)
+	Save(ctx context.Context, image string, file string) error
+	// Load Загружает образ из tar архива file
+	Load(ctx context.Context, file string) error
+}
+
+// New Возвращает рантайм по имени из конфига: docker (Docker Engine API через unix сокет endpoint) или podman (rootless, через podman cli)
+func New(kind string, endpoint string) (Runtime, error) {
+	switch kind {
+	case "", "docker":
+		if endpoint == "" {
+			endpoint = DefaultDockerSocket
+		}
+		return NewDocker(endpoint), nil
+	case "podman":
+		return NewPodman(endpoint), nil
+	}
+	return nil, fmt.Errorf("unknown container runtime %q, supported: docker, podman", kind)
+}
+
+// EnsureImage Загружает образ из реестра, если его нет в локальном хранилище
+func EnsureImage(ctx context.Context, rt Runtime, image string) error {
+	_, err := rt.Inspect(ctx, image)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrImageNotFound) {
+		return err
+	}
+	return rt.Pull(ctx, image)
+}
@@ -0,0 +1,321 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sources/dockerfile"
+	"sources/logger"
+	"strings"
+)
+
+// DefaultDockerSocket Сокет Docker Engine API по умолчанию
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// Docker Рантайм, работающий с Docker Engine API через unix сокет
+type Docker struct {
+	socket string
+	client *http.Client
+}
+
+// NewDocker Создает клиент Docker Engine API для сокета socket
+func NewDocker(socket string) *Docker {
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Docker{socket: socket, client: &http.Client{Transport: tr}}
+}
+
+// apiError Возвращает ошибку из ответа Docker Engine API
+func apiError(resp *http.Response) error {
+	var e struct {
+		Message string `json:"message"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(b, &e) == nil && e.Message != "" {
+		return fmt.Errorf("docker api: %s (status %d)", e.Message, resp.StatusCode)
+	}
+	return fmt.Errorf("docker api: status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+}
+
+func (d *Docker) do(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	logger.Log.Tracef("Docker api request: %s %s", method, path)
+	return d.client.Do(req)
+}
+
+// Run Создает контейнер, запускает его, дожидается завершения, выводит логи в Stdout/Stderr и удаляет контейнер.
+// При отмене ctx ожидание прерывается и контейнер удаляется принудительно (вместе с запущенной сборкой).
+func (d *Docker) Run(ctx context.Context, opts RunOptions) error {
+	var binds []string
+	for _, m := range opts.Mounts {
+		b := m.Source + ":" + m.Target
+		if m.ReadOnly {
+			b = b + ":ro"
+		}
+		binds = append(binds, b)
+	}
+	hostConfig := map[string]interface{}{"Binds": binds}
+	if opts.Network != "" {
+		hostConfig["NetworkMode"] = opts.Network
+	}
+	create := map[string]interface{}{
+		"Image":      opts.Image,
+		"Cmd":        opts.Cmd,
+		"User":       opts.User,
+		"WorkingDir": opts.WorkDir,
+		"Env":        opts.Env,
+		"HostConfig": hostConfig,
+	}
+	resp, err := d.do(ctx, http.MethodPost, "/containers/create", create)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return apiError(resp)
+	}
+	var created struct {
+		Id string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return err
+	}
+	defer d.remove(created.Id)
+	resp, err = d.do(ctx, http.MethodPost, "/containers/"+created.Id+"/start", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		return apiError(resp)
+	}
+	resp, err = d.do(ctx, http.MethodPost, "/containers/"+created.Id+"/wait", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	var wait struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&wait); err != nil {
+		return err
+	}
+	if err := d.logs(ctx, created.Id, opts.Stdout, opts.Stderr); err != nil {
+		logger.Log.Errorf("Unable to read logs of container %s: %v", created.Id, err)
+	}
+	if wait.StatusCode != 0 {
+		return &ExitError{Code: wait.StatusCode}
+	}
+	return nil
+}
+
+// logs Читает мультиплексированный поток логов контейнера и раскладывает его по stdout и stderr
+func (d *Docker) logs(ctx context.Context, id string, stdout io.Writer, stderr io.Writer) error {
+	resp, err := d.do(ctx, http.MethodGet, "/containers/"+id+"/logs?stdout=1&stderr=1", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, resp.Body, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// remove Принудительно удаляет контейнер. Выполняется и после отмены контекста сборки, чтобы не оставлять запущенных контейнеров.
+func (d *Docker) remove(id string) {
+	resp, err := d.do(context.Background(), http.MethodDelete, "/containers/"+id+"?force=1&v=1", nil)
+	if err != nil {
+		logger.Log.Errorf("Unable to remove container %s: %v", id, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// Pull Загружает образ, ошибки загрузки приходят в потоке прогресса.
+// Тег или дайджест передается отдельно: без tag Docker Engine API загружает все теги репозитория.
+func (d *Docker) Pull(ctx context.Context, image string) error {
+	logger.Log.Debugf("Pull image %s", image)
+	resp, err := d.do(ctx, http.MethodPost, "/images/create?"+pullQuery(image).Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("docker pull %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// pullQuery Возвращает параметры загрузки образа: репозиторий с реестром и тег (по умолчанию latest) или дайджест
+func pullQuery(image string) url.Values {
+	ref := dockerfile.ParseImageRef(image)
+	tag := ref.Tag
+	if ref.Digest != "" {
+		tag = ref.Digest
+	} else if tag == "" {
+		tag = "latest"
+	}
+	ref.Tag, ref.Digest = "", ""
+	return url.Values{"fromImage": {ref.String()}, "tag": {tag}}
+}
+
+// imagePath Экранирует части имени образа для пути запроса, сохраняя разделители "/" репозитория
+func imagePath(image string) string {
+	parts := strings.Split(image, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Inspect Возвращает сведения об образе
+func (d *Docker) Inspect(ctx context.Context, image string) (*ImageInfo, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/images/"+imagePath(image)+"/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrImageNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+	var info struct {
+		Id          string   `json:"Id"`
+		RepoTags    []string `json:"RepoTags"`
+		RepoDigests []string `json:"RepoDigests"`
+		Size        int64    `json:"Size"`
+		Created     string   `json:"Created"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &ImageInfo{ID: info.Id, RepoTags: info.RepoTags, RepoDigests: info.RepoDigests, Size: info.Size, Created: info.Created}, nil
+}
+
+// Save Выгружает образ в tar архив
+func (d *Docker) Save(ctx context.Context, image string, file string) error {
+	resp, err := d.do(ctx, http.MethodGet, "/images/get?names="+url.QueryEscape(image), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Неполный архив образа не должен остаться в каталоге сервиса
+		os.Remove(file)
+	}
+	return err
+}
+
+// Load Загружает образ из tar архива, ошибки загрузки приходят в потоке ответа
+func (d *Docker) Load(ctx context.Context, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://docker/images/load?quiet=1", f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	logger.Log.Tracef("Docker api request: POST /images/load, file %s", file)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("docker load %s: %s", file, msg.Error)
+		}
+	}
+}
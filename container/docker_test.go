@@ -0,0 +1,92 @@
+package container
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// dockerServer Запускает обработчик Docker Engine API на unix сокете и возвращает клиент для него
+func dockerServer(t *testing.T, h http.HandlerFunc) *Docker {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return NewDocker(socket)
+}
+
+func TestDockerPull(t *testing.T) {
+	tests := []struct {
+		image string
+		from  string
+		tag   string
+	}{
+		{"gradle", "gradle", "latest"},
+		{"gradle:7.4.1-jdk11", "gradle", "7.4.1-jdk11"},
+		{"registry.local:5000/maven:3.8.6-openjdk-11", "registry.local:5000/maven", "3.8.6-openjdk-11"},
+		{"localhost/tools/jdk", "localhost/tools/jdk", "latest"},
+		{"gradle:7.4@sha256:0123abcd", "gradle", "sha256:0123abcd"},
+	}
+	for _, tt := range tests {
+		var method, from, tag string
+		d := dockerServer(t, func(w http.ResponseWriter, r *http.Request) {
+			method, from, tag = r.Method, r.URL.Query().Get("fromImage"), r.URL.Query().Get("tag")
+			w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"status":"Done"}`))
+		})
+		if err := d.Pull(context.Background(), tt.image); err != nil {
+			t.Errorf("Pull(%s): %v", tt.image, err)
+		}
+		if method != http.MethodPost || from != tt.from || tag != tt.tag {
+			t.Errorf("Pull(%s) sent %s fromImage=%q tag=%q, want fromImage=%q tag=%q", tt.image, method, from, tag, tt.from, tt.tag)
+		}
+	}
+}
+
+func TestDockerPullError(t *testing.T) {
+	d := dockerServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"error":"manifest unknown"}`))
+	})
+	if err := d.Pull(context.Background(), "gradle:0.0"); err == nil {
+		t.Error("expected error from progress stream")
+	}
+}
+
+func TestDockerInspect(t *testing.T) {
+	tests := map[string]string{
+		"gradle:7.4.1-jdk11":                         "/images/gradle:7.4.1-jdk11/json",
+		"registry.local:5000/maven:3.8.6-openjdk-11": "/images/registry.local:5000/maven:3.8.6-openjdk-11/json",
+		"gradle@sha256:0123abcd":                     "/images/gradle@sha256:0123abcd/json",
+		"tools/jdk?x#y":                              "/images/tools/jdk%3Fx%23y/json",
+	}
+	for image, want := range tests {
+		var path string
+		d := dockerServer(t, func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.EscapedPath()
+			w.Write([]byte(`{"Id":"sha256:1","RepoTags":["gradle:7.4.1-jdk11"],"Size":10}`))
+		})
+		info, err := d.Inspect(context.Background(), image)
+		if err != nil {
+			t.Errorf("Inspect(%s): %v", image, err)
+			continue
+		}
+		if path != want {
+			t.Errorf("Inspect(%s) requested %s, want %s", image, path, want)
+		}
+		if info.ID != "sha256:1" || info.Size != 10 {
+			t.Errorf("Inspect(%s) = %+v", image, info)
+		}
+	}
+	d := dockerServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+	})
+	if _, err := d.Inspect(context.Background(), "missing"); err != ErrImageNotFound {
+		t.Errorf("err = %v, want ErrImageNotFound", err)
+	}
+}
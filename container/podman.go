@@ -0,0 +1,139 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"sources/logger"
+	"strings"
+	"time"
+)
+
+// Podman Рантайм rootless Podman, команды выполняются через podman cli без shell
+type Podman struct {
+	binary string
+}
+
+// NewPodman Создает рантайм Podman, binary - путь к podman (по умолчанию ищется в PATH)
+func NewPodman(binary string) *Podman {
+	if binary == "" {
+		binary = "podman"
+	}
+	return &Podman{binary: binary}
+}
+
+func (p *Podman) command(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, p.binary, args...)
+	var errb bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &errb
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, &errb)
+	}
+	logger.Log.Tracef("Podman command: %v", cmd)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if strings.Contains(strings.ToLower(errb.String()), "image not known") || strings.Contains(strings.ToLower(errb.String()), "no such image") {
+			return ErrImageNotFound
+		}
+		return fmt.Errorf("%w: %s", &ExitError{Code: exitErr.ExitCode()}, strings.TrimSpace(errb.String()))
+	}
+	return err
+}
+
+// Run Запускает контейнер с --rm. Для rootless режима используется --userns=keep-id,
+// чтобы файлы в смонтированных каталогах принадлежали пользователю хоста.
+// Контейнеру задается имя, чтобы при отмене ctx удалить его через podman rm -f: завершение самого podman run контейнер не останавливает.
+func (p *Podman) Run(ctx context.Context, opts RunOptions) error {
+	name := fmt.Sprintf("services-revision-tool-%d-%d", os.Getpid(), rand.Int63())
+	args := []string{"run", "--rm", "--userns=keep-id", "--name", name}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	if opts.WorkDir != "" {
+		args = append(args, "--workdir", opts.WorkDir)
+	}
+	if opts.Network != "" {
+		args = append(args, "--network", opts.Network)
+	}
+	for _, e := range opts.Env {
+		args = append(args, "--env", e)
+	}
+	for _, m := range opts.Mounts {
+		v := m.Source + ":" + m.Target
+		if m.ReadOnly {
+			v = v + ":ro"
+		}
+		args = append(args, "--volume", v)
+	}
+	args = append(args, opts.Image)
+	args = append(args, opts.Cmd...)
+	cmd := exec.CommandContext(ctx, p.binary, args...)
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	cmd.Cancel = func() error {
+		logger.Log.Debugf("Remove podman container %s", name)
+		rm := exec.Command(p.binary, "rm", "-f", "--time", "0", name)
+		if err := rm.Run(); err != nil {
+			logger.Log.Errorf("Unable to remove podman container %s: %v", name, err)
+		}
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 10 * time.Second
+	logger.Log.Tracef("Podman command: %v", cmd)
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// Pull Загружает образ из реестра
+func (p *Podman) Pull(ctx context.Context, image string) error {
+	logger.Log.Debugf("Pull image %s", image)
+	return p.command(ctx, nil, nil, "pull", image)
+}
+
+// Inspect Возвращает сведения об образе
+func (p *Podman) Inspect(ctx context.Context, image string) (*ImageInfo, error) {
+	var out bytes.Buffer
+	if err := p.command(ctx, &out, nil, "image", "inspect", image); err != nil {
+		return nil, err
+	}
+	var infos []struct {
+		Id          string   `json:"Id"`
+		RepoTags    []string `json:"RepoTags"`
+		RepoDigests []string `json:"RepoDigests"`
+		Size        int64    `json:"Size"`
+		Created     string   `json:"Created"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &infos); err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, ErrImageNotFound
+	}
+	i := infos[0]
+	return &ImageInfo{ID: i.Id, RepoTags: i.RepoTags, RepoDigests: i.RepoDigests, Size: i.Size, Created: i.Created}, nil
+}
+
+// Save Выгружает образ в tar архив в формате docker-archive, совместимом с docker image load
+func (p *Podman) Save(ctx context.Context, image string, file string) error {
+	return p.command(ctx, nil, nil, "image", "save", "--format", "docker-archive", "-o", file, image)
+}
+
+// Load Загружает образ из tar архива
+func (p *Podman) Load(ctx context.Context, file string) error {
+	return p.command(ctx, nil, nil, "load", "-i", file)
+}
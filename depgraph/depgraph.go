@@ -0,0 +1,268 @@
+// depgraph Пакет для получения разрешенного графа зависимостей gradle: init скрипт с задачей выгрузки графа в JSON и разбор результата
+package depgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sources/logger"
+	"strings"
+)
+
+// TaskName Имя задачи gradle, которую добавляет init скрипт
+const TaskName = "servicesRevisionToolDependencyGraph"
+
+// DirProperty Системное свойство с каталогом, куда задача сохраняет граф (по файлу на проект)
+const DirProperty = "servicesRevisionTool.graphDir"
+
+// script Init скрипт gradle, добавляющий во все проекты задачу выгрузки разрешенного графа зависимостей.
+// Для каждой разрешаемой конфигурации сохраняются ребра графа: откуда, запрошенная и выбранная версия, причина выбора.
+// Скрипт на Groovy, поэтому подходит и для проектов на Kotlin DSL.
+const script = `// Сгенерировано services-revision-tool.
+// Добавляет задачу ` + TaskName + `, которая сохраняет разрешенный граф зависимостей проекта в JSON.
+import groovy.json.JsonOutput
+import org.gradle.api.artifacts.component.ModuleComponentIdentifier
+import org.gradle.api.artifacts.component.ModuleComponentSelector
+import org.gradle.api.artifacts.component.ProjectComponentIdentifier
+import org.gradle.api.artifacts.result.ResolvedDependencyResult
+import org.gradle.api.artifacts.result.UnresolvedDependencyResult
+import org.gradle.util.GradleVersion
+
+def outDir = System.getProperty('` + DirProperty + `')
+
+def component = { id ->
+    if (id instanceof ModuleComponentIdentifier) {
+        return [group: id.group, module: id.module, version: id.version]
+    }
+    if (id instanceof ProjectComponentIdentifier) {
+        return [project: id.projectPath]
+    }
+    return [display: id.displayName]
+}
+
+def selector = { r ->
+    if (r instanceof ModuleComponentSelector) {
+        return [group: r.group, module: r.module, version: r.version]
+    }
+    return [display: r.displayName]
+}
+
+def graphTask = { Project p, Task task ->
+    if (task.respondsTo('notCompatibleWithConfigurationCache', String)) {
+        task.notCompatibleWithConfigurationCache('Resolves configurations at execution time')
+    }
+    task.doLast {
+        def configurations = []
+        p.configurations.findAll { it.canBeResolved }.each { c ->
+            try {
+                def edges = []
+                c.incoming.resolutionResult.allDependencies.each { d ->
+                    def edge = [from: component(d.from.id), requested: selector(d.requested)]
+                    if (d instanceof ResolvedDependencyResult) {
+                        edge.selected = component(d.selected.id)
+                        edge.reason = d.selected.selectionReason.toString()
+                    } else if (d instanceof UnresolvedDependencyResult) {
+                        edge.unresolved = String.valueOf(d.failure?.message)
+                    }
+                    edges << edge
+                }
+                configurations << [name: c.name, edges: edges]
+            } catch (Exception e) {
+                configurations << [name: c.name, error: String.valueOf(e.message)]
+            }
+        }
+        def dir = new File(outDir)
+        dir.mkdirs()
+        def name = p.path == ':' ? 'root' : p.path.substring(1).replace(':', '_')
+        new File(dir, name + '.json').text = JsonOutput.toJson([project: p.path, configurations: configurations])
+    }
+}
+
+allprojects { p ->
+    if (GradleVersion.current() >= GradleVersion.version('4.9')) {
+        p.tasks.register('` + TaskName + `') { graphTask(p, it) }
+    } else {
+        graphTask(p, p.tasks.create('` + TaskName + `'))
+    }
+}
+`
+
+// Component Компонент графа: внешний модуль (group, module, version), проект сборки или иное описание
+type Component struct {
+	Group   string `json:"group,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	Project string `json:"project,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Edge Ребро графа: зависимость from от запрошенной версии requested, разрешенная в selected
+type Edge struct {
+	From       Component  `json:"from"`
+	Requested  Component  `json:"requested"`
+	Selected   *Component `json:"selected,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	Unresolved string     `json:"unresolved,omitempty"`
+}
+
+// Configuration Граф зависимостей одной конфигурации проекта. Error заполнен, если конфигурацию не удалось разрешить.
+type Configuration struct {
+	Name  string `json:"name"`
+	Edges []Edge `json:"edges"`
+	Error string `json:"error,omitempty"`
+}
+
+// Project Графы зависимостей конфигураций проекта gradle
+type Project struct {
+	Project        string          `json:"project"`
+	Configurations []Configuration `json:"configurations"`
+}
+
+// Graph Разрешенный граф зависимостей сборки по всем проектам
+type Graph struct {
+	Projects []Project `json:"projects"`
+}
+
+// Conflict Зависимость, для которой gradle выбрал версию, отличную от запрошенной
+type Conflict struct {
+	Project       string
+	Configuration string
+	Requested     Component
+	Selected      Component
+	Reason        string
+}
+
+// String Возвращает строковое представление компонента: group:module:version, проект или описание
+func (c Component) String() string {
+	if c.Module != "" {
+		return c.Group + ":" + c.Module + ":" + c.Version
+	}
+	if c.Project != "" {
+		return "project " + c.Project
+	}
+	return c.Display
+}
+
+// External Проверяет, что компонент является внешним модулем, а не проектом сборки
+func (c Component) External() bool {
+	return c.Module != ""
+}
+
+// CreateScript Создает init скрипт с задачей выгрузки графа в файле file
+func CreateScript(file string) error {
+	logger.Log.Debugf("Create gradle dependency graph init script: %s", file)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(script), 0644)
+}
+
+// Load Читает графы проектов, сохраненные задачей в каталог dir
+func Load(dir string) (*Graph, error) {
+	files, err := filepath.Glob(dir + "/*.json")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no dependency graph files found in %s", dir)
+	}
+	sort.Strings(files)
+	g := &Graph{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var p Project
+		if err := json.Unmarshal(b, &p); err != nil {
+			return nil, fmt.Errorf("unable to parse dependency graph %s: %w", f, err)
+		}
+		g.Projects = append(g.Projects, p)
+	}
+	return g, nil
+}
+
+// ProductConfiguration Проверяет, что конфигурация - classpath компиляции или выполнения продукта: compileClasspath,
+// runtimeClasspath или такие же конфигурации вариантов (releaseRuntimeClasspath). Конфигурации тестов (testRuntimeClasspath,
+// integrationTestCompileClasspath, debugAndroidTestRuntimeClasspath), обработчиков аннотаций и прочие не учитываются.
+func ProductConfiguration(name string) bool {
+	if name == "compileClasspath" || name == "runtimeClasspath" {
+		return true
+	}
+	for _, suffix := range []string{"CompileClasspath", "RuntimeClasspath"} {
+		if variant, ok := strings.CutSuffix(name, suffix); ok {
+			return !strings.HasPrefix(variant, "test") && !strings.Contains(variant, "Test")
+		}
+	}
+	return false
+}
+
+// Modules Возвращает выбранные gradle внешние модули конфигураций продукта всех проектов без повторов, в порядке сортировки
+func (g *Graph) Modules() []Component {
+	seen := make(map[string]bool)
+	var result []Component
+	for _, p := range g.Projects {
+		for _, c := range p.Configurations {
+			if !ProductConfiguration(c.Name) {
+				continue
+			}
+			for _, e := range c.Edges {
+				if e.Selected == nil || !e.Selected.External() || seen[e.Selected.String()] {
+					continue
+				}
+				seen[e.Selected.String()] = true
+				result = append(result, *e.Selected)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+	return result
+}
+
+// Conflicts Возвращает ребра конфигураций продукта, в которых выбранная версия внешнего модуля отличается от запрошенной
+func (g *Graph) Conflicts() []Conflict {
+	var result []Conflict
+	for _, p := range g.Projects {
+		for _, c := range p.Configurations {
+			if !ProductConfiguration(c.Name) {
+				continue
+			}
+			for _, e := range c.Edges {
+				// Версия без указания (например из BOM) конфликтом не считается, она есть в полном графе
+				if e.Selected == nil || !e.Selected.External() || !e.Requested.External() || e.Requested.Version == "" {
+					continue
+				}
+				if e.Requested.Version == e.Selected.Version && e.Requested.Group == e.Selected.Group && e.Requested.Module == e.Selected.Module {
+					continue
+				}
+				result = append(result, Conflict{Project: p.Project, Configuration: c.Name, Requested: e.Requested, Selected: *e.Selected, Reason: e.Reason})
+			}
+		}
+	}
+	return result
+}
+
+// Unresolved Возвращает неразрешенные зависимости и ошибки разрешения конфигураций продукта
+// в формате "проект конфигурация: зависимость: ошибка"
+func (g *Graph) Unresolved() []string {
+	var result []string
+	for _, p := range g.Projects {
+		for _, c := range p.Configurations {
+			if !ProductConfiguration(c.Name) {
+				continue
+			}
+			if c.Error != "" {
+				result = append(result, p.Project+" "+c.Name+": "+c.Error)
+			}
+			for _, e := range c.Edges {
+				if e.Unresolved != "" {
+					result = append(result, p.Project+" "+c.Name+": "+e.Requested.String()+": "+e.Unresolved)
+				}
+			}
+		}
+	}
+	return result
+}
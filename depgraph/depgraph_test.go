@@ -0,0 +1,134 @@
+package depgraph
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// rootJson Граф корневого проекта в формате задачи TaskName: конфликт версий, версия из BOM,
+// зависимость от проекта сборки, неразрешенная зависимость, конфигурации с ошибкой, тестов и обработчиков аннотаций
+const rootJson = `{"project":":","configurations":[
+{"name":"compileClasspath","edges":[
+ {"from":{"project":":"},"requested":{"group":"com.google.guava","module":"guava","version":"30.0-jre"},
+  "selected":{"group":"com.google.guava","module":"guava","version":"31.1-jre"},"reason":"conflict resolution: between versions 31.1-jre and 30.0-jre"},
+ {"from":{"group":"com.google.guava","module":"guava","version":"31.1-jre"},"requested":{"group":"com.google.guava","module":"failureaccess","version":"1.0.1"},
+  "selected":{"group":"com.google.guava","module":"failureaccess","version":"1.0.1"},"reason":"requested"},
+ {"from":{"project":":"},"requested":{"group":"org.slf4j","module":"slf4j-api"},
+  "selected":{"group":"org.slf4j","module":"slf4j-api","version":"2.0.7"},"reason":"requested"},
+ {"from":{"project":":"},"requested":{"display":"project :lib"},"selected":{"project":":lib"},"reason":"requested"},
+ {"from":{"project":":"},"requested":{"group":"org.example","module":"missing","version":"1.0"},"unresolved":"Could not find org.example:missing:1.0."}
+]},
+{"name":"testCompileClasspath","edges":[
+ {"from":{"project":":"},"requested":{"group":"junit","module":"junit","version":"4.12"},
+  "selected":{"group":"junit","module":"junit","version":"4.13.2"},"reason":"conflict resolution: between versions 4.13.2 and 4.12"},
+ {"from":{"project":":"},"requested":{"group":"org.example","module":"test-missing","version":"1.0"},"unresolved":"Could not find org.example:test-missing:1.0."}
+]},
+{"name":"annotationProcessor","edges":[
+ {"from":{"project":":"},"requested":{"group":"org.projectlombok","module":"lombok","version":"1.18.30"},
+  "selected":{"group":"org.projectlombok","module":"lombok","version":"1.18.30"},"reason":"requested"}
+]},
+{"name":"legacy","error":"Resolving dependency configuration 'legacy' is not allowed"},
+{"name":"releaseRuntimeClasspath","error":"Could not resolve all dependencies for configuration ':releaseRuntimeClasspath'."}
+]}`
+
+// libJson Граф подпроекта :lib с модулем, который уже есть в графе корневого проекта
+const libJson = `{"project":":lib","configurations":[
+{"name":"runtimeClasspath","edges":[
+ {"from":{"project":":lib"},"requested":{"group":"com.google.guava","module":"guava","version":"31.1-jre"},
+  "selected":{"group":"com.google.guava","module":"guava","version":"31.1-jre"},"reason":"requested"}
+]}]}`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "root.json"), []byte(rootJson), 0644)
+	os.WriteFile(filepath.Join(dir, "lib.json"), []byte(libJson), 0644)
+	os.WriteFile(filepath.Join(dir, "build.log"), []byte("not a graph"), 0644)
+	g, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// Файлы читаются в порядке сортировки имен
+	if len(g.Projects) != 2 || g.Projects[0].Project != ":lib" || g.Projects[1].Project != ":" {
+		t.Fatalf("projects = %+v", g.Projects)
+	}
+	var modules []string
+	for _, m := range g.Modules() {
+		modules = append(modules, m.String())
+	}
+	want := []string{
+		"com.google.guava:failureaccess:1.0.1",
+		"com.google.guava:guava:31.1-jre",
+		"org.slf4j:slf4j-api:2.0.7",
+	}
+	if !reflect.DeepEqual(modules, want) {
+		t.Errorf("modules = %q, want %q", modules, want)
+	}
+	conflicts := g.Conflicts()
+	if len(conflicts) != 1 {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	c := conflicts[0]
+	if c.Project != ":" || c.Configuration != "compileClasspath" || c.Requested.Version != "30.0-jre" || c.Selected.Version != "31.1-jre" {
+		t.Errorf("conflict = %+v", c)
+	}
+	// Зависимости тестов и обработчиков аннотаций не входят в список зависимостей, конфликты и ошибки разрешения
+	unresolved := []string{
+		": compileClasspath: org.example:missing:1.0: Could not find org.example:missing:1.0.",
+		": releaseRuntimeClasspath: Could not resolve all dependencies for configuration ':releaseRuntimeClasspath'.",
+	}
+	if !reflect.DeepEqual(g.Unresolved(), unresolved) {
+		t.Errorf("unresolved = %q, want %q", g.Unresolved(), unresolved)
+	}
+}
+
+func TestProductConfiguration(t *testing.T) {
+	tests := map[string]bool{
+		"compileClasspath":                 true,
+		"runtimeClasspath":                 true,
+		"releaseRuntimeClasspath":          true,
+		"debugCompileClasspath":            true,
+		"testCompileClasspath":             false,
+		"testRuntimeClasspath":             false,
+		"testFixturesRuntimeClasspath":     false,
+		"integrationTestRuntimeClasspath":  false,
+		"debugAndroidTestCompileClasspath": false,
+		"releaseUnitTestRuntimeClasspath":  false,
+		"annotationProcessor":              false,
+		"kapt":                             false,
+		"implementation":                   false,
+	}
+	for name, want := range tests {
+		if got := ProductConfiguration(name); got != want {
+			t.Errorf("ProductConfiguration(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(t.TempDir()); err == nil {
+		t.Error("expected error for directory without graph files")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "root.json"), []byte(`{"project":":","configurations":[`), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for truncated graph file")
+	}
+}
+
+func TestComponentString(t *testing.T) {
+	tests := []struct {
+		c    Component
+		want string
+	}{
+		{Component{Group: "org.slf4j", Module: "slf4j-api", Version: "2.0.7"}, "org.slf4j:slf4j-api:2.0.7"},
+		{Component{Project: ":lib"}, "project :lib"},
+		{Component{Display: "file(libs/a.jar)"}, "file(libs/a.jar)"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+// dockerfile Пакет для разбора Dockerfile: стадии сборки, ARG/ENV подстановки, перенос строк и exec форма RUN
+package dockerfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Instruction Инструкция Dockerfile с аргументами после склейки перенесенных строк
+type Instruction struct {
+	Cmd  string
+	Args string
+	Line int
+}
+
+// Stage Стадия сборки, начинается с инструкции FROM
+type Stage struct {
+	Index        int
+	Name         string
+	Image        string
+	BaseStage    *Stage
+	Instructions []Instruction
+	Env          map[string]string
+	runs         []string
+}
+
+// Dockerfile Результат разбора Dockerfile
+type Dockerfile struct {
+	Args   map[string]string
+	Stages []*Stage
+}
+
+// ImageRef Ссылка на docker образ вида [registry[:port]/]repository[:tag][@digest]
+type ImageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+var (
+	directiveRe = regexp.MustCompile(`^#\s*([a-zA-Z]+)\s*=\s*(.+?)\s*$`)
+	heredocRe   = regexp.MustCompile(`<<-?\s*["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+	varRe       = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)(?:(:[-+])([^}]*))?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+)
+
+// ParseFile Разбирает Dockerfile по пути file
+func ParseFile(file string) (*Dockerfile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse Разбирает Dockerfile: склеивает перенесенные строки, убирает комментарии, раскрывает heredoc,
+// подставляет значения ARG и ENV в FROM и RUN и раскладывает инструкции по стадиям
+func Parse(r io.Reader) (*Dockerfile, error) {
+	instructions, err := readInstructions(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dockerfile{Args: make(map[string]string)}
+	var stage *Stage
+	for _, ins := range instructions {
+		switch ins.Cmd {
+		case "FROM":
+			stage, err = d.newStage(ins)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case "ARG":
+			name, value, hasValue := splitArg(ins.Args)
+			if stage == nil {
+				if hasValue {
+					d.Args[name] = substitute(value, d.Args)
+				} else if _, ok := d.Args[name]; !ok {
+					d.Args[name] = ""
+				}
+				continue
+			}
+			if hasValue {
+				stage.Env[name] = substitute(value, stage.Env)
+			} else if v, ok := d.Args[name]; ok {
+				stage.Env[name] = v
+			}
+		case "ENV":
+			if stage == nil {
+				return nil, fmt.Errorf("line %d: ENV before FROM", ins.Line)
+			}
+			for k, v := range splitEnv(ins.Args) {
+				stage.Env[k] = substitute(v, stage.Env)
+			}
+		case "RUN":
+			if stage == nil {
+				return nil, fmt.Errorf("line %d: RUN before FROM", ins.Line)
+			}
+			stage.runs = append(stage.runs, substitute(runCommand(ins.Args), stage.Env))
+		}
+		if stage == nil {
+			return nil, fmt.Errorf("line %d: %s before FROM", ins.Line, ins.Cmd)
+		}
+		stage.Instructions = append(stage.Instructions, ins)
+	}
+	if len(d.Stages) == 0 {
+		return nil, errors.New("no FROM instruction found")
+	}
+	return d, nil
+}
+
+// Stage Возвращает стадию по имени (без учета регистра) или nil
+func (d *Dockerfile) Stage(name string) *Stage {
+	for _, s := range d.Stages {
+		if s.Name != "" && strings.EqualFold(s.Name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Runs Возвращает команды RUN стадии по порядку с подставленными ARG и ENV. Exec форма склеивается в строку.
+func (s *Stage) Runs() []string {
+	return s.runs
+}
+
+// ImageRef Возвращает разобранную ссылку на базовый образ стадии
+func (s *Stage) ImageRef() ImageRef {
+	return ParseImageRef(s.Image)
+}
+
+func (d *Dockerfile) newStage(ins Instruction) (*Stage, error) {
+	fields := strings.Fields(ins.Args)
+	var args []string
+	for _, f := range fields {
+		// Флаги FROM, например --platform, на выбор образа не влияют
+		if !strings.HasPrefix(f, "--") {
+			args = append(args, f)
+		}
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("line %d: FROM without image", ins.Line)
+	}
+	s := &Stage{
+		Index: len(d.Stages),
+		Image: substitute(args[0], d.Args),
+		Env:   make(map[string]string),
+	}
+	if len(args) >= 3 && strings.EqualFold(args[1], "as") {
+		s.Name = args[2]
+	}
+	if base := d.Stage(s.Image); base != nil {
+		s.BaseStage = base
+		for k, v := range base.Env {
+			s.Env[k] = v
+		}
+	}
+	d.Stages = append(d.Stages, s)
+	return s, nil
+}
+
+// readInstructions Читает инструкции Dockerfile с учетом директивы escape, переносов строк, комментариев и heredoc
+func readInstructions(r io.Reader) ([]Instruction, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	escape := `\`
+	var result []Instruction
+	var buf strings.Builder
+	start := 0
+	line := 0
+	directives := true
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if directives {
+			if m := directiveRe.FindStringSubmatch(trimmed); m != nil {
+				if strings.EqualFold(m[1], "escape") {
+					escape = m[2]
+				}
+				continue
+			}
+			directives = false
+		}
+		// Пустые строки и комментарии пропускаются и внутри перенесенной инструкции, как в docker
+		if strings.HasPrefix(trimmed, "#") || trimmed == "" {
+			continue
+		}
+		if buf.Len() == 0 {
+			start = line
+		}
+		if strings.HasSuffix(strings.TrimRightFunc(text, isSpace), escape) {
+			t := strings.TrimRightFunc(text, isSpace)
+			buf.WriteString(strings.TrimSuffix(t, escape))
+			buf.WriteString(" ")
+			continue
+		}
+		buf.WriteString(text)
+		full := strings.TrimSpace(buf.String())
+		buf.Reset()
+		cmd, args, _ := strings.Cut(full, " ")
+		ins := Instruction{Cmd: strings.ToUpper(cmd), Args: strings.TrimSpace(args), Line: start}
+		if m := heredocRe.FindStringSubmatch(ins.Args); m != nil && ins.Cmd == "RUN" {
+			var body []string
+			for scanner.Scan() {
+				line++
+				if strings.TrimSpace(scanner.Text()) == m[1] {
+					break
+				}
+				body = append(body, scanner.Text())
+			}
+			ins.Args = strings.TrimSpace(strings.Replace(ins.Args, m[0], "", 1) + "\n" + strings.Join(body, "\n"))
+		}
+		result = append(result, ins)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		full := strings.TrimSpace(buf.String())
+		cmd, args, _ := strings.Cut(full, " ")
+		result = append(result, Instruction{Cmd: strings.ToUpper(cmd), Args: strings.TrimSpace(args), Line: start})
+	}
+	return result, nil
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r'
+}
+
+// runCommand Возвращает команду RUN в виде строки shell, exec форма ["a", "b"] склеивается через пробел
+func runCommand(args string) string {
+	for strings.HasPrefix(args, "--") {
+		// Флаги RUN (--mount, --network и т.п.)
+		_, rest, _ := strings.Cut(args, " ")
+		args = strings.TrimSpace(rest)
+	}
+	if strings.HasPrefix(args, "[") {
+		var exec []string
+		if err := json.Unmarshal([]byte(args), &exec); err == nil {
+			for i, a := range exec {
+				if strings.ContainsAny(a, " \t\"'") {
+					exec[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+				}
+			}
+			return strings.Join(exec, " ")
+		}
+	}
+	return args
+}
+
+func splitArg(args string) (name string, value string, hasValue bool) {
+	name, value, hasValue = strings.Cut(strings.TrimSpace(args), "=")
+	return strings.TrimSpace(name), unquote(strings.TrimSpace(value)), hasValue
+}
+
+// splitEnv Разбирает ENV в формах "KEY=VALUE KEY2=VALUE2" и "KEY VALUE"
+func splitEnv(args string) map[string]string {
+	env := make(map[string]string)
+	args = strings.TrimSpace(args)
+	key, rest, _ := strings.Cut(args, " ")
+	if !strings.Contains(key, "=") {
+		env[key] = unquote(strings.TrimSpace(rest))
+		return env
+	}
+	for _, pair := range splitWords(args) {
+		k, v, ok := strings.Cut(pair, "=")
+		if ok {
+			env[k] = unquote(v)
+		}
+	}
+	return env
+}
+
+// splitWords Делит строку по пробелам с учетом кавычек
+func splitWords(s string) []string {
+	var words []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if cur.Len() > 0 {
+				words = append(words, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		words = append(words, cur.String())
+	}
+	return words
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// substitute Подставляет в строку значения переменных $VAR, ${VAR}, ${VAR:-default} и ${VAR:+value}.
+// Неизвестные переменные без значения по умолчанию остаются как есть, их раскроет shell в контейнере.
+func substitute(s string, vars map[string]string) string {
+	return varRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := varRe.FindStringSubmatch(m)
+		name := sub[1] + sub[4]
+		v, ok := vars[name]
+		switch sub[2] {
+		case ":-":
+			if !ok || v == "" {
+				return substitute(sub[3], vars)
+			}
+		case ":+":
+			if ok && v != "" {
+				return substitute(sub[3], vars)
+			}
+			return ""
+		}
+		if !ok {
+			return m
+		}
+		return v
+	})
+}
+
+// ParseImageRef Разбирает ссылку на образ. Первый компонент пути считается адресом реестра,
+// если содержит точку или порт либо равен localhost.
+func ParseImageRef(image string) ImageRef {
+	var ref ImageRef
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry = first
+			name = name[i+1:]
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+	ref.Repository = name
+	return ref
+}
+
+// Name Возвращает имя образа без реестра, например gradle для library/gradle
+func (r ImageRef) Name() string {
+	return r.Repository[strings.LastIndex(r.Repository, "/")+1:]
+}
+
+// String Возвращает ссылку на образ в исходном виде
+func (r ImageRef) String() string {
+	s := r.Repository
+	if r.Registry != "" {
+		s = r.Registry + "/" + s
+	}
+	if r.Tag != "" {
+		s = s + ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s = s + "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package dockerfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		stage string
+		image string
+		runs  []string
+	}{
+		{
+			name: "ARG in FROM",
+			src: `ARG REGISTRY=registry.local:5000
+ARG GRADLE_VERSION=7.4.1
+FROM ${REGISTRY}/gradle:${GRADLE_VERSION}-jdk11 AS build
+RUN gradle build`,
+			stage: "build",
+			image: "registry.local:5000/gradle:7.4.1-jdk11",
+			runs:  []string{"gradle build"},
+		},
+		{
+			name: "ARG default without value and FROM flags",
+			src: `ARG TAG
+FROM --platform=linux/amd64 gradle:${TAG:-7.6} as Build
+RUN gradle build`,
+			stage: "build",
+			image: "gradle:7.6",
+			runs:  []string{"gradle build"},
+		},
+		{
+			name: "line continuation with empty and comment lines",
+			src: `FROM gradle:7.4.1-jdk11 AS build
+RUN gradle clean \
+    # собираем shadowJar
+
+    shadowJar \
+    -x test
+RUN echo done`,
+			stage: "build",
+			image: "gradle:7.4.1-jdk11",
+			runs:  []string{"gradle clean      shadowJar      -x test", "echo done"},
+		},
+		{
+			name: "exec form RUN",
+			src: `FROM maven:3.8.6-openjdk-11 AS build
+RUN ["mvn", "-B", "package", "-Dname=a b"]`,
+			stage: "build",
+			image: "maven:3.8.6-openjdk-11",
+			runs:  []string{"mvn -B package '-Dname=a b'"},
+		},
+		{
+			name: "multiple RUN steps with ENV and ARG substitution",
+			src: `FROM gradle:7.4.1-jdk11 AS build
+ARG TASK=shadowJar
+ENV OPTS="-x test" HOME_DIR=/home/gradle
+WORKDIR $HOME_DIR
+RUN ./gradlew clean
+RUN --mount=type=cache,target=/root/.gradle ./gradlew ${TASK} $OPTS`,
+			stage: "build",
+			image: "gradle:7.4.1-jdk11",
+			runs:  []string{"./gradlew clean", "./gradlew shadowJar -x test"},
+		},
+		{
+			name: "escape directive",
+			src: "# escape=`\n" +
+				"FROM mcr.microsoft.com/windows/servercore:ltsc2022 AS build\n" +
+				"RUN mvn clean `\n" +
+				"    package\n",
+			stage: "build",
+			image: "mcr.microsoft.com/windows/servercore:ltsc2022",
+			runs:  []string{"mvn clean      package"},
+		},
+		{
+			name: "stage built from another stage",
+			src: `FROM gradle:7.4.1-jdk11 AS base
+ENV OPTS=--offline
+FROM base AS gradle_build
+RUN gradle build $OPTS`,
+			stage: "gradle_build",
+			image: "base",
+			runs:  []string{"gradle build --offline"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Parse(strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			s := d.Stage(tt.stage)
+			if s == nil {
+				t.Fatalf("stage %s not found", tt.stage)
+			}
+			if s.Image != tt.image {
+				t.Errorf("image = %q, want %q", s.Image, tt.image)
+			}
+			if !reflect.DeepEqual(s.Runs(), tt.runs) {
+				t.Errorf("runs = %q, want %q", s.Runs(), tt.runs)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{"", "RUN gradle build", "ARG A=1\nENV B=2"} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Parse(%q): expected error", src)
+		}
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image string
+		want  ImageRef
+	}{
+		{"gradle:7.4.1-jdk11", ImageRef{Repository: "gradle", Tag: "7.4.1-jdk11"}},
+		{"library/gradle", ImageRef{Repository: "library/gradle"}},
+		{"registry.local:5000/tools/gradle:7.4.1", ImageRef{Registry: "registry.local:5000", Repository: "tools/gradle", Tag: "7.4.1"}},
+		{"localhost/maven:3", ImageRef{Registry: "localhost", Repository: "maven", Tag: "3"}},
+		{"nexus:8443/maven@sha256:abc", ImageRef{Registry: "nexus:8443", Repository: "maven", Digest: "sha256:abc"}},
+	}
+	for _, tt := range tests {
+		got := ParseImageRef(tt.image)
+		if got != tt.want {
+			t.Errorf("ParseImageRef(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+		if got.String() != tt.image {
+			t.Errorf("String() = %q, want %q", got.String(), tt.image)
+		}
+	}
+	if name := ParseImageRef("registry.local:5000/tools/gradle:7").Name(); name != "gradle" {
+		t.Errorf("Name() = %q, want gradle", name)
+	}
+}
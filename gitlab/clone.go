@@ -0,0 +1,213 @@
+package gitlab_helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sources/logger"
+	"strconv"
+	"strings"
+)
+
+// CloneOptions Параметры клонирования: Token передается заголовком авторизации только хосту репозитория,
+// SkipTLS отключает проверку сертификата
+type CloneOptions struct {
+	Token   string
+	SkipTLS bool
+}
+
+// Submodule Подмодуль репозитория: путь в дереве исходных кодов, url и коммит, зафиксированный в родительском репозитории
+type Submodule struct {
+	Path string `json:"path"`
+	Url  string `json:"url"`
+	Sha  string `json:"sha"`
+}
+
+// fallbackDepths Глубина истории ref, которая загружается, если сервер не отдает коммит по SHA. Обычно коммит
+// на вершине ref, иначе история углубляется, пока коммит не будет получен
+var fallbackDepths = []int{1, 100, 1000}
+
+// CloneCommit Клонирует репозиторий repoURL в каталог dir на коммите sha без истории, инициализирует подмодули рекурсивно
+// на зафиксированных коммитах, получает объекты Git LFS и удаляет служебные каталоги .git. Если сервер не отдает коммит
+// по SHA, клонируется ref с ограниченной историей. Возвращает подмодули.
+func CloneCommit(ctx context.Context, repoURL string, ref string, sha string, dir string, opts CloneOptions) ([]Submodule, error) {
+	env := cloneEnv(repoURL, opts)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	logger.Log.Tracef("Clone %s at commit %s to %s", repoURL, sha, dir)
+	steps := [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", repoURL},
+	}
+	for _, args := range steps {
+		if _, err := runGit(ctx, dir, env, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := fetchCommit(ctx, dir, env, ref, sha); err != nil {
+		return nil, err
+	}
+	if _, err := runGit(ctx, dir, env, "checkout", "-q", "--detach", sha); err != nil {
+		return nil, err
+	}
+	if _, err := runGit(ctx, dir, env, "submodule", "update", "-q", "--init", "--recursive", "--depth", "1"); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Зафиксированный коммит подмодуля может быть не на вершине ветки, тогда нужна история
+		logger.Log.Debugf("Unable to update submodules without history, retrying with full history: %v", err)
+		if _, err := runGit(ctx, dir, env, "submodule", "update", "-q", "--init", "--recursive"); err != nil {
+			return nil, err
+		}
+	}
+	out, err := runGit(ctx, dir, env, "submodule", "foreach", "--quiet", "--recursive",
+		`printf '%s\t%s\t%s\n' "$displaypath" "$sha1" "$(git config --get remote.origin.url)"`)
+	if err != nil {
+		return nil, err
+	}
+	var submodules []Submodule
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 3 {
+			submodules = append(submodules, Submodule{Path: fields[0], Sha: fields[1], Url: fields[2]})
+		}
+	}
+	if usesLFS(dir) {
+		logger.Log.Debugf("Repository %s uses Git LFS, fetching objects", repoURL)
+		if _, err := runGit(ctx, dir, env, "lfs", "version"); err != nil {
+			return nil, fmt.Errorf("repository %s uses Git LFS, git-lfs is required: %w", repoURL, err)
+		}
+		if _, err := runGit(ctx, dir, env, "lfs", "pull"); err != nil {
+			return nil, err
+		}
+		if _, err := runGit(ctx, dir, env, "submodule", "foreach", "--quiet", "--recursive", "git lfs pull"); err != nil {
+			return nil, err
+		}
+	}
+	return submodules, removeGitDirs(dir)
+}
+
+// fetchCommit Загружает в репозиторий dir коммит sha без истории. Если сервер не отдает коммит по SHA,
+// загружается ref с глубиной истории из fallbackDepths, пока коммит не будет получен.
+func fetchCommit(ctx context.Context, dir string, env []string, ref string, sha string) error {
+	_, err := runGit(ctx, dir, env, "fetch", "-q", "--depth", "1", "origin", sha)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	logger.Log.Debugf("Unable to fetch commit %s by SHA, fetching ref %s: %v", sha, ref, err)
+	for _, depth := range fallbackDepths {
+		if _, err := runGit(ctx, dir, env, "fetch", "-q", "--depth", strconv.Itoa(depth), "origin", ref); err != nil {
+			return err
+		}
+		if _, err := runGit(ctx, dir, env, "cat-file", "-e", sha+"^{commit}"); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("commit %s not found in last %d commits of %s", sha, fallbackDepths[len(fallbackDepths)-1], ref)
+}
+
+// cloneEnv Возвращает окружение git: без интерактивных запросов, без загрузки LFS при checkout (объекты получаются
+// отдельно), с заголовком авторизации для хоста репозитория через GIT_CONFIG_*, чтобы токен не попал в аргументы процесса.
+// Заголовок задается только для http(s) url, для ssh (в том числе git@host:group/project.git) используются ключи ssh.
+func cloneEnv(repoURL string, opts CloneOptions) []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0", "GIT_LFS_SKIP_SMUDGE=1"}
+	var config [][2]string
+	if opts.Token != "" {
+		if host, ok := httpHost(repoURL); ok {
+			auth := base64.StdEncoding.EncodeToString([]byte("oauth2:" + opts.Token))
+			config = append(config, [2]string{"http." + host + "/.extraHeader", "Authorization: Basic " + auth})
+		} else {
+			logger.Log.Debugf("Repository url %s is not http(s), token is not used", repoURL)
+		}
+	}
+	if opts.SkipTLS {
+		config = append(config, [2]string{"http.sslVerify", "false"})
+	}
+	env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(config)))
+	for i, c := range config {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, c[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, c[1]))
+	}
+	return env
+}
+
+// httpHost Возвращает схему и хост (https://host:port) http(s) url репозитория. Для url ssh, в том числе в формате scp,
+// и локальных путей возвращает false.
+func httpHost(repoURL string) (string, bool) {
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.Scheme + "://" + u.Host, true
+}
+
+// runGit Выполняет команду git в каталоге dir и возвращает ее вывод, в ошибку добавляется вывод stderr
+func runGit(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// usesLFS Проверяет, что в дереве исходных кодов есть .gitattributes с фильтром lfs
+func usesLFS(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if found {
+			return filepath.SkipAll
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Name() == ".gitattributes" {
+			b, err := os.ReadFile(path)
+			found = err == nil && bytes.Contains(b, []byte("filter=lfs"))
+		}
+		return nil
+	})
+	return found
+}
+
+// removeGitDirs Удаляет служебные каталоги и файлы .git репозитория и подмодулей
+func removeGitDirs(dir string) error {
+	var gitDirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			gitDirs = append(gitDirs, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range gitDirs {
+		if err := os.RemoveAll(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,33 +1,96 @@
 package gitlab_helper
 
 import (
+	"context"
 	"os/exec"
     "path/filepath"
-	"errors"
 	"crypto/tls"
-	"log"
+	"fmt"
 	"net/http"
+	"sort"
 	"sources/logger"
 	"strings"
 
 	"github.com/xanzy/go-gitlab"
 )
 
-func GetProjectTag(gitClient *gitlab.Client, projectID int) []*gitlab.Tag {
+// GetProjectTag Возвращает все теги проекта, постранично. Ошибка возвращается вместе с уже полученными тегами.
+func GetProjectTag(ctx context.Context, gitClient *gitlab.Client, projectID int) ([]*gitlab.Tag, error) {
+	var tags []*gitlab.Tag
 	orderBy := "updated"
 	sortBy := "desc"
-	logger.Log.Tracef("Get project id: %d tags", projectID)
-	tags, _, err := gitClient.Tags.ListTags(projectID, &gitlab.ListTagsOptions{
+	opt := &gitlab.ListTagsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
 		OrderBy: &orderBy,
 		Sort:    &sortBy,
-	})
+	}
+	logger.Log.Tracef("Get project id: %d tags", projectID)
+	for {
+		tagsPart, resp, err := gitClient.Tags.ListTags(projectID, opt, gitlab.WithContext(ctx))
+		if err != nil {
+			return tags, fmt.Errorf("could not get project %d tags: %w", projectID, err)
+		}
+		tags = append(tags, tagsPart...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return tags, nil
+}
+
+// GetProjectBranch Возвращает ветку проекта с именем name, nil - если ветки нет
+func GetProjectBranch(ctx context.Context, gitClient *gitlab.Client, projectID int, name string) (*gitlab.Branch, error) {
+	logger.Log.Tracef("Get project id: %d branch %s", projectID, name)
+	branch, resp, err := gitClient.Branches.GetBranch(projectID, name, gitlab.WithContext(ctx))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
 	if err != nil {
-		logger.Log.Errorf("Could not get project %d tags: %v", projectID, err)
+		return nil, fmt.Errorf("could not get project %d branch %s: %w", projectID, name, err)
 	}
-	return tags
+	return branch, nil
 }
 
-func GetGitlabClient(gitlabToken string, gitlabBaseURL string, skipTLS bool) *gitlab.Client {
+// FindCommit Возвращает первый из последних depth коммитов проекта (по всем веткам, от новых к старым),
+// для которого match возвращает true, nil - если такого коммита нет
+func FindCommit(ctx context.Context, gitClient *gitlab.Client, projectID int, depth int, match func(*gitlab.Commit) bool) (*gitlab.Commit, error) {
+	all := true
+	opt := &gitlab.ListCommitsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+		All: &all,
+	}
+	logger.Log.Tracef("Search project id: %d commits", projectID)
+	seen := 0
+	for seen < depth {
+		commits, resp, err := gitClient.Commits.ListCommits(projectID, opt, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("could not get project %d commits: %w", projectID, err)
+		}
+		for _, c := range commits {
+			if seen >= depth {
+				break
+			}
+			seen++
+			if match(c) {
+				return c, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return nil, nil
+}
+
+func GetGitlabClient(gitlabToken string, gitlabBaseURL string, skipTLS bool) (*gitlab.Client, error) {
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLS},
 	}
@@ -36,24 +99,26 @@ func GetGitlabClient(gitlabToken string, gitlabBaseURL string, skipTLS bool) *gi
 	optClient := gitlab.WithHTTPClient(client)
 	gitClient, err := gitlab.NewClient(gitlabToken, gitlab.WithBaseURL(gitlabBaseURL), optClient)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
-	return gitClient
+	return gitClient, nil
 }
 
-func GetProjectsInGroup(gitClient *gitlab.Client, gitlabGroupID string) []*gitlab.Project {
+// GetProjectsInGroup Возвращает проекты группы gitlabGroupID (id или полный путь), с includeSubgroups - и проекты ее подгрупп
+func GetProjectsInGroup(ctx context.Context, gitClient *gitlab.Client, gitlabGroupID string, includeSubgroups bool) ([]*gitlab.Project, error) {
 	var projects []*gitlab.Project
 	opt := &gitlab.ListGroupProjectsOptions{
 		ListOptions: gitlab.ListOptions{
 			PerPage: 50,
 			Page:    1,
 		},
+		IncludeSubGroups: gitlab.Bool(includeSubgroups),
 	}
 	logger.Log.Tracef("List projects in gitlab group %s", gitlabGroupID)
 	for {
-		projectsPart, resp, err := gitClient.Groups.ListGroupProjects(gitlabGroupID, opt)
+		projectsPart, resp, err := gitClient.Groups.ListGroupProjects(gitlabGroupID, opt, gitlab.WithContext(ctx))
 		if err != nil {
-			log.Fatalf("Error getting projects, check your setting or gitlab token: %v", err)
+			return nil, fmt.Errorf("error getting projects of group %s, check your setting or gitlab token: %w", gitlabGroupID, err)
 		}
 		projects = append(projects, projectsPart...)
 		if resp.NextPage == 0 {
@@ -61,47 +126,84 @@ func GetProjectsInGroup(gitClient *gitlab.Client, gitlabGroupID string) []*gitla
 		}
 		opt.Page = resp.NextPage
 	}
-	return projects
+	return projects, nil
 }
 
-func GetProjectsMap(projects []*gitlab.Project) map[string]*gitlab.Project {
-	var tempProjectMap map[string]*gitlab.Project
-	tempProjectMap = make(map[string]*gitlab.Project)
+// GetProjectsMap Возвращает проекты по полному пути (PathWithNamespace) и по пути в группе (Path), если он однозначен.
+// Пути, которые есть у нескольких проектов разных подгрупп, возвращаются вторым значением с полными путями этих проектов.
+func GetProjectsMap(projects []*gitlab.Project) (map[string]*gitlab.Project, map[string][]string) {
+	return MapProjects(projects, func(p *gitlab.Project) (string, string) {
+		return p.PathWithNamespace, strings.TrimSpace(p.Path)
+	})
+}
+
+// MapProjects Возвращает проекты любого хранилища по полному пути и по пути в группе, если он однозначен.
+// paths возвращает полный путь проекта и путь в группе. Пути, которые есть у нескольких проектов,
+// возвращаются вторым значением с полными путями этих проектов.
+func MapProjects[P any](projects []P, paths func(P) (string, string)) (map[string]P, map[string][]string) {
+	tempProjectMap := make(map[string]P)
+	byPath := make(map[string][]string)
 	for _, project := range projects {
-		tempProjectMap[strings.TrimSpace(project.Path)] = project
+		full, path := paths(project)
+		if _, ok := tempProjectMap[full]; ok {
+			continue
+		}
+		tempProjectMap[full] = project
+		byPath[path] = append(byPath[path], full)
 	}
-	if tempProjectMap == nil {
-		log.Fatalf("Empty projects map, terminating")
+	ambiguous := make(map[string][]string)
+	for path, full := range byPath {
+		if len(full) == 1 {
+			if _, ok := tempProjectMap[path]; !ok {
+				tempProjectMap[path] = tempProjectMap[full[0]]
+			}
+			continue
+		}
+		sort.Strings(full)
+		ambiguous[path] = full
 	}
-	return tempProjectMap
+	return tempProjectMap, ambiguous
 }
 
-func GetProjectArchive(gitClient *gitlab.Client, gitlabProjectID int, format *string, sha *string) []byte {
+// GetProjectArchive Возвращает архив проекта в формате format для ref sha. Загрузка прерывается при отмене ctx.
+func GetProjectArchive(ctx context.Context, gitClient *gitlab.Client, gitlabProjectID int, format *string, sha *string) ([]byte, error) {
 	opt := &gitlab.ArchiveOptions{
 		Format: format,
 		SHA:    sha,
 	}
 	logger.Log.Tracef("Get project archive, id: %d, format: %v, sha: %v", gitlabProjectID, *format, *sha)
-	tempBody, _, err := gitClient.Repositories.Archive(gitlabProjectID, opt, nil)
+	tempBody, _, err := gitClient.Repositories.Archive(gitlabProjectID, opt, gitlab.WithContext(ctx))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return tempBody
+	return tempBody, nil
+}
 
+// ResolveCommit Возвращает коммит, на который указывает ref (ветка, тег или SHA) проекта
+func ResolveCommit(ctx context.Context, gitClient *gitlab.Client, gitlabProjectID int, ref string) (*gitlab.Commit, error) {
+	logger.Log.Tracef("Resolve ref %s of project id: %d", ref, gitlabProjectID)
+	commit, _, err := gitClient.Commits.GetCommit(gitlabProjectID, ref, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve ref %s of project %d: %w", ref, gitlabProjectID, err)
+	}
+	return commit, nil
 }
 
-func GetProjectID(gitClient *gitlab.Client, serviceName string) (int, error) {
-	projects := GetProjectsInGroup(gitClient, "2706")
-	projectsMap := GetProjectsMap(projects)
-	project, ok := projectsMap[strings.TrimSpace(serviceName)]
-	if !ok {
-		return 0, errors.New("Service not found in the GitLab group")
-	}
-	return project.ID, nil
+// GetProject Возвращает проект по полному пути (группа/подгруппа/проект), nil - если проекта нет
+func GetProject(ctx context.Context, gitClient *gitlab.Client, path string) (*gitlab.Project, error) {
+	logger.Log.Tracef("Get project %s", path)
+	project, resp, err := gitClient.Projects.GetProject(path, nil, gitlab.WithContext(ctx))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get project %s: %w", path, err)
+	}
+	return project, nil
 }
 
-func CloneRepo(repoURL, branch, cloneDir string) (string, error) {
-    cmd := exec.Command("git", "clone", "-b", branch, repoURL, cloneDir)
+func CloneRepo(ctx context.Context, repoURL, branch, cloneDir string) (string, error) {
+    cmd := exec.CommandContext(ctx, "git", "clone", "-b", branch, repoURL, cloneDir)
     if err := cmd.Run(); err != nil {
         return "", err
     }
@@ -3,7 +3,6 @@ module sources
 go 1.20
 
 require (
-	github.com/cavaliergopher/grab/v3 v3.0.1 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
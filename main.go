@@ -4,17 +4,24 @@ package main
 import (
 	"strings"
 	"path/filepath"
+	"context"
 	_ "embed"
 	"fmt"
 	"os"
+	"os/signal"
 	"sources/config"
+	"sources/container"
 	gitlab_helper "sources/gitlab"
 	"sources/logger"
+	"sources/nexus"
+	"sources/scheduler"
 	"sources/services"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/xanzy/go-gitlab"
+	"golang.org/x/exp/slices"
 )
 
 var (
@@ -29,9 +36,9 @@ func init() {
 	logger.InitLog()
 }
 
-func cloneMzServiceRepo(cfg *config.Configuration) (string, error) {
+func cloneMzServiceRepo(ctx context.Context, cfg *config.Configuration) (string, error) {
     cloneDir := filepath.Join(cfg.Output_dir, "mz-service")
-    _, err := gitlab_helper.CloneRepo("https://git.gosuslugi.local/pgs2-rtlabs/source/mz-service", "dev", cloneDir)
+    _, err := gitlab_helper.CloneRepo(ctx, "https://git.gosuslugi.local/pgs2-rtlabs/source/mz-service", "dev", cloneDir)
     if err != nil {
         return "", err
     }
@@ -47,11 +54,11 @@ func getServiceVersion(cloneDir, serviceName string) (string, error) {
     return strings.TrimSpace(string(version)), nil
 }
 
-func cloneRepoForService(gitClient *gitlab.Client, cfg *config.Configuration, serviceName string) (string, error) {
+func cloneRepoForService(ctx context.Context, gitClient *gitlab.Client, cfg *config.Configuration, serviceName string) (string, error) {
     cloneDir := filepath.Join(cfg.Output_dir, serviceName)
 
     // Клонируем репозиторий
-    _, err := gitlab_helper.CloneRepo(cfg.FrankensteinRepo, "master", cloneDir)
+    _, err := gitlab_helper.CloneRepo(ctx, cfg.FrankensteinRepo, "master", cloneDir)
     if err != nil {
         return "", err
     }
@@ -64,16 +71,16 @@ func cloneRepoForService(gitClient *gitlab.Client, cfg *config.Configuration, se
     return cloneDir, nil
 }
 
-func processFrankenstein(svc string, cfg *config.Configuration, gitClient *gitlab.Client) error {
+func processFrankenstein(ctx context.Context, svc string, cfg *config.Configuration, gitClient *gitlab.Client) error {
     // Клонирование mz-service
-    _, err := cloneMzServiceRepo(cfg)
+    _, err := cloneMzServiceRepo(ctx, cfg)
     if err != nil {
         logger.Error("Ошибка клонирования mz-service: ", err)
         return err
     }
 
     // Клонирование mz-xsd-storage для сервиса франкенштейна
-    _, err = cloneRepoForService(gitClient, cfg, svc)
+    _, err = cloneRepoForService(ctx, gitClient, cfg, svc)
     if err != nil {
         logger.Error("Ошибка клонирования mz-xsd-storage: ", err)
         return err
@@ -93,7 +100,7 @@ func processFrankenstein(svc string, cfg *config.Configuration, gitClient *gitla
     }
 
     // Запуск процесса сборки из mz-service
-    err = services.Build(svc)
+    err = services.Build(ctx, svc)
     if err != nil {
         logger.Error("Ошибка в процессе сборки: ", err)
         return err
@@ -102,54 +109,107 @@ func processFrankenstein(svc string, cfg *config.Configuration, gitClient *gitla
         return nil
     }
 
-func processSvc(idx int, svc string, projectsMap map[string]*gitlab.Project, cfg *config.Configuration, gitClient *gitlab.Client, wg *sync.WaitGroup, semaphore chan struct{}) {
+func processSvc(ctx context.Context, idx int, svc string, project *gitlab.Project, cfg *config.Configuration, gitClient *gitlab.Client, wg *sync.WaitGroup, semaphore chan struct{}) {
 	defer wg.Done()
+	defer func() { <-semaphore }()
 
 	// Проверяем, является ли сервис франкенштейном
 	isFrankenstein := services.IsFrankensteinService(svc, cfg)
 	if isFrankenstein {
 		// Обработка сервиса франкенштейна
-		err := processFrankenstein(svc, cfg, gitClient)
+		err := processFrankenstein(ctx, svc, cfg, gitClient)
+		if err != nil && ctx.Err() != nil {
+			services.SetOutcome(svc, services.Outcome{Status: services.StatusInterrupted, Stage: "frankenstein"})
+			return
+		}
 		if err != nil {
 			logger.Error("Ошибка при обработке сервиса 'франкенштейн': ", svc, ": ", err)
+			services.SetOutcome(svc, services.Failure(err, "frankenstein"))
 			return
 		}
-	} else if projectsMap[svc] != nil {
+		services.SetOutcome(svc, services.Outcome{Status: services.StatusSucceeded})
+	} else if project != nil {
 		// Обработка обычных сервисов
-		logger.Log.Debugf("service # %d: %s id: %d path: %v\n", idx, svc, projectsMap[svc].ID, projectsMap[svc].Path)
+		logger.Log.Debugf("service # %d: %s id: %d path: %v\n", idx, svc, project.ID, project.PathWithNamespace)
 		logger.Log.Infof("Processing %s service", svc)
 		apath := cfg.Output_dir + "/" + svc + "." + cfg.Archive_format
-		err := os.WriteFile(apath, gitlab_helper.GetProjectArchive(gitClient, projectsMap[svc].ID, &cfg.Archive_format, &cfg.Branch), 0644)
+		start, err := services.PrepareResume(project)
 		if err != nil {
-			logger.Log.Fatalf("Error writing archive file: %v", err)
+			logger.Log.Errorf("Unable to prepare processing of service %s: %v", svc, err)
+			services.SetOutcome(svc, services.Failure(err, ""))
+			return
+		}
+		if start != services.StageFetch {
+			logger.Log.Infof("Resume processing of service %s from stage %s", svc, start)
+		} else {
+			err = services.FetchService(ctx, project, cfg.Branch, apath)
+			if err != nil && ctx.Err() != nil {
+				services.Interrupt(project, services.StageFetch)
+				return
+			}
+			if err == nil {
+				err = services.CompleteStage(project, services.StageFetch, nil)
+			}
+			if err != nil {
+				logger.Log.Errorf("Error getting archive of service %s: %v", svc, err)
+				services.SetOutcome(svc, services.Failure(err, services.StageFetch))
+				return
+			}
+			logger.Log.Debugf("Created archive for service # %d: %s id: %d\n", idx, svc, project.ID)
 		}
-		logger.Log.Debugf("Created archive for service # %d: %s id: %d\n", idx, svc, projectsMap[svc].ID)
 		logger.Log.Debugf("Build service %s", svc)
-		err2 := services.ProcessService(apath, projectsMap[svc])
+		err2 := services.ProcessService(ctx, apath, project)
+		if err2 != nil && ctx.Err() != nil {
+			return
+		}
 		if err2 != nil {
-			logger.Log.Errorf("Error process service %s", svc)
-			os.Exit(1)
+			// Ошибка сервиса не останавливает обработку остальных сервисов
+			logger.Log.Errorf("Error process service %s: %v", svc, err2)
+			services.SetOutcome(svc, services.Failure(err2, ""))
+			return
+		}
+		outcome := services.Outcome{Status: services.StatusSucceeded}
+		if start != services.StageFetch {
+			outcome.Reason = "resumed from stage " + start
 		}
+		services.SetOutcome(svc, outcome)
 		logger.Log.Debugf("Finish process service %s", svc)
-		} else {
-			logger.Log.Warnf("Project %s not found\n", svc)
-			services.UnknownProjects = append(services.UnknownProjects, svc)
+	} else {
+		services.MapMutex.RLock()
+		paths, ambiguous := services.AmbiguousProjects[svc]
+		services.MapMutex.RUnlock()
+		if ambiguous {
+			logger.Log.Warnf("Project path %s is ambiguous, use one of full paths in service_list: %s", svc, strings.Join(paths, ", "))
+			services.SetOutcome(svc, services.Outcome{Status: services.StatusSkipped, Reason: "ambiguous project path, use one of " + strings.Join(paths, ", ")})
+			return
 		}
-	
-		logger.Log.Infof("Finished processing %s service. Details in Readme.md file", svc)
-		<-semaphore
+		logger.Log.Warnf("Project %s not found\n", svc)
+		services.MapMutex.Lock()
+		services.UnknownProjects = append(services.UnknownProjects, svc)
+		services.MapMutex.Unlock()
+		services.SetOutcome(svc, services.Outcome{Status: services.StatusSkipped, Reason: "project not found in gitlab"})
+		return
 	}
 
+	logger.Log.Infof("Finished processing %s service. Details in Readme.md file", svc)
+}
+
 func main() {
 	if config.Version {
 		fmt.Printf("%s", version)
 		os.Exit(0)
 	}
 	logger.Log.Info("Starting")
+	// По SIGINT/SIGTERM отменяется контекст: новые сервисы не запускаются, сборки останавливаются,
+	// результаты завершенных стадий сохраняются для -resume, отчет записывается. Повторный сигнал завершает процесс сразу.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		logger.Log.Warn("Interrupted, stopping running builds, completed stages are kept for -resume. Send the signal again to exit immediately")
+	}()
 	var projects []*gitlab.Project
-	var projectsRtlDeps []*gitlab.Project
-	var projectsMap map[string]*gitlab.Project
-	var projectsRtlDepsMap map[string]*gitlab.Project
 	var gitClient *gitlab.Client
 	var limit syscall.Rlimit
 	skipTLS = true
@@ -163,11 +223,19 @@ func main() {
 		logger.Log.Fatalf("Terminating, error: %v", err_config)
 	}
 	services.Cfg = cfg
+	nexus.Cfg = cfg
 	gitlabToken := os.Getenv("GIT_TOKEN")
 	if gitlabToken == "" {
 		logger.Log.Fatalf("Please set gitlab token in GIT_TOKEN env var")
 	}
-	if config.ForceReplace {
+	if (config.ForceStage != "" || config.ForceService != "") && !config.ForceReplace {
+		logger.Log.Fatalf("-force-stage and -force-service could be used only with -force")
+	}
+	if config.ForceStage != "" && !slices.Contains(services.Stages, config.ForceStage) {
+		logger.Log.Fatalf("Unknown stage %s, should be one of: %s", config.ForceStage, strings.Join(services.Stages, ", "))
+	}
+	// С -force-stage или -force-service повторно обрабатываются только указанные стадии и сервисы, каталог output_dir сохраняется
+	if config.ForceReplace && config.ForceStage == "" && config.ForceService == "" {
 		err = os.RemoveAll(cfg.Output_dir)
 		if err != nil {
 			logger.Log.Fatalf("Could not delete temp folder %s, error: %v", cfg.Output_dir, err)
@@ -203,22 +271,39 @@ func main() {
 			logger.Log.Fatalf("Please set nexus auth credentials in NEXUS_USER and NEXUS_PASS env vars or set `upload_to_nexus=false` in config.json")
 		}
 	}
-	gitClient = gitlab_helper.GetGitlabClient(gitlabToken, cfg.Gitlab_api_host, skipTLS)
+	runtime, err := container.New(cfg.ContainerRuntime, cfg.ContainerEndpoint)
+	if err != nil {
+		logger.Log.Fatalf("Terminating, error: %v", err)
+	}
+	services.Runtime = runtime
+	services.Downloads = scheduler.New(scheduler.Config{
+		Concurrency: cfg.Downloads.MaxConcurrency,
+		PerHost:     cfg.Downloads.PerHost,
+		Rate:        cfg.Downloads.Rate,
+		Burst:       cfg.Downloads.Burst,
+		Retries:     *cfg.Downloads.Retries,
+		BackoffBase: time.Duration(cfg.Downloads.BackoffBase) * time.Second,
+		BackoffMax:  time.Duration(cfg.Downloads.BackoffMax) * time.Second,
+	})
+	gitClient, err = gitlab_helper.GetGitlabClient(gitlabToken, cfg.Gitlab_api_host, skipTLS)
+	if err != nil {
+		logger.Log.Fatalf("Terminating, error: %v", err)
+	}
 	services.GitClient = gitClient
-	projects = gitlab_helper.GetProjectsInGroup(gitClient, cfg.Group_id)
+	services.CloneAuth = gitlab_helper.CloneOptions{Token: gitlabToken, SkipTLS: skipTLS}
+	projects, err = gitlab_helper.GetProjectsInGroup(ctx, gitClient, cfg.Group_id, cfg.IncludeSubgroups)
+	if err != nil {
+		logger.Log.Fatalf("Terminating, error: %v", err)
+	}
 	if projects == nil {
 		logger.Log.Fatalf("Failed to retrieve any projects. Check if the group is correct")
 	}
-	projectsRtlDeps = gitlab_helper.GetProjectsInGroup(gitClient, cfg.RtlSearchRepoId)
-	if projects == nil {
-		logger.Log.Fatalf("Failed to retrieve any project for rtl.pgs dependencies. Check if the group is correct")
+	projectsMap, ambiguous := gitlab_helper.GetProjectsMap(projects)
+	services.AddAmbiguousProjects(ambiguous)
+	err = services.InitSources(ctx)
+	if err != nil {
+		logger.Log.Fatalf("Terminating, error: %v", err)
 	}
-	projectsMap = make(map[string]*gitlab.Project)
-	projectsMap = gitlab_helper.GetProjectsMap(projects)
-	projectsRtlDepsMap = make(map[string]*gitlab.Project)
-	projectsRtlDepsMap = gitlab_helper.GetProjectsMap(projectsRtlDeps)
-	services.ProjectsMap = projectsMap
-	services.ProjectsRtlDepsMap = projectsRtlDepsMap
 	if _, err := os.Stat(cfg.Output_dir); os.IsNotExist(err) {
 		err := os.Mkdir(cfg.Output_dir, 0744)
 		if err != nil {
@@ -236,22 +321,68 @@ func main() {
 	}
 	logger.Log.Info("Processing services")
 	services.Init()
+	err = services.LoadJournal()
+	if err != nil {
+		logger.Log.Fatalf("Terminating, error: %v", err)
+	}
 	var pmax = cfg.MaxParallelism
 	semaphore := make(chan struct{}, pmax)
 	wg := &sync.WaitGroup{}
+	// Результаты сервиса ключуются путем проекта в группе, полный путь в service_list только выбирает проект подгруппы.
+	// Проекты с одинаковым путем из разных подгрупп не обрабатываются и попадают в отчет как неоднозначные.
+	var listed []*gitlab.Project
+	for _, svc := range cfg.Service_list {
+		if project := projectsMap[svc]; project != nil && !services.IsFrankensteinService(svc, cfg) {
+			listed = append(listed, project)
+		}
+	}
+	collisions := services.PathCollisions(listed)
+	services.AddAmbiguousProjects(collisions)
 	for idx, svc := range cfg.Service_list {
-		if _, err := os.Stat(cfg.Output_dir + "/" + svc + "." + cfg.Archive_format); err == nil {
+		var project *gitlab.Project
+		if !services.IsFrankensteinService(svc, cfg) {
+			project = projectsMap[svc]
+		}
+		if project != nil {
+			path := strings.TrimSpace(project.Path)
+			if full, ok := collisions[path]; ok {
+				logger.Log.Warnf("Project path %s of service %s is used by several services from service_list: %s, skipping", path, svc, strings.Join(full, ", "))
+				services.SetOutcome(svc, services.Outcome{Status: services.StatusSkipped, Reason: "ambiguous project path " + path + " in service_list: " + strings.Join(full, ", ")})
+				continue
+			}
+			svc = path
+		}
+		if project != nil && services.Processed(project) {
 			logger.Log.Debugf("Service %s has already been processed, skipping", svc)
+			services.RestoreProcessed(project)
+			services.SetOutcome(svc, services.Outcome{Status: services.StatusSkipped, Reason: "already processed"})
 			continue
 
 		}
 
-		semaphore <- struct{}{}
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			services.SetOutcome(svc, services.Outcome{Status: services.StatusInterrupted, Stage: "not started"})
+			continue
+		}
 		wg.Add(1)
-		go processSvc(idx, svc, projectsMap, cfg, gitClient, wg, semaphore)
+		go processSvc(ctx, idx, svc, project, cfg, gitClient, wg, semaphore)
 
 	}
 	wg.Wait()
-	services.SummaryReport("report.txt")
+	if err := services.SummaryReport("report.txt"); err != nil {
+		logger.Log.Errorf("Error writing report: %v", err)
+	}
+	code := services.ExitCode()
+	switch code {
+	case services.ExitInterrupted:
+		logger.Log.Warnf("Run interrupted, report written to %s", cfg.Output_dir+"/report.txt")
+	case services.ExitPartial:
+		logger.Log.Warnf("Some services failed, details in %s", cfg.Output_dir+"/report.txt")
+	}
+	os.Exit(code)
 
 }
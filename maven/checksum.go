@@ -0,0 +1,124 @@
+package maven
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sources/logger"
+	"strings"
+)
+
+// ErrChecksumMismatch Контрольная сумма скачанного файла не совпадает с опубликованной
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumAlgorithms Алгоритмы контрольных сумм в порядке предпочтения, расширение файла совпадает с именем алгоритма
+var checksumAlgorithms = []string{"sha256", "sha1"}
+
+// ChecksumError Несовпадение контрольной суммы файла File, Source - откуда взята ожидаемая сумма
+// (репозиторий или кеш сборки)
+type ChecksumError struct {
+	File      string
+	Algorithm string
+	Source    string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: %s mismatch, expected %s (%s), got %s", e.File, e.Algorithm, e.Expected, e.Source, e.Actual)
+}
+
+// Is Позволяет проверять ошибку через errors.Is(err, ErrChecksumMismatch)
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// IsChecksumFile Проверяет, что файл является контрольной суммой или подписью другого файла
+func IsChecksumFile(name string) bool {
+	for _, ext := range []string{".sha1", ".sha256", ".sha512", ".md5", ".asc"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileChecksum Возвращает контрольную сумму файла в hex по алгоритму sha256 или sha1
+func FileChecksum(file string, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %s", algorithm)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SameChecksum Сравнивает контрольные суммы в hex без учета регистра и ведущих нулей (gradle не хранит их в именах каталогов кеша)
+func SameChecksum(a string, b string) bool {
+	return strings.TrimLeft(strings.ToLower(a), "0") == strings.TrimLeft(strings.ToLower(b), "0")
+}
+
+// Checksum Возвращает опубликованную в репозитории контрольную сумму файла по пути path: .sha256, если его нет - .sha1.
+// Если контрольных сумм нет, возвращается ErrNotFound.
+func (c *Client) Checksum(ctx context.Context, repo *Repository, path string) (string, string, error) {
+	for _, algorithm := range checksumAlgorithms {
+		resp, err := c.get(ctx, repo, repo.Url+"/"+path+"."+algorithm)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if err != nil {
+			return "", "", err
+		}
+		// Файл может содержать имя файла после суммы (формат sha256sum)
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 {
+			return "", "", fmt.Errorf("empty %s checksum for %s in %s", algorithm, path, repo.Name)
+		}
+		return algorithm, strings.ToLower(fields[0]), nil
+	}
+	return "", "", ErrNotFound
+}
+
+// verify Проверяет скачанный файл file по контрольной сумме, опубликованной для пути path.
+// Если репозиторий не публикует контрольные суммы, файл считается проверенным.
+func (c *Client) verify(ctx context.Context, repo *Repository, path string, file string) error {
+	algorithm, expected, err := c.Checksum(ctx, repo, path)
+	if errors.Is(err, ErrNotFound) {
+		logger.Log.Debugf("No published checksum for %s in %s", path, repo.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get checksum of %s: %w", path, err)
+	}
+	actual, err := FileChecksum(file, algorithm)
+	if err != nil {
+		return err
+	}
+	if !SameChecksum(expected, actual) {
+		return &ChecksumError{File: path[strings.LastIndex(path, "/")+1:], Algorithm: algorithm, Source: repo.Name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
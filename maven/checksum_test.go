@@ -0,0 +1,140 @@
+package maven
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	const path = "com/example/lib/1.0/lib-1.0.jar"
+	const content = "jar content"
+	tests := []struct {
+		name      string
+		sums      map[string]string
+		algorithm string
+		mismatch  bool
+	}{
+		{
+			name:      "sha256 matches",
+			sums:      map[string]string{".sha256": sha256Hex(content), ".sha1": "0000"},
+			algorithm: "sha256",
+		},
+		{
+			name:      "sha256 mismatch",
+			sums:      map[string]string{".sha256": sha256Hex("other"), ".sha1": sha1Hex(content)},
+			algorithm: "sha256",
+			mismatch:  true,
+		},
+		{
+			name:      "sha1 in sha1sum format",
+			sums:      map[string]string{".sha1": "  " + sha1Hex(content) + "  lib-1.0.jar\n"},
+			algorithm: "sha1",
+		},
+		{
+			name:      "sha1 in upper case",
+			sums:      map[string]string{".sha1": strings.ToUpper(sha1Hex(content)) + "\n"},
+			algorithm: "sha1",
+		},
+		{
+			name:      "sha1 mismatch",
+			sums:      map[string]string{".sha1": sha1Hex("other")},
+			algorithm: "sha1",
+			mismatch:  true,
+		},
+		{
+			name: "no published checksums",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{path: content}
+			for ext, sum := range tt.sums {
+				files[path+ext] = sum
+			}
+			c := repoServer(t, files, nil)
+			file := t.TempDir() + "/lib-1.0.jar"
+			_, err := c.DownloadPath(context.Background(), c.Repositories[0], path, file)
+			if !tt.mismatch {
+				if err != nil {
+					t.Fatalf("DownloadPath: %v", err)
+				}
+				if b, _ := os.ReadFile(file); string(b) != content {
+					t.Errorf("content = %q", b)
+				}
+				return
+			}
+			if !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("err = %v, want checksum mismatch", err)
+			}
+			var ce *ChecksumError
+			if !errors.As(err, &ce) || ce.Algorithm != tt.algorithm || ce.File != "lib-1.0.jar" || ce.Source != "test" {
+				t.Errorf("checksum error = %+v", ce)
+			}
+			// Файл с несовпадающей суммой не сохраняется, временный файл удаляется
+			for _, f := range []string{file, file + ".part"} {
+				if _, err := os.Stat(f); !os.IsNotExist(err) {
+					t.Errorf("%s must be removed", f)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadChecksumFile(t *testing.T) {
+	// Сами файлы контрольных сумм не проверяются
+	const path = "com/example/lib/1.0/lib-1.0.jar.sha1"
+	c := repoServer(t, map[string]string{path: "abc", path + ".sha1": "def"}, nil)
+	if _, err := c.DownloadPath(context.Background(), c.Repositories[0], path, t.TempDir()+"/lib-1.0.jar.sha1"); err != nil {
+		t.Errorf("DownloadPath: %v", err)
+	}
+}
+
+func TestFileChecksum(t *testing.T) {
+	file := t.TempDir() + "/f"
+	os.WriteFile(file, []byte("content-110"), 0644)
+	for algorithm, want := range map[string]string{
+		"sha1":   "007784269c7d6043e862dd7429f2266e69b2395b",
+		"sha256": sha256Hex("content-110"),
+	} {
+		got, err := FileChecksum(file, algorithm)
+		if err != nil || got != want {
+			t.Errorf("FileChecksum(%s) = %q, %v, want %q", algorithm, got, err, want)
+		}
+	}
+	if _, err := FileChecksum(file, "md5"); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+}
+
+func TestSameChecksum(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"007784269c7d6043e862dd7429f2266e69b2395b", "7784269c7d6043e862dd7429f2266e69b2395b", true},
+		{"007784269C7D6043E862DD7429F2266E69B2395B", "7784269c7d6043e862dd7429f2266e69b2395b", true},
+		{"7784269c7d6043e862dd7429f2266e69b2395b", "7784269c7d6043e862dd7429f2266e69b2395", false},
+		{"107784269c7d6043e862dd7429f2266e69b2395b", "7784269c7d6043e862dd7429f2266e69b2395b", false},
+	}
+	for _, tt := range tests {
+		if got := SameChecksum(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameChecksum(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+// maven Пакет клиента maven репозитория: пути к файлам вычисляются по координатам артефакта,
+// maven-metadata.xml используется для snapshot версий и плагинов gradle, html листинг каталогов необязателен
+package maven
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sources/logger"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// ErrNotFound Файл отсутствует в репозитории
+var ErrNotFound = errors.New("not found in repository")
+
+// errMethodNotAllowed Репозиторий не поддерживает метод запроса (например HEAD)
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// PluginMarkerSuffix Окончание artifactId маркера плагина gradle (id/id.gradle.plugin/version)
+const PluginMarkerSuffix = ".gradle.plugin"
+
+// Artifact Координаты файла в maven репозитории
+type Artifact struct {
+	GroupId    string
+	ArtifactId string
+	Version    string
+	Classifier string
+	Extension  string
+}
+
+// Metadata Содержимое maven-metadata.xml уровня артефакта (список версий) или версии (snapshot)
+type Metadata struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Versioning struct {
+		Latest   string   `xml:"latest"`
+		Release  string   `xml:"release"`
+		Versions []string `xml:"versions>version"`
+		Snapshot struct {
+			Timestamp   string `xml:"timestamp"`
+			BuildNumber string `xml:"buildNumber"`
+		} `xml:"snapshot"`
+		SnapshotVersions []SnapshotVersion `xml:"snapshotVersions>snapshotVersion"`
+	} `xml:"versioning"`
+}
+
+// SnapshotVersion Опубликованный файл snapshot версии с версией, включающей время публикации
+type SnapshotVersion struct {
+	Classifier string `xml:"classifier"`
+	Extension  string `xml:"extension"`
+	Value      string `xml:"value"`
+}
+
+// pom Часть pom, нужная для разбора маркера плагина gradle
+type pom struct {
+	Dependencies []struct {
+		GroupId    string `xml:"groupId"`
+		ArtifactId string `xml:"artifactId"`
+		Version    string `xml:"version"`
+	} `xml:"dependencies>dependency"`
+}
+
+// Repository Maven репозиторий. Groups - шаблоны groupId (синтаксис path.Match, например org.springframework*),
+// которые разрешено искать в репозитории, пустой список разрешает все. Timeout - таймаут ожидания ответа
+// на запрос (до получения заголовков, тело файла читается без ограничения), 0 - без таймаута.
+type Repository struct {
+	Name     string
+	Url      string
+	Username string
+	Password string
+	Groups   []string
+	Timeout  time.Duration
+	http     *http.Client
+}
+
+// Client Клиент maven репозиториев. Репозитории перебираются по порядку.
+// CharsetReader используется для разбора pom не в UTF-8.
+type Client struct {
+	Repositories  []*Repository
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+}
+
+// NewClient Создает клиент для репозиториев repos, httpClient может быть настроен на работу через прокси.
+// Http клиент общий для всех репозиториев, таймаут репозитория применяется к каждому запросу.
+func NewClient(httpClient *http.Client, repos ...Repository) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	c := &Client{}
+	for _, repo := range repos {
+		if repo.Url == "" {
+			continue
+		}
+		r := repo
+		r.Url = strings.TrimSuffix(r.Url, "/")
+		if r.Name == "" {
+			r.Name = r.Url
+		}
+		r.http = httpClient
+		c.Repositories = append(c.Repositories, &r)
+	}
+	return c
+}
+
+// Allows Проверяет, что groupId разрешено искать в репозитории
+func (r *Repository) Allows(groupId string) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, g := range r.Groups {
+		if ok, _ := path.Match(g, groupId); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSnapshot Проверяет, что версия артефакта snapshot
+func (a Artifact) IsSnapshot() bool {
+	return strings.HasSuffix(a.Version, "-SNAPSHOT")
+}
+
+// IsPluginMarker Проверяет, что артефакт является маркером плагина gradle
+func (a Artifact) IsPluginMarker() bool {
+	return strings.HasSuffix(a.ArtifactId, PluginMarkerSuffix)
+}
+
+// Dir Возвращает путь каталога версии артефакта в репозитории: group/path/artifactId/version
+func (a Artifact) Dir() string {
+	return strings.ReplaceAll(a.GroupId, ".", "/") + "/" + a.ArtifactId + "/" + a.Version
+}
+
+// FileName Возвращает имя файла артефакта для версии version: artifactId-version[-classifier].extension
+func (a Artifact) FileName(version string) string {
+	name := a.ArtifactId + "-" + version
+	if a.Classifier != "" {
+		name = name + "-" + a.Classifier
+	}
+	return name + "." + a.Extension
+}
+
+// String Возвращает координаты артефакта в формате group:artifact:version[:classifier]@extension
+func (a Artifact) String() string {
+	s := a.GroupId + ":" + a.ArtifactId + ":" + a.Version
+	if a.Classifier != "" {
+		s = s + ":" + a.Classifier
+	}
+	return s + "@" + a.Extension
+}
+
+// WithFile Возвращает артефакт той же версии с другим классификатором и расширением
+func (a Artifact) WithFile(classifier string, extension string) Artifact {
+	a.Classifier = classifier
+	a.Extension = extension
+	return a
+}
+
+// get Выполняет GET запрос. 404 возвращается как ErrNotFound, остальные ответы кроме 200 - как ошибка.
+// Повторы и ограничение частоты запросов выполняет транспорт http клиента (планировщик загрузок).
+func (c *Client) get(ctx context.Context, repo *Repository, url string) (*http.Response, error) {
+	return c.do(ctx, repo, http.MethodGet, url)
+}
+
+// do Выполняет запрос method к репозиторию repo. Таймаут репозитория ограничивает ожидание заголовков ответа,
+// после их получения тело читается без ограничения по времени, чтобы не прерывать загрузку больших файлов.
+func (c *Client) do(ctx context.Context, repo *Repository, method string, url string) (*http.Response, error) {
+	logger.Log.Tracef("Maven request: %s %s", method, url)
+	cancel := func() {}
+	var timer *time.Timer
+	if repo.Timeout > 0 {
+		ctx, cancel = context.WithCancel(ctx)
+		timer = time.AfterFunc(repo.Timeout, cancel)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if repo.Username != "" {
+		req.SetBasicAuth(repo.Username, repo.Password)
+	}
+	resp, err := repo.http.Do(req)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("%s %s: no response in %s", method, url, repo.Timeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		cancel()
+		return nil, ErrNotFound
+	case http.StatusMethodNotAllowed:
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s %s: %w", method, url, errMethodNotAllowed)
+	default:
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s %s: status %d", method, url, resp.StatusCode)
+	}
+}
+
+// cancelBody Тело ответа, при закрытии которого освобождается контекст запроса
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+// Close Закрывает тело ответа и освобождает контекст запроса
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Metadata Читает maven-metadata.xml по пути path (каталог артефакта или версии) в репозитории repo
+func (c *Client) Metadata(ctx context.Context, repo *Repository, path string) (*Metadata, error) {
+	resp, err := c.get(ctx, repo, repo.Url+"/"+path+"/maven-metadata.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	m := &Metadata{}
+	err = xml.NewDecoder(resp.Body).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse maven-metadata.xml in %s: %w", repo.Url+"/"+path, err)
+	}
+	return m, nil
+}
+
+// remotePath Возвращает путь файла артефакта в репозитории. Для snapshot версии имя файла берется
+// из maven-metadata.xml каталога версии (версия с временем публикации), если метаданных нет - используется имя как есть.
+func (c *Client) remotePath(ctx context.Context, repo *Repository, a Artifact) string {
+	if !a.IsSnapshot() {
+		return a.Dir() + "/" + a.FileName(a.Version)
+	}
+	m, err := c.Metadata(ctx, repo, a.Dir())
+	if err != nil {
+		logger.Log.Debugf("No snapshot metadata for %s in %s: %v", a, repo.Name, err)
+		return a.Dir() + "/" + a.FileName(a.Version)
+	}
+	for _, v := range m.Versioning.SnapshotVersions {
+		if v.Classifier == a.Classifier && v.Extension == a.Extension && v.Value != "" {
+			return a.Dir() + "/" + a.FileName(v.Value)
+		}
+	}
+	if s := m.Versioning.Snapshot; s.Timestamp != "" && s.BuildNumber != "" {
+		return a.Dir() + "/" + a.FileName(strings.TrimSuffix(a.Version, "SNAPSHOT")+s.Timestamp+"-"+s.BuildNumber)
+	}
+	return a.Dir() + "/" + a.FileName(a.Version)
+}
+
+// Exists Проверяет наличие файла артефакта в репозитории repo запросом HEAD.
+// Если репозиторий не поддерживает HEAD (405), наличие проверяется запросом GET.
+func (c *Client) Exists(ctx context.Context, repo *Repository, a Artifact) (bool, error) {
+	url := repo.Url + "/" + c.remotePath(ctx, repo, a)
+	resp, err := c.do(ctx, repo, http.MethodHead, url)
+	if errors.Is(err, errMethodNotAllowed) {
+		logger.Log.Debugf("Repository %s does not allow HEAD requests, use GET", repo.Name)
+		resp, err = c.get(ctx, repo, url)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Find Возвращает первый по порядку репозиторий, в котором есть pom или .module версии артефакта.
+// Репозитории, не разрешающие groupId артефакта, пропускаются, при ошибке репозитория поиск продолжается в следующем.
+// Если артефакт не найден ни в одном репозитории, возвращается ErrNotFound.
+func (c *Client) Find(ctx context.Context, a Artifact) (*Repository, error) {
+	var lastErr error
+	for _, repo := range c.Repositories {
+		if !repo.Allows(a.GroupId) {
+			continue
+		}
+		for _, ext := range []string{"pom", "module"} {
+			ok, err := c.Exists(ctx, repo, a.WithFile("", ext))
+			if err != nil && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				logger.Log.Debugf("Unable to check %s in %s, try next repository: %v", a, repo.Name, err)
+				lastErr = err
+				break
+			}
+			if ok {
+				return repo, nil
+			}
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrNotFound
+}
+
+// Download Скачивает файл артефакта из репозитория repo в каталог dir под именем с версией артефакта.
+// Файл пишется во временный файл и переименовывается после успешной загрузки. Возвращает путь к файлу.
+func (c *Client) Download(ctx context.Context, repo *Repository, a Artifact, dir string) (string, error) {
+	return c.DownloadPath(ctx, repo, c.remotePath(ctx, repo, a), dir+"/"+a.FileName(a.Version))
+}
+
+// DownloadPath Скачивает файл по пути path в репозитории repo в файл file. Файл проверяется по опубликованной
+// контрольной сумме (.sha256 или .sha1), при несовпадении файл удаляется и возвращается *ChecksumError.
+func (c *Client) DownloadPath(ctx context.Context, repo *Repository, path string, file string) (string, error) {
+	resp, err := c.get(ctx, repo, repo.Url+"/"+path)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0744)
+	if err != nil {
+		resp.Body.Close()
+		return "", err
+	}
+	tmp := file + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		resp.Body.Close()
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	// Тело ответа закрывается до запроса контрольной суммы, чтобы освободить слот планировщика загрузок
+	resp.Body.Close()
+	if err == nil && !IsChecksumFile(path) {
+		err = c.verify(ctx, repo, path, tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	logger.Log.Tracef("Downloaded %s to %s", repo.Url+"/"+path, file)
+	return file, os.Rename(tmp, file)
+}
+
+// PluginMarker Возвращает артефакт реализации плагина gradle по pom маркера плагина
+func (c *Client) PluginMarker(ctx context.Context, repo *Repository, marker Artifact) (Artifact, error) {
+	resp, err := c.get(ctx, repo, repo.Url+"/"+c.remotePath(ctx, repo, marker.WithFile("", "pom")))
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer resp.Body.Close()
+	var p pom
+	err = xml.NewDecoder(resp.Body).Decode(&p)
+	if err != nil {
+		return Artifact{}, err
+	}
+	for _, d := range p.Dependencies {
+		if d.GroupId != "" && d.ArtifactId != "" && d.Version != "" && !strings.Contains(d.Version, "$") {
+			return Artifact{GroupId: d.GroupId, ArtifactId: d.ArtifactId, Version: d.Version, Extension: "jar"}, nil
+		}
+	}
+	return Artifact{}, fmt.Errorf("no implementation dependency in plugin marker %s", marker)
+}
+
+// Listing Возвращает имена файлов из html листинга каталога версии артефакта.
+// Листинг есть не во всех репозиториях (например nexus proxy и artifactory его не отдают), поэтому используется только как дополнение.
+func (c *Client) Listing(ctx context.Context, repo *Repository, a Artifact) ([]string, error) {
+	resp, err := c.get(ctx, repo, repo.Url+"/"+a.Dir()+"/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var files []string
+	for _, l := range ParseLinks(resp.Body) {
+		l = strings.TrimPrefix(l, repo.Url+"/"+a.Dir()+"/")
+		if l == "" || strings.HasPrefix(l, "..") || strings.HasSuffix(l, "/") || strings.ContainsAny(l, "/?#:") {
+			continue
+		}
+		files = append(files, l)
+	}
+	return files, nil
+}
+
+// ParseLinks Возвращает ссылки (href) из html страницы
+func ParseLinks(body io.Reader) []string {
+	var links []string
+	z := html.NewTokenizer(body)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.EndTagToken:
+			token := z.Token()
+			if "a" == token.Data {
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						links = append(links, attr.Val)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package maven
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// repoServer Запускает maven репозиторий с файлами files (путь без ведущего / -> содержимое), запрошенные пути пишутся в requests
+func repoServer(t *testing.T, files map[string]string, requests *[]string) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.TrimPrefix(r.URL.Path, "/repo/")
+		if requests != nil {
+			*requests = append(*requests, p)
+		}
+		body, ok := files[p]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return NewClient(srv.Client(), Repository{Name: "test", Url: srv.URL + "/repo/"})
+}
+
+func TestArtifactLayout(t *testing.T) {
+	tests := []struct {
+		a      Artifact
+		dir    string
+		file   string
+		str    string
+		snap   bool
+		marker bool
+	}{
+		{
+			a:    Artifact{GroupId: "org.apache.commons", ArtifactId: "commons-lang3", Version: "3.12.0", Extension: "jar"},
+			dir:  "org/apache/commons/commons-lang3/3.12.0",
+			file: "commons-lang3-3.12.0.jar",
+			str:  "org.apache.commons:commons-lang3:3.12.0@jar",
+		},
+		{
+			a:    Artifact{GroupId: "io.netty", ArtifactId: "netty-transport-native-epoll", Version: "4.1.86.Final", Classifier: "linux-x86_64", Extension: "jar"},
+			dir:  "io/netty/netty-transport-native-epoll/4.1.86.Final",
+			file: "netty-transport-native-epoll-4.1.86.Final-linux-x86_64.jar",
+			str:  "io.netty:netty-transport-native-epoll:4.1.86.Final:linux-x86_64@jar",
+		},
+		{
+			a:    Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0-SNAPSHOT", Classifier: "sources", Extension: "jar"},
+			dir:  "com/example/lib/1.0-SNAPSHOT",
+			file: "lib-1.0-SNAPSHOT-sources.jar",
+			str:  "com.example:lib:1.0-SNAPSHOT:sources@jar",
+			snap: true,
+		},
+		{
+			a:      Artifact{GroupId: "org.springframework.boot", ArtifactId: "org.springframework.boot.gradle.plugin", Version: "2.7.5", Extension: "pom"},
+			dir:    "org/springframework/boot/org.springframework.boot.gradle.plugin/2.7.5",
+			file:   "org.springframework.boot.gradle.plugin-2.7.5.pom",
+			str:    "org.springframework.boot:org.springframework.boot.gradle.plugin:2.7.5@pom",
+			marker: true,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Dir(); got != tt.dir {
+			t.Errorf("Dir() = %q, want %q", got, tt.dir)
+		}
+		if got := tt.a.FileName(tt.a.Version); got != tt.file {
+			t.Errorf("FileName() = %q, want %q", got, tt.file)
+		}
+		if got := tt.a.String(); got != tt.str {
+			t.Errorf("String() = %q, want %q", got, tt.str)
+		}
+		if tt.a.IsSnapshot() != tt.snap || tt.a.IsPluginMarker() != tt.marker {
+			t.Errorf("%s: snapshot %v, plugin marker %v", tt.a, tt.a.IsSnapshot(), tt.a.IsPluginMarker())
+		}
+	}
+}
+
+func TestRepositoryAllows(t *testing.T) {
+	r := &Repository{Groups: []string{"org.springframework*", "com.example"}}
+	for group, want := range map[string]bool{
+		"org.springframework":      true,
+		"org.springframework.boot": true,
+		"com.example":              true,
+		"com.example.lib":          false,
+		"org.apache":               false,
+	} {
+		if got := r.Allows(group); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", group, got, want)
+		}
+	}
+	if !(&Repository{}).Allows("any.group") {
+		t.Error("repository without groups must allow any group")
+	}
+}
+
+func TestSnapshotPath(t *testing.T) {
+	const dir = "com/example/lib/1.0-SNAPSHOT"
+	tests := []struct {
+		name     string
+		metadata string
+		a        Artifact
+		want     string
+	}{
+		{
+			name: "snapshotVersions",
+			metadata: `<metadata><versioning>
+<snapshot><timestamp>20230101.120000</timestamp><buildNumber>3</buildNumber></snapshot>
+<snapshotVersions>
+<snapshotVersion><extension>pom</extension><value>1.0-20230101.120000-3</value></snapshotVersion>
+<snapshotVersion><classifier>sources</classifier><extension>jar</extension><value>1.0-20230101.115500-2</value></snapshotVersion>
+</snapshotVersions></versioning></metadata>`,
+			a:    Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0-SNAPSHOT", Classifier: "sources", Extension: "jar"},
+			want: dir + "/lib-1.0-20230101.115500-2-sources.jar",
+		},
+		{
+			name: "timestamp and build number",
+			metadata: `<metadata><versioning>
+<snapshot><timestamp>20230101.120000</timestamp><buildNumber>3</buildNumber></snapshot>
+</versioning></metadata>`,
+			a:    Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0-SNAPSHOT", Extension: "jar"},
+			want: dir + "/lib-1.0-20230101.120000-3.jar",
+		},
+		{
+			name: "no metadata",
+			a:    Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0-SNAPSHOT", Extension: "jar"},
+			want: dir + "/lib-1.0-SNAPSHOT.jar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{}
+			if tt.metadata != "" {
+				files[dir+"/maven-metadata.xml"] = tt.metadata
+			}
+			c := repoServer(t, files, nil)
+			if got := c.remotePath(context.Background(), c.Repositories[0], tt.a); got != tt.want {
+				t.Errorf("remotePath = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadSnapshot(t *testing.T) {
+	const dir = "com/example/lib/1.0-SNAPSHOT"
+	c := repoServer(t, map[string]string{
+		dir + "/maven-metadata.xml":            `<metadata><versioning><snapshot><timestamp>20230101.120000</timestamp><buildNumber>3</buildNumber></snapshot></versioning></metadata>`,
+		dir + "/lib-1.0-20230101.120000-3.jar": "jar",
+	}, nil)
+	out := t.TempDir()
+	a := Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0-SNAPSHOT", Extension: "jar"}
+	file, err := c.Download(context.Background(), c.Repositories[0], a, out)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	// Локально файл сохраняется под именем с версией SNAPSHOT, как в кеше сборки
+	if file != out+"/lib-1.0-SNAPSHOT.jar" {
+		t.Errorf("file = %q", file)
+	}
+	if b, _ := os.ReadFile(file); string(b) != "jar" {
+		t.Errorf("content = %q", b)
+	}
+}
+
+func TestFind(t *testing.T) {
+	var requests []string
+	c := repoServer(t, map[string]string{
+		"org/example/gradle-only/1.0/gradle-only-1.0.module": "{}",
+	}, &requests)
+	repo, err := c.Find(context.Background(), Artifact{GroupId: "org.example", ArtifactId: "gradle-only", Version: "1.0"})
+	if err != nil || repo != c.Repositories[0] {
+		t.Fatalf("Find = %v, %v", repo, err)
+	}
+	want := []string{"org/example/gradle-only/1.0/gradle-only-1.0.pom", "org/example/gradle-only/1.0/gradle-only-1.0.module"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+	_, err = c.Find(context.Background(), Artifact{GroupId: "org.example", ArtifactId: "missing", Version: "1.0"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+	c.Repositories[0].Groups = []string{"com.*"}
+	if _, err = c.Find(context.Background(), Artifact{GroupId: "org.example", ArtifactId: "gradle-only", Version: "1.0"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound for disallowed group", err)
+	}
+}
+
+func TestFindFailover(t *testing.T) {
+	const pom = "/com/example/lib/1.0/lib-1.0.pom"
+	var requests []string
+	record := func(name string, h http.HandlerFunc) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, name+" "+r.Method+" "+r.URL.Path)
+			h(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	failing := record("failing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	private := record("private", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "reader" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != pom {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<project/>"))
+	})
+	spring := record("spring", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<project/>"))
+	})
+	c := NewClient(nil,
+		Repository{Name: "spring", Url: spring.URL, Groups: []string{"org.springframework*"}},
+		Repository{Name: "failing", Url: failing.URL},
+		Repository{Name: "private", Url: private.URL, Username: "reader", Password: "secret"},
+	)
+	a := Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0"}
+	repo, err := c.Find(context.Background(), a)
+	if err != nil || repo != c.Repositories[2] {
+		t.Fatalf("Find = %v, %v, want private", repo, err)
+	}
+	// Репозиторий spring не разрешает groupId артефакта и не запрашивается, после ошибки failing поиск продолжается
+	want := []string{"failing HEAD " + pom, "private HEAD " + pom}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+
+	// Без учетных данных private отвечает 401, ошибка последнего репозитория возвращается вместо ErrNotFound
+	requests = nil
+	c.Repositories[2].Password = ""
+	_, err = c.Find(context.Background(), a)
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("err = %v, want status 401", err)
+	}
+
+	// Разрешенный groupId ищется и в репозитории с фильтром групп
+	requests = nil
+	repo, err = c.Find(context.Background(), Artifact{GroupId: "org.springframework.boot", ArtifactId: "boot", Version: "2.7.5"})
+	if err != nil || repo != c.Repositories[0] {
+		t.Fatalf("Find = %v, %v, want spring", repo, err)
+	}
+	if want := []string{"spring HEAD /org/springframework/boot/boot/2.7.5/boot-2.7.5.pom"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+func TestExistsHead(t *testing.T) {
+	const file = "com/example/lib/1.0/lib-1.0.pom"
+	for _, head := range []bool{true, false} {
+		var methods []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			if r.Method == http.MethodHead && !head {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if r.URL.Path != "/"+file {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte("<project/>"))
+		}))
+		c := NewClient(srv.Client(), Repository{Url: srv.URL})
+		a := Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0", Extension: "pom"}
+		ok, err := c.Exists(context.Background(), c.Repositories[0], a)
+		if err != nil || !ok {
+			t.Errorf("head %v: Exists = %v, %v", head, ok, err)
+		}
+		want := []string{http.MethodHead}
+		if !head {
+			want = append(want, http.MethodGet)
+		}
+		if !reflect.DeepEqual(methods, want) {
+			t.Errorf("head %v: methods = %q, want %q", head, methods, want)
+		}
+		ok, err = c.Exists(context.Background(), c.Repositories[0], a.WithFile("", "module"))
+		if err != nil || ok {
+			t.Errorf("head %v: Exists(missing) = %v, %v", head, ok, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestRepositoryTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-headers.jar":
+			time.Sleep(300 * time.Millisecond)
+		case "/slow-body.jar":
+			// Заголовки отправляются сразу, тело - дольше таймаута репозитория
+			w.Write([]byte("part1"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("part2"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(srv.Client(), Repository{Url: srv.URL, Timeout: 100 * time.Millisecond})
+	out := t.TempDir()
+	_, err := c.DownloadPath(context.Background(), c.Repositories[0], "slow-headers.jar", out+"/slow-headers.jar")
+	if err == nil || !strings.Contains(err.Error(), "no response in 100ms") {
+		t.Errorf("slow headers: err = %v, want timeout", err)
+	}
+	file, err := c.DownloadPath(context.Background(), c.Repositories[0], "slow-body.jar", out+"/slow-body.jar")
+	if err != nil {
+		t.Fatalf("slow body: %v", err)
+	}
+	if b, _ := os.ReadFile(file); string(b) != "part1part2" {
+		t.Errorf("slow body: content = %q", b)
+	}
+}
+
+func TestPluginMarker(t *testing.T) {
+	const dir = "org/springframework/boot/org.springframework.boot.gradle.plugin/2.7.5"
+	c := repoServer(t, map[string]string{
+		dir + "/org.springframework.boot.gradle.plugin-2.7.5.pom": `<project>
+<dependencies>
+<dependency><groupId>org.example</groupId><artifactId>templated</artifactId><version>${plugin.version}</version></dependency>
+<dependency><groupId>org.springframework.boot</groupId><artifactId>spring-boot-gradle-plugin</artifactId><version>2.7.5</version></dependency>
+</dependencies></project>`,
+		"com/example/empty.gradle.plugin/1.0/empty.gradle.plugin-1.0.pom": `<project></project>`,
+	}, nil)
+	marker := Artifact{GroupId: "org.springframework.boot", ArtifactId: "org.springframework.boot.gradle.plugin", Version: "2.7.5", Extension: "pom"}
+	a, err := c.PluginMarker(context.Background(), c.Repositories[0], marker)
+	if err != nil {
+		t.Fatalf("PluginMarker: %v", err)
+	}
+	want := Artifact{GroupId: "org.springframework.boot", ArtifactId: "spring-boot-gradle-plugin", Version: "2.7.5", Extension: "jar"}
+	if a != want {
+		t.Errorf("implementation = %+v, want %+v", a, want)
+	}
+	empty := Artifact{GroupId: "com.example", ArtifactId: "empty.gradle.plugin", Version: "1.0"}
+	if _, err := c.PluginMarker(context.Background(), c.Repositories[0], empty); err == nil {
+		t.Error("expected error for marker without implementation dependency")
+	}
+}
+
+func TestListing(t *testing.T) {
+	const dir = "com/example/lib/1.0"
+	c := repoServer(t, map[string]string{
+		dir + "/": `<html><body>
+<a href="../">../</a>
+<a href="lib-1.0.jar">lib-1.0.jar</a>
+<a href="lib-1.0-sources.jar">lib-1.0-sources.jar</a>
+<a href="sub/">sub/</a>
+<a href="https://other/x.jar">x</a>
+</body></html>`,
+	}, nil)
+	files, err := c.Listing(context.Background(), c.Repositories[0], Artifact{GroupId: "com.example", ArtifactId: "lib", Version: "1.0"})
+	if err != nil {
+		t.Fatalf("Listing: %v", err)
+	}
+	if want := []string{"lib-1.0.jar", "lib-1.0-sources.jar"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+}
@@ -0,0 +1,156 @@
+package maven
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxParentDepth Максимальная длина цепочки родительских pom при поиске секции scm
+const maxParentDepth = 10
+
+// Scm Секция scm pom: адрес репозитория для просмотра (url), адреса git (connection, developerConnection) и тег релиза
+type Scm struct {
+	Url                 string `xml:"url"`
+	Connection          string `xml:"connection"`
+	DeveloperConnection string `xml:"developerConnection"`
+	Tag                 string `xml:"tag"`
+}
+
+// Urls Возвращает заданные адреса репозитория в порядке connection, developerConnection, url
+func (s Scm) Urls() []string {
+	var urls []string
+	for _, u := range []string{s.Connection, s.DeveloperConnection, s.Url} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// Pom Координаты, родительский pom, свойства и секция scm pom артефакта
+type Pom struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Parent     struct {
+		GroupId    string `xml:"groupId"`
+		ArtifactId string `xml:"artifactId"`
+		Version    string `xml:"version"`
+	} `xml:"parent"`
+	Properties struct {
+		Entries []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"properties"`
+	Scm Scm `xml:"scm"`
+}
+
+// ParsePom Разбирает pom, charsetReader используется для pom не в UTF-8 и может быть nil
+func ParsePom(r io.Reader, charsetReader func(string, io.Reader) (io.Reader, error)) (*Pom, error) {
+	p := &Pom{}
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(p); err != nil {
+		return nil, err
+	}
+	// groupId и version без явного значения наследуются от родителя
+	if p.GroupId == "" {
+		p.GroupId = p.Parent.GroupId
+	}
+	if p.Version == "" {
+		p.Version = p.Parent.Version
+	}
+	return p, nil
+}
+
+// Pom Скачивает и разбирает pom артефакта a из первого репозитория, в котором он есть
+func (c *Client) Pom(ctx context.Context, a Artifact) (*Pom, error) {
+	a = a.WithFile("", "pom")
+	repo, err := c.Find(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.get(ctx, repo, repo.Url+"/"+c.remotePath(ctx, repo, a))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	p, err := ParsePom(resp.Body, c.CharsetReader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse pom of %s: %w", a, err)
+	}
+	return p, nil
+}
+
+// propertyRef Ссылка на свойство в значении pom
+var propertyRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Scm Возвращает секцию scm pom p, дополненную полями ближайших родительских pom, до первого pom с адресом
+// репозитория. В отличие от maven, к унаследованному url не добавляется artifactId: репозиторий многомодульного
+// проекта общий для всех модулей. Свойства ${project.*} и свойства из properties подставляются для артефакта p,
+// поля с неизвестными свойствами отбрасываются. Пустая Scm - секции нет во всей цепочке pom. При ошибке чтения
+// родительского pom возвращаются поля, найденные до него.
+func (c *Client) Scm(ctx context.Context, p *Pom) (Scm, error) {
+	var err error
+	props := map[string]string{
+		"project.groupId":    p.GroupId,
+		"project.artifactId": p.ArtifactId,
+		"project.version":    p.Version,
+		"groupId":            p.GroupId,
+		"artifactId":         p.ArtifactId,
+		"version":            p.Version,
+	}
+	scm := Scm{}
+	fields := func(s *Scm) []*string {
+		return []*string{&s.Url, &s.Connection, &s.DeveloperConnection, &s.Tag}
+	}
+	current := p
+	for depth := 0; ; depth++ {
+		for _, e := range current.Properties.Entries {
+			if _, ok := props[e.XMLName.Local]; !ok {
+				props[e.XMLName.Local] = strings.TrimSpace(e.Value)
+			}
+		}
+		dst, src := fields(&scm), fields(&current.Scm)
+		for i := range dst {
+			if *dst[i] == "" {
+				*dst[i] = strings.TrimSpace(*src[i])
+			}
+		}
+		parent := current.Parent
+		if len(scm.Urls()) > 0 || parent.ArtifactId == "" || depth >= maxParentDepth {
+			break
+		}
+		current, err = c.Pom(ctx, Artifact{GroupId: parent.GroupId, ArtifactId: parent.ArtifactId, Version: parent.Version})
+		if err != nil {
+			err = fmt.Errorf("unable to read parent pom %s:%s:%s: %w", parent.GroupId, parent.ArtifactId, parent.Version, err)
+			break
+		}
+	}
+	for _, f := range fields(&scm) {
+		*f = interpolate(*f, props)
+	}
+	return scm, err
+}
+
+// interpolate Подставляет свойства props в значение s. Если свойство не найдено, возвращается пустая строка.
+func interpolate(s string, props map[string]string) string {
+	// Свойства могут ссылаться на другие свойства, глубина подстановки ограничена
+	for i := 0; i < 5 && strings.Contains(s, "${"); i++ {
+		s = propertyRef.ReplaceAllStringFunc(s, func(ref string) string {
+			if v, ok := props[ref[2:len(ref)-1]]; ok {
+				return v
+			}
+			return ref
+		})
+	}
+	if strings.Contains(s, "${") {
+		return ""
+	}
+	return s
+}
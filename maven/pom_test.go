@@ -0,0 +1,34 @@
+package maven
+
+import "testing"
+
+func TestInterpolate(t *testing.T) {
+	props := map[string]string{
+		"project.version":    "1.2.3",
+		"project.artifactId": "lib",
+		"git.host":           "github.com",
+		"scm.base":           "https://${git.host}/org",
+		"scm.url":            "${scm.base}/${project.artifactId}",
+		"cycle.a":            "${cycle.b}",
+		"cycle.b":            "${cycle.a}",
+	}
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"plain", "plain"},
+		{"v${project.version}", "v1.2.3"},
+		{"${project.artifactId}-${project.version}", "lib-1.2.3"},
+		// Свойства, ссылающиеся на другие свойства
+		{"${scm.url}.git", "https://github.com/org/lib.git"},
+		{"${unknown}", ""},
+		{"v${project.version}-${unknown}", ""},
+		{"${cycle.a}", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := interpolate(tt.s, props); got != tt.want {
+			t.Errorf("interpolate(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
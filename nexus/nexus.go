@@ -1,109 +1,68 @@
 package nexus
 
 import (
-	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"sources/config"
 	"sources/logger"
-	"strconv"
-	"strings"
 )
 
 var (
 	Cfg *config.Configuration
 )
 
-func AddNexusToBuildGradle(file string, version string) error {
-	logger.Log.Debugf("Add local nexus to repositorines in file: %s", file)
-	f, err := os.OpenFile(file, os.O_RDWR, 0644)
-	if err != nil {
-		logger.Log.Errorf("Unable to open %s, error: %v", file, err)
-		return err
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	lines := []string{}
-	for scanner.Scan() {
-		ln := scanner.Text()
-		if strings.Contains(strings.ToLower(ln), "mavencentral()") {
-			gradleMajorVersion, _ := strconv.Atoi(strings.Split(version, ".")[0])
-			if err != nil {
-				logger.Log.Errorf("Could not get gradle major ver : %v", err)
-				return err
-			}
-			logger.Log.Tracef("Gradle Major version is %d", gradleMajorVersion)
-			if gradleMajorVersion > 5 {
-				logger.Log.Tracef("Found mavenCentral, add local nexus with insecure flag for gradle version %s", version)
-				lines = append(lines, "maven { url '"+Cfg.NexusMavenUrl+"' \nallowInsecureProtocol true\n  }")
-				continue
-			} else {
-				logger.Log.Tracef("Found mavenCentral, add local nexus after for gradle version %s", version)
-				lines = append(lines, "maven { url '"+Cfg.NexusMavenUrl+"' }")
-				continue
-			}
-		}
-		lines = append(lines, ln)
-	}
-	content := strings.Join(lines, "\n")
-	_, err = f.WriteAt([]byte(content), 0)
-	if err != nil {
-		logger.Log.Errorf("Error writing %s : %v", file, err)
-		return err
-	}
-	return nil
+// initScript Шаблон init скрипта gradle, добавляющего локальный nexus во все секции репозиториев сборки.
+// Скрипт написан на Groovy и подключается ключом -I, поэтому работает и для проектов на Kotlin DSL.
+// Возможности, появившиеся в новых версиях gradle, проверяются во время выполнения скрипта.
+const initScript = `// Сгенерировано services-revision-tool.
+// Добавляет локальный nexus в репозитории сборки без изменения build.gradle и settings.gradle сервиса.
+// Используется ключом -I при сборке и при оффлайн сборке из gradle_dependencies.
+import org.gradle.util.GradleVersion
+
+def nexusUrl = '%s'
+
+def addNexus = { RepositoryHandler repos ->
+    if (repos.findByName('servicesRevisionToolNexus') != null) {
+        return
+    }
+    repos.maven {
+        name = 'servicesRevisionToolNexus'
+        url = nexusUrl
+        if (GradleVersion.current() >= GradleVersion.version('6.0')) {
+            allowInsecureProtocol = true
+        }
+    }
 }
 
-func AddNexusToSettingsGradle(file string, version string) error {
-	logger.Log.Debugf("Add local nexus to repositorines in file: %s", file)
-	f, err := os.OpenFile(file, os.O_RDWR, 0644)
-	if err != nil {
-		logger.Log.Errorf("Unable to open %s, error: %v", file, err)
-		return err
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	lines := []string{}
-	add_status := false
-	gradleMajorVersion, _ := strconv.Atoi(strings.Split(version, ".")[0])
-	if err != nil {
-		logger.Log.Errorf("Could not get gradle major ver : %v", err)
-		return err
-	}
-	for scanner.Scan() {
-		ln := scanner.Text()
-		if strings.Contains(strings.ToLower(ln), "repositories") && !add_status {
-			logger.Log.Tracef("Gradle Major version is %d, settings gradle", gradleMajorVersion)
-			if gradleMajorVersion > 5 {
-				logger.Log.Tracef("Append settings.gradle with insecure flag for gradle version %s in file %s", version, file)
-				lines = append(lines, "repositories {\nmaven { url '"+Cfg.NexusMavenUrl+"' \nallowInsecureProtocol true\n}")
-				add_status = true
-				continue
-			} else {
-				logger.Log.Tracef("Append settings.gradle for gradle version %s in file %s", version, file)
-				lines = append(lines, "repositories {\nmaven { url '"+Cfg.NexusMavenUrl+"'}")
-				add_status = true
-				continue
-			}
-		}
+def repositoriesMode = null
 
-		lines = append(lines, ln)
-	}
-	if !add_status {
-		f := make([]string, len(lines)+1)
-		copy(f[1:], lines)
-		logger.Log.Tracef("Create plugins section in settings.gradle for gradle version %s in file %s", version, file)
-		if gradleMajorVersion > 5 {
-			f[0] = "pluginManagement {\nrepositories {\nmaven { url '" + Cfg.NexusMavenUrl + "' \nallowInsecureProtocol true\n}\n}\n}"
-		} else {
-			f[0] = "pluginManagement {\nrepositories {\nmaven { url '" + Cfg.NexusMavenUrl + "'}\n}\n}"
-		}
-		lines = f
-	}
-	content := strings.Join(lines, "\n")
-	_, err = f.WriteAt([]byte(content), 0)
+settingsEvaluated { settings ->
+    addNexus(settings.pluginManagement.repositories)
+    if (GradleVersion.current() >= GradleVersion.version('6.8')) {
+        addNexus(settings.dependencyResolutionManagement.repositories)
+        repositoriesMode = settings.dependencyResolutionManagement.repositoriesMode.get().name()
+    }
+}
+
+allprojects {
+    buildscript {
+        addNexus(repositories)
+    }
+    // Репозитории проекта запрещены режимом FAIL_ON_PROJECT_REPOS, в этом случае хватает dependencyResolutionManagement
+    if (repositoriesMode != 'FAIL_ON_PROJECT_REPOS') {
+        addNexus(repositories)
+    }
+}
+`
+
+// CreateInitScript Создает init скрипт gradle с локальным nexus (Cfg.NexusMavenUrl) в файле file
+func CreateInitScript(file string) error {
+	logger.Log.Debugf("Create gradle init script with local nexus: %s", file)
+	err := os.WriteFile(file, []byte(fmt.Sprintf(initScript, Cfg.NexusMavenUrl)), 0644)
 	if err != nil {
 		logger.Log.Errorf("Error writing %s : %v", file, err)
 		return err
@@ -111,11 +70,13 @@ func AddNexusToSettingsGradle(file string, version string) error {
 	return nil
 }
 
-func UploadNexus(f string, d string) error {
+// UploadNexus Загружает файл f в репозиторий nexus под именем d, загрузка прерывается при отмене ctx
+func UploadNexus(ctx context.Context, f string, d string) error {
 	arch, err := os.Open(f)
 	client := &http.Client{}
 	if err != nil {
-		logger.Log.Fatalf("Error opening archive file: %v", err)
+		logger.Log.Errorf("Error opening archive file: %v", err)
+		return err
 	}
 	defer arch.Close()
 	//	if Cfg.Proxy {
@@ -135,7 +96,7 @@ func UploadNexus(f string, d string) error {
 	//		client = &http.Client{}
 	//	}
 	payload := io.MultiReader(arch)
-	req, err := http.NewRequest("PUT", Cfg.NexusUrl+Cfg.NexusPath+"/"+d, payload)
+	req, err := http.NewRequestWithContext(ctx, "PUT", Cfg.NexusUrl+Cfg.NexusPath+"/"+d, payload)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,222 @@
+// scheduler Пакет общего планировщика http загрузок: ограничение числа одновременных запросов (всего и на хост),
+// ограничение частоты запросов к хосту (token bucket), повтор с экспоненциальной задержкой и учетом Retry-After
+package scheduler
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"sources/logger"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Config Параметры планировщика. Rate - запросов в секунду к одному хосту, 0 - без ограничения частоты.
+// Retries - число повторов после неудачной попытки, BackoffBase и BackoffMax - начальная и максимальная задержка повтора.
+type Config struct {
+	Concurrency int
+	PerHost     int
+	Rate        float64
+	Burst       int
+	Retries     int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+// Scheduler Планировщик запросов, общий для всех обрабатываемых сервисов
+type Scheduler struct {
+	cfg    Config
+	global chan struct{}
+	mu     sync.Mutex
+	hosts  map[string]*host
+}
+
+// host Ограничения одного хоста: слоты одновременных запросов и token bucket
+type host struct {
+	slots   chan struct{}
+	limiter *rate.Limiter
+}
+
+// New Создает планировщик, нулевые значения ограничений заменяются значениями по умолчанию
+func New(cfg Config) *Scheduler {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 16
+	}
+	if cfg.PerHost <= 0 || cfg.PerHost > cfg.Concurrency {
+		cfg.PerHost = cfg.Concurrency
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.PerHost
+	}
+	if cfg.BackoffBase <= 0 {
+		cfg.BackoffBase = time.Second
+	}
+	if cfg.BackoffMax < cfg.BackoffBase {
+		cfg.BackoffMax = cfg.BackoffBase
+	}
+	return &Scheduler{cfg: cfg, global: make(chan struct{}, cfg.Concurrency), hosts: make(map[string]*host)}
+}
+
+// Concurrency Возвращает общее ограничение одновременных запросов
+func (s *Scheduler) Concurrency() int {
+	return s.cfg.Concurrency
+}
+
+func (s *Scheduler) host(name string) *host {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[name]
+	if !ok {
+		limit := rate.Inf
+		if s.cfg.Rate > 0 {
+			limit = rate.Limit(s.cfg.Rate)
+		}
+		h = &host{slots: make(chan struct{}, s.cfg.PerHost), limiter: rate.NewLimiter(limit, s.cfg.Burst)}
+		s.hosts[name] = h
+	}
+	return h
+}
+
+// Transport Возвращает http транспорт, выполняющий запросы через планировщик поверх base (nil - http.DefaultTransport).
+// Слоты запроса освобождаются после закрытия тела ответа, поэтому тело ответа нужно закрывать до следующего запроса.
+// Повторяются запросы без тела, завершившиеся сетевой ошибкой или ответом 429 и 5xx.
+func (s *Scheduler) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{s: s, base: base}
+}
+
+type transport struct {
+	s    *Scheduler
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := t.s.host(req.URL.Host)
+	retries := t.s.cfg.Retries
+	if req.Body != nil && req.Body != http.NoBody {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		release, err := t.s.acquire(req, h)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && !retryable(resp.StatusCode) {
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		}
+		if attempt >= retries {
+			if err != nil {
+				release()
+				return nil, err
+			}
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		}
+		delay := t.s.backoff(attempt)
+		if err == nil {
+			if d, ok := retryAfter(resp); ok {
+				delay = d
+			}
+			logger.Log.Debugf("%s %s: status %d, retry in %s", req.Method, req.URL, resp.StatusCode, delay)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		} else {
+			logger.Log.Debugf("%s %s: %v, retry in %s", req.Method, req.URL, err, delay)
+		}
+		// Во время ожидания слоты свободны для других запросов
+		release()
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// acquire Занимает общий слот и слот хоста, затем ждет токен хоста. Возвращает функцию освобождения слотов.
+func (s *Scheduler) acquire(req *http.Request, h *host) (func(), error) {
+	ctx := req.Context()
+	select {
+	case s.global <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-s.global
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			<-h.slots
+			<-s.global
+		})
+	}
+	if err := h.limiter.Wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// backoff Возвращает задержку перед повтором attempt: экспоненциальная от BackoffBase, не больше BackoffMax,
+// со случайной составляющей в половину задержки, чтобы параллельные загрузки не повторялись одновременно
+func (s *Scheduler) backoff(attempt int) time.Duration {
+	d := s.cfg.BackoffBase
+	for i := 0; i < attempt && d < s.cfg.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > s.cfg.BackoffMax {
+		d = s.cfg.BackoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryAfter Читает заголовок Retry-After ответов 429 и 503: число секунд или дата
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// releaseBody Тело ответа, освобождающее слоты планировщика при закрытии
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,265 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// get Выполняет GET запрос через транспорт планировщика и закрывает тело ответа
+func get(t *testing.T, c *http.Client, url string) int {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Errorf("GET %s: %v", url, err)
+		return 0
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestTokenBucket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	s := New(Config{Rate: 20, Burst: 2})
+	c := &http.Client{Transport: s.Transport(srv.Client().Transport)}
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		get(t, c, srv.URL)
+	}
+	// Два запроса из burst сразу, остальные четыре - по одному в 50ms
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("6 requests at 20 rps with burst 2 took %s, want at least 200ms", elapsed)
+	}
+	// Ограничение частоты действует на каждый хост отдельно
+	other := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	start = time.Now()
+	get(t, c, other)
+	get(t, c, other)
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("burst of other host took %s", elapsed)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		hosts       int
+		wantPerHost int32
+		wantTotal   int32
+	}{
+		{name: "per host", cfg: Config{Concurrency: 10, PerHost: 2}, hosts: 1, wantPerHost: 2, wantTotal: 2},
+		{name: "per host on two hosts", cfg: Config{Concurrency: 10, PerHost: 2}, hosts: 2, wantPerHost: 2, wantTotal: 4},
+		{name: "global", cfg: Config{Concurrency: 3, PerHost: 2}, hosts: 2, wantPerHost: 2, wantTotal: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			current := make(map[string]int32)
+			maxHost := make(map[string]int32)
+			var total, maxTotal int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				current[r.Host]++
+				total++
+				if current[r.Host] > maxHost[r.Host] {
+					maxHost[r.Host] = current[r.Host]
+				}
+				if total > maxTotal {
+					maxTotal = total
+				}
+				mu.Unlock()
+				time.Sleep(30 * time.Millisecond)
+				mu.Lock()
+				current[r.Host]--
+				total--
+				mu.Unlock()
+			}))
+			defer srv.Close()
+			urls := []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)}[:tt.hosts]
+			c := &http.Client{Transport: New(tt.cfg).Transport(srv.Client().Transport)}
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				for _, u := range urls {
+					wg.Add(1)
+					go func(u string) {
+						defer wg.Done()
+						get(t, c, u)
+					}(u)
+				}
+			}
+			wg.Wait()
+			for h, m := range maxHost {
+				if m != tt.wantPerHost {
+					t.Errorf("max concurrent requests to %s = %d, want %d", h, m, tt.wantPerHost)
+				}
+			}
+			if maxTotal != tt.wantTotal {
+				t.Errorf("max concurrent requests = %d, want %d", maxTotal, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestSlotReleasedOnBodyClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	c := &http.Client{Transport: New(Config{Concurrency: 1}).Transport(srv.Client().Transport)}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Пока тело первого ответа не закрыто, слот занят
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := c.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded while slot is busy", err)
+	}
+	resp.Body.Close()
+	if status := get(t, c, srv.URL); status != http.StatusOK {
+		t.Errorf("status = %d after slot release", status)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		method   string
+		statuses []int
+		header   string
+		status   int
+		attempts int32
+		minDelay time.Duration
+	}{
+		{
+			name:     "Retry-After seconds",
+			cfg:      Config{Retries: 2, BackoffBase: time.Millisecond},
+			statuses: []int{http.StatusTooManyRequests},
+			header:   "1",
+			status:   http.StatusOK,
+			attempts: 2,
+			minDelay: time.Second,
+		},
+		{
+			name:     "Retry-After date",
+			cfg:      Config{Retries: 2, BackoffBase: time.Millisecond},
+			statuses: []int{http.StatusServiceUnavailable},
+			header:   time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat),
+			status:   http.StatusOK,
+			attempts: 2,
+		},
+		{
+			name:     "exponential backoff",
+			cfg:      Config{Retries: 3, BackoffBase: 40 * time.Millisecond, BackoffMax: time.Second},
+			statuses: []int{http.StatusBadGateway, http.StatusInternalServerError},
+			status:   http.StatusOK,
+			attempts: 3,
+			// Задержки не меньше половины 40ms и 80ms
+			minDelay: 60 * time.Millisecond,
+		},
+		{
+			name:     "retries exhausted",
+			cfg:      Config{Retries: 1, BackoffBase: time.Millisecond},
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			status:   http.StatusBadGateway,
+			attempts: 2,
+		},
+		{
+			name:     "not found is not retried",
+			cfg:      Config{Retries: 3, BackoffBase: time.Millisecond},
+			statuses: []int{http.StatusNotFound},
+			status:   http.StatusNotFound,
+			attempts: 1,
+		},
+		{
+			name:     "request with body is not retried",
+			cfg:      Config{Retries: 3, BackoffBase: time.Millisecond},
+			method:   http.MethodPut,
+			statuses: []int{http.StatusBadGateway},
+			status:   http.StatusBadGateway,
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				if int(n) <= len(tt.statuses) {
+					if tt.header != "" {
+						w.Header().Set("Retry-After", tt.header)
+					}
+					w.WriteHeader(tt.statuses[n-1])
+				}
+			}))
+			defer srv.Close()
+			c := &http.Client{Transport: New(tt.cfg).Transport(srv.Client().Transport)}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			var body io.Reader
+			if method == http.MethodPut {
+				body = strings.NewReader("artifact")
+			}
+			req, _ := http.NewRequest(method, srv.URL, body)
+			start := time.Now()
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", method, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("retried after %s, want at least %s", elapsed, tt.minDelay)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := &http.Client{Transport: New(Config{Retries: 3}).Transport(srv.Client().Transport)}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	if _, err := c.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled request waited %s", elapsed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := New(Config{BackoffBase: 100 * time.Millisecond, BackoffMax: 500 * time.Millisecond})
+	for attempt, max := range []time.Duration{100, 200, 400, 500, 500} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := s.backoff(attempt); d < max/2 || d > max {
+				t.Errorf("backoff(%d) = %s, want between %s and %s", attempt, d, max/2, max)
+			}
+		}
+	}
+}
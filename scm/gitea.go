@@ -0,0 +1,249 @@
+package scm
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sources/logger"
+	"strings"
+	"time"
+)
+
+// giteaPageSize Размер страницы списков Gitea API
+const giteaPageSize = 50
+
+// Gitea Хранилище Gitea, работающее через REST API v1
+type Gitea struct {
+	api    string
+	token  string
+	client *http.Client
+}
+
+// NewGitea Создает клиент Gitea API для сервера baseUrl (например, https://gitea.example.com)
+func NewGitea(baseUrl string, token string, skipTLS bool) *Gitea {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: skipTLS}
+	return &Gitea{
+		api:    strings.TrimSuffix(baseUrl, "/") + "/api/v1",
+		token:  token,
+		client: &http.Client{Transport: tr, Timeout: 10 * time.Minute},
+	}
+}
+
+// giteaRepo Репозиторий из ответа Gitea API
+type giteaRepo struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	CloneUrl string `json:"clone_url"`
+}
+
+// giteaCommit Коммит из ответа Gitea API
+type giteaCommit struct {
+	Sha    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string    `json:"name"`
+			Email string    `json:"email"`
+			Date  time.Time `json:"date"`
+		} `json:"author"`
+		Committer struct {
+			Date time.Time `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+}
+
+func (c giteaCommit) commit() *Commit {
+	return &Commit{
+		Sha:     c.Sha,
+		Title:   title(c.Commit.Message),
+		Message: c.Commit.Message,
+		Author:  author(c.Commit.Author.Name, c.Commit.Author.Email),
+		Date:    c.Commit.Committer.Date,
+	}
+}
+
+// get Выполняет GET запрос к API по пути path. Ответ 404 возвращается как ErrNotFound.
+func (g *Gitea) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.api+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.token != "" {
+		req.Header.Set("Authorization", "token "+g.token)
+	}
+	logger.Log.Tracef("Gitea request %s", path)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("gitea request %s: %s: %s", path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// getJSON Выполняет GET запрос к API и разбирает ответ в v
+func (g *Gitea) getJSON(ctx context.Context, path string, v interface{}) error {
+	b, err := g.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unable to parse gitea response %s: %w", path, err)
+	}
+	return nil
+}
+
+// repoPath Возвращает путь API репозитория проекта
+func repoPath(p *Project) string {
+	owner, name, _ := strings.Cut(p.ID, "/")
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+}
+
+// escapeSegments Экранирует части пути ref, сохраняя "/": Gitea принимает ветки вида release/1.0 без экранирования
+func escapeSegments(ref string) string {
+	parts := strings.Split(ref, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (g *Gitea) Name() string {
+	return "gitea"
+}
+
+// ListProjects Возвращает репозитории организации group, если организации нет - репозитории пользователя.
+// Вложенных групп в Gitea нет, subgroups не используется.
+func (g *Gitea) ListProjects(ctx context.Context, group string, subgroups bool) ([]*Project, error) {
+	var projects []*Project
+	base := "/orgs/" + url.PathEscape(group) + "/repos"
+	for page := 1; ; page++ {
+		var repos []giteaRepo
+		err := g.getJSON(ctx, fmt.Sprintf("%s?page=%d&limit=%d", base, page, giteaPageSize), &repos)
+		if errors.Is(err, ErrNotFound) && page == 1 && strings.HasPrefix(base, "/orgs/") {
+			base = "/users/" + url.PathEscape(group) + "/repos"
+			page = 0
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting repositories of %s: %w", group, err)
+		}
+		for _, r := range repos {
+			projects = append(projects, &Project{ID: r.FullName, Path: r.Name, FullPath: r.FullName, Url: r.CloneUrl})
+		}
+		if len(repos) < giteaPageSize {
+			break
+		}
+	}
+	return projects, nil
+}
+
+// Project Возвращает репозиторий по пути owner/name
+func (g *Gitea) Project(ctx context.Context, path string) (*Project, error) {
+	owner, name, ok := strings.Cut(path, "/")
+	if !ok || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid gitea repository path %q, should be owner/name", path)
+	}
+	var r giteaRepo
+	err := g.getJSON(ctx, "/repos/"+url.PathEscape(owner)+"/"+url.PathEscape(name), &r)
+	if err != nil {
+		return nil, err
+	}
+	return &Project{ID: r.FullName, Path: r.Name, FullPath: r.FullName, Url: r.CloneUrl}, nil
+}
+
+func (g *Gitea) ListTags(ctx context.Context, p *Project) ([]string, error) {
+	var names []string
+	for page := 1; ; page++ {
+		var tags []struct {
+			Name string `json:"name"`
+		}
+		err := g.getJSON(ctx, fmt.Sprintf("%s/tags?page=%d&limit=%d", repoPath(p), page, giteaPageSize), &tags)
+		if err != nil {
+			return names, fmt.Errorf("error getting tags of %s: %w", p.FullPath, err)
+		}
+		for _, t := range tags {
+			names = append(names, t.Name)
+		}
+		if len(tags) < giteaPageSize {
+			break
+		}
+	}
+	return names, nil
+}
+
+func (g *Gitea) HasBranch(ctx context.Context, p *Project, name string) (bool, error) {
+	_, err := g.get(ctx, repoPath(p)+"/branches/"+escapeSegments(name))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (g *Gitea) ResolveRef(ctx context.Context, p *Project, ref string) (*Commit, error) {
+	var commits []giteaCommit
+	err := g.getJSON(ctx, repoPath(p)+"/commits?limit=1&stat=false&sha="+url.QueryEscape(ref), &commits)
+	if err == nil && len(commits) == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve ref %s of project %s: %w", ref, p.FullPath, err)
+	}
+	return commits[0].commit(), nil
+}
+
+// FindCommit Просматривает последние depth коммитов ветки по умолчанию: Gitea не отдает коммиты всех веток одним списком
+func (g *Gitea) FindCommit(ctx context.Context, p *Project, depth int, match func(*Commit) bool) (*Commit, error) {
+	seen := 0
+	for page := 1; seen < depth; page++ {
+		var commits []giteaCommit
+		err := g.getJSON(ctx, fmt.Sprintf("%s/commits?page=%d&limit=%d&stat=false", repoPath(p), page, giteaPageSize), &commits)
+		if err != nil {
+			return nil, fmt.Errorf("error getting commits of %s: %w", p.FullPath, err)
+		}
+		for _, c := range commits {
+			if seen >= depth {
+				break
+			}
+			seen++
+			if commit := c.commit(); match(commit) {
+				return commit, nil
+			}
+		}
+		if len(commits) < giteaPageSize {
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (g *Gitea) Archive(ctx context.Context, p *Project, sha string, format string) ([]byte, error) {
+	ext, err := giteaArchiveFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return g.get(ctx, repoPath(p)+"/archive/"+url.PathEscape(sha)+"."+ext)
+}
+
+// giteaArchiveFormat Возвращает расширение архива Gitea для формата archive_format: Gitea отдает только zip, tar.gz и bundle
+func giteaArchiveFormat(format string) (string, error) {
+	switch format {
+	case "tgz", "tar.gz":
+		return "tar.gz", nil
+	case "zip":
+		return "zip", nil
+	}
+	return "", fmt.Errorf("archive format %q is not supported by gitea, should be tgz, tar.gz or zip", format)
+}
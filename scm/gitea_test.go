@@ -0,0 +1,40 @@
+package scm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGiteaArchive(t *testing.T) {
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+	g := NewGitea(srv.URL, "", false)
+	p := &Project{ID: "org/lib", Path: "lib", FullPath: "org/lib"}
+	tests := map[string]string{
+		"tgz":    "/api/v1/repos/org/lib/archive/abc123.tar.gz",
+		"tar.gz": "/api/v1/repos/org/lib/archive/abc123.tar.gz",
+		"zip":    "/api/v1/repos/org/lib/archive/abc123.zip",
+	}
+	for format, want := range tests {
+		requested = ""
+		if _, err := g.Archive(context.Background(), p, "abc123", format); err != nil {
+			t.Errorf("Archive(%s): %v", format, err)
+		}
+		if requested != want {
+			t.Errorf("Archive(%s) requested %s, want %s", format, requested, want)
+		}
+	}
+	// Форматы, которых нет в Gitea, не запрашиваются
+	for _, format := range []string{"tar.bz2", "tar"} {
+		requested = ""
+		if _, err := g.Archive(context.Background(), p, "abc123", format); err == nil || requested != "" {
+			t.Errorf("Archive(%s) = %v, requested %q, want error without request", format, err, requested)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package scm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	gitlab_helper "sources/gitlab"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Gitlab Хранилище GitLab, работающее через клиент gitlab API
+type Gitlab struct {
+	client *gitlab.Client
+}
+
+// NewGitlab Создает хранилище GitLab для клиента client
+func NewGitlab(client *gitlab.Client) *Gitlab {
+	return &Gitlab{client: client}
+}
+
+// GitlabProject Возвращает проект хранилища для проекта gitlab
+func GitlabProject(p *gitlab.Project) *Project {
+	return &Project{ID: strconv.Itoa(p.ID), Path: strings.TrimSpace(p.Path), FullPath: p.PathWithNamespace, Url: p.HTTPURLToRepo}
+}
+
+func (g *Gitlab) Name() string {
+	return "gitlab"
+}
+
+// id Возвращает числовой id проекта gitlab
+func (g *Gitlab) id(p *Project) (int, error) {
+	id, err := strconv.Atoi(p.ID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gitlab project id %q of %s", p.ID, p.FullPath)
+	}
+	return id, nil
+}
+
+func (g *Gitlab) ListProjects(ctx context.Context, group string, subgroups bool) ([]*Project, error) {
+	projects, err := gitlab_helper.GetProjectsInGroup(ctx, g.client, group, subgroups)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Project
+	for _, p := range projects {
+		result = append(result, GitlabProject(p))
+	}
+	return result, nil
+}
+
+func (g *Gitlab) Project(ctx context.Context, path string) (*Project, error) {
+	project, err := gitlab_helper.GetProject(ctx, g.client, path)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, ErrNotFound
+	}
+	return GitlabProject(project), nil
+}
+
+func (g *Gitlab) ListTags(ctx context.Context, p *Project) ([]string, error) {
+	id, err := g.id(p)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := gitlab_helper.GetProjectTag(ctx, g.client, id)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	return names, nil
+}
+
+func (g *Gitlab) HasBranch(ctx context.Context, p *Project, name string) (bool, error) {
+	id, err := g.id(p)
+	if err != nil {
+		return false, err
+	}
+	branch, err := gitlab_helper.GetProjectBranch(ctx, g.client, id, name)
+	return branch != nil, err
+}
+
+func (g *Gitlab) ResolveRef(ctx context.Context, p *Project, ref string) (*Commit, error) {
+	id, err := g.id(p)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := gitlab_helper.ResolveCommit(ctx, g.client, id, ref)
+	var er *gitlab.ErrorResponse
+	if errors.As(err, &er) && er.Response != nil && er.Response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("unable to resolve ref %s of project %s: %w", ref, p.FullPath, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gitlabCommit(commit), nil
+}
+
+func (g *Gitlab) FindCommit(ctx context.Context, p *Project, depth int, match func(*Commit) bool) (*Commit, error) {
+	id, err := g.id(p)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := gitlab_helper.FindCommit(ctx, g.client, id, depth, func(c *gitlab.Commit) bool {
+		return match(gitlabCommit(c))
+	})
+	if err != nil || commit == nil {
+		return nil, err
+	}
+	return gitlabCommit(commit), nil
+}
+
+func (g *Gitlab) Archive(ctx context.Context, p *Project, sha string, format string) ([]byte, error) {
+	id, err := g.id(p)
+	if err != nil {
+		return nil, err
+	}
+	return gitlab_helper.GetProjectArchive(ctx, g.client, id, &format, &sha)
+}
+
+// gitlabCommit Возвращает коммит хранилища для коммита gitlab
+func gitlabCommit(c *gitlab.Commit) *Commit {
+	commit := &Commit{Sha: c.ID, Title: c.Title, Message: c.Message, Author: author(c.AuthorName, c.AuthorEmail)}
+	if c.CommittedDate != nil {
+		commit.Date = *c.CommittedDate
+	}
+	return commit
+}
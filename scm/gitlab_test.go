@@ -0,0 +1,58 @@
+package scm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestGitlabResolveRef(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/7/repository/commits/v1.0":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": "abc123", "title": "release 1.0", "author_name": "Dev", "author_email": "dev@example.com"}`))
+		case "/api/v4/projects/7/repository/commits/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "404 Commit Not Found"}`))
+		}
+	}))
+	defer srv.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(srv.URL), gitlab.WithoutRetries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGitlab(client)
+	p := &Project{ID: "7", Path: "lib", FullPath: "group/lib"}
+	commit, err := g.ResolveRef(context.Background(), p, "v1.0")
+	if err != nil || commit.Sha != "abc123" || commit.Author != "Dev (dev@example.com)" {
+		t.Errorf("ResolveRef(v1.0) = %+v, %v", commit, err)
+	}
+	if _, err := g.ResolveRef(context.Background(), p, "v2.0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveRef(v2.0) err = %v, want ErrNotFound", err)
+	}
+	if _, err := g.ResolveRef(context.Background(), p, "broken"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveRef(broken) err = %v, want server error", err)
+	}
+}
+
+func TestProjectsMap(t *testing.T) {
+	core := &Project{Path: "idm", FullPath: "pgs/core/idm"}
+	api := &Project{Path: "idm", FullPath: "pgs/api/idm"}
+	auth := &Project{Path: "auth", FullPath: "pgs/auth"}
+	m, ambiguous := ProjectsMap([]*Project{core, auth, api, core})
+	want := map[string]*Project{"pgs/core/idm": core, "pgs/api/idm": api, "pgs/auth": auth, "auth": auth}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("projects = %v, want %v", m, want)
+	}
+	if want := map[string][]string{"idm": {"pgs/api/idm", "pgs/core/idm"}}; !reflect.DeepEqual(ambiguous, want) {
+		t.Errorf("ambiguous = %v, want %v", ambiguous, want)
+	}
+}
@@ -0,0 +1,172 @@
+package scm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Local Хранилище из локальных bare репозиториев (зеркал) в каталоге root, работающее через git cli.
+// Группа - каталог относительно root, проект - bare репозиторий, имя проекта - имя каталога без суффикса .git.
+type Local struct {
+	root string
+}
+
+// NewLocal Создает хранилище для каталога зеркал root
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// logFormat Формат коммита git log/show: SHA, автор, email, дата коммита и сообщение, разделенные NUL, коммиты - символом RS
+const logFormat = "--format=%H%x00%an%x00%ae%x00%cI%x00%B%x1e"
+
+// localArchiveFormats Форматы git archive: tgz и tar.gz - встроенные фильтры tar через gzip
+var localArchiveFormats = []string{"tgz", "tar.gz", "tar", "zip"}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+// git Выполняет команду git в bare репозитории проекта p
+func (l *Local) git(ctx context.Context, p *Project, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--git-dir", p.ID}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s in %s: %w: %s", args[0], p.ID, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// ListProjects Возвращает bare репозитории каталога group (относительно root), с subgroups - и вложенных каталогов
+func (l *Local) ListProjects(ctx context.Context, group string, subgroups bool) ([]*Project, error) {
+	dir := filepath.Join(l.root, group)
+	var projects []*Project
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isBareRepo(path) {
+			rel, err := filepath.Rel(l.root, path)
+			if err != nil {
+				return err
+			}
+			full := strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+			projects = append(projects, &Project{ID: path, Path: strings.TrimSuffix(d.Name(), ".git"), FullPath: full, Url: path})
+			return filepath.SkipDir
+		}
+		if path != dir && !subgroups {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing mirrors in %s: %w", dir, err)
+	}
+	return projects, nil
+}
+
+// Project Возвращает bare репозиторий path.git или path относительно root. Пути вне root не ищутся.
+func (l *Local) Project(ctx context.Context, path string) (*Project, error) {
+	full := strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if !filepath.IsLocal(full) {
+		return nil, ErrNotFound
+	}
+	for _, dir := range []string{filepath.Join(l.root, full+".git"), filepath.Join(l.root, full)} {
+		if isBareRepo(dir) {
+			return &Project{ID: dir, Path: filepath.Base(full), FullPath: full, Url: dir}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// isBareRepo Проверяет, что каталог является bare репозиторием git
+func isBareRepo(dir string) bool {
+	for _, f := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Local) ListTags(ctx context.Context, p *Project) ([]string, error) {
+	out, err := l.git(ctx, p, "for-each-ref", "--format=%(refname:short)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+func (l *Local) HasBranch(ctx context.Context, p *Project, name string) (bool, error) {
+	out, err := l.git(ctx, p, "for-each-ref", "--format=%(refname)", "refs/heads/"+name)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) == "refs/heads/"+name, nil
+}
+
+func (l *Local) ResolveRef(ctx context.Context, p *Project, ref string) (*Commit, error) {
+	out, err := l.git(ctx, p, "show", "-s", logFormat, ref+"^{commit}", "--")
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve ref %s of project %s: %w", ref, p.FullPath, err)
+	}
+	commits := parseLog(out)
+	if len(commits) == 0 {
+		return nil, ErrNotFound
+	}
+	return commits[0], nil
+}
+
+func (l *Local) FindCommit(ctx context.Context, p *Project, depth int, match func(*Commit) bool) (*Commit, error) {
+	out, err := l.git(ctx, p, "log", "--all", "--date-order", fmt.Sprintf("--max-count=%d", depth), logFormat)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range parseLog(out) {
+		if match(c) {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// Archive Возвращает архив коммита с каталогом верхнего уровня как в архиве gitlab
+func (l *Local) Archive(ctx context.Context, p *Project, sha string, format string) ([]byte, error) {
+	if !slices.Contains(localArchiveFormats, format) {
+		return nil, fmt.Errorf("archive format %q is not supported by git archive, should be tgz, tar.gz, tar or zip", format)
+	}
+	return l.git(ctx, p, "archive", "--format="+format, "--prefix="+p.Path+"-"+sha+"-"+sha+"/", sha)
+}
+
+// parseLog Разбирает вывод git log в формате logFormat
+func parseLog(out []byte) []*Commit {
+	var commits []*Commit
+	for _, rec := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(rec, "\n"), "\x00", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[3])
+		commits = append(commits, &Commit{
+			Sha:     fields[0],
+			Author:  author(fields[1], fields[2]),
+			Date:    date,
+			Title:   title(fields[4]),
+			Message: fields[4],
+		})
+	}
+	return commits
+}
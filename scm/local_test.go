@@ -0,0 +1,80 @@
+package scm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// bareRepo Создает bare репозиторий name.git в root с одним коммитом файла pom.xml и возвращает SHA коммита
+func bareRepo(t *testing.T, root string, name string) string {
+	t.Helper()
+	work := t.TempDir()
+	run := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return string(bytes.TrimSpace(out))
+	}
+	run(work, "init", "-q")
+	os.WriteFile(filepath.Join(work, "pom.xml"), []byte("<project/>"), 0644)
+	run(work, "add", "pom.xml")
+	run(work, "commit", "-q", "-m", "initial")
+	run(work, "clone", "-q", "--bare", work, filepath.Join(root, name+".git"))
+	return run(work, "rev-parse", "HEAD")
+}
+
+func TestLocalArchive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	sha := bareRepo(t, root, "org/lib")
+	l := NewLocal(root)
+	p, err := l.Project(context.Background(), "org/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Заголовки форматов: gzip для tgz и tar.gz, zip, tar с каталогом верхнего уровня как в архиве gitlab
+	tests := map[string]func([]byte) bool{
+		"tgz":    func(b []byte) bool { return bytes.HasPrefix(b, []byte{0x1f, 0x8b}) },
+		"tar.gz": func(b []byte) bool { return bytes.HasPrefix(b, []byte{0x1f, 0x8b}) },
+		"zip": func(b []byte) bool {
+			_, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+			return err == nil
+		},
+		"tar": func(b []byte) bool {
+			r := tar.NewReader(bytes.NewReader(b))
+			for {
+				h, err := r.Next()
+				if err != nil {
+					return false
+				}
+				if h.Name == "lib-"+sha+"-"+sha+"/pom.xml" {
+					return true
+				}
+			}
+		},
+	}
+	for format, valid := range tests {
+		b, err := l.Archive(context.Background(), p, sha, format)
+		if err != nil {
+			t.Errorf("Archive(%s): %v", format, err)
+			continue
+		}
+		if !valid(b) {
+			t.Errorf("Archive(%s) returned unexpected content", format)
+		}
+	}
+	if _, err := l.Archive(context.Background(), p, sha, "tar.bz2"); err == nil {
+		t.Error("expected error for tar.bz2")
+	}
+}
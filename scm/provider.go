@@ -0,0 +1,101 @@
+// scm Пакет для получения исходных кодов внутренних зависимостей из хранилищ git: GitLab, Gitea и локальных bare репозиториев
+package scm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gitlab_helper "sources/gitlab"
+)
+
+// ErrNotFound Проект, ref или ветка отсутствуют в хранилище
+var ErrNotFound = errors.New("not found")
+
+// Project Проект хранилища: ID - идентификатор в хранилище (id gitlab, owner/name gitea, путь к bare репозиторию),
+// Path - имя проекта, FullPath - полный путь с группами, Url - адрес для клонирования
+type Project struct {
+	ID       string
+	Path     string
+	FullPath string
+	Url      string
+}
+
+// Commit Коммит проекта: SHA, первая строка и полное сообщение, автор в формате "Имя (email)" и дата коммита
+type Commit struct {
+	Sha     string
+	Title   string
+	Message string
+	Author  string
+	Date    time.Time
+}
+
+// SourceProvider Хранилище исходных кодов: список проектов группы, проект по пути, теги и ветки проекта, поиск коммитов и архив по SHA
+type SourceProvider interface {
+	// Name Возвращает тип хранилища для логов и отчета
+	Name() string
+	// ListProjects Возвращает проекты группы (для gitea - организации или пользователя, для local - каталога),
+	// с subgroups - и проекты вложенных групп
+	ListProjects(ctx context.Context, group string, subgroups bool) ([]*Project, error)
+	// Project Возвращает проект по полному пути или ErrNotFound
+	Project(ctx context.Context, path string) (*Project, error)
+	// ListTags Возвращает имена всех тегов проекта
+	ListTags(ctx context.Context, p *Project) ([]string, error)
+	// HasBranch Проверяет наличие ветки name
+	HasBranch(ctx context.Context, p *Project, name string) (bool, error)
+	// ResolveRef Возвращает коммит, на который указывает ref (ветка, тег или SHA), или ErrNotFound
+	ResolveRef(ctx context.Context, p *Project, ref string) (*Commit, error)
+	// FindCommit Возвращает первый из последних depth коммитов проекта, для которого match вернул true, или nil
+	FindCommit(ctx context.Context, p *Project, depth int, match func(*Commit) bool) (*Commit, error)
+	// Archive Возвращает архив исходных кодов коммита sha в формате format (tar.gz, zip)
+	Archive(ctx context.Context, p *Project, sha string, format string) ([]byte, error)
+}
+
+// Options Параметры хранилища из источника sources: Url - адрес API gitea или каталог local, Token - токен доступа
+type Options struct {
+	Url     string
+	Token   string
+	SkipTLS bool
+}
+
+// New Возвращает хранилище по типу из конфига: gitea или local. Хранилище gitlab создается через NewGitlab
+// из клиента, общего с получением сервисов.
+func New(kind string, opts Options) (SourceProvider, error) {
+	switch kind {
+	case "gitea":
+		if opts.Url == "" {
+			return nil, errors.New("gitea source provider requires url")
+		}
+		return NewGitea(opts.Url, opts.Token, opts.SkipTLS), nil
+	case "local":
+		if opts.Url == "" {
+			return nil, errors.New("local source provider requires url with mirrors directory")
+		}
+		return NewLocal(opts.Url), nil
+	}
+	return nil, fmt.Errorf("unknown source provider %q, supported: gitlab, gitea, local", kind)
+}
+
+// ProjectsMap Возвращает проекты по полному пути и по имени, если оно однозначно.
+// Имена, которые есть у нескольких проектов, возвращаются вторым значением с полными путями этих проектов.
+func ProjectsMap(projects []*Project) (map[string]*Project, map[string][]string) {
+	return gitlab_helper.MapProjects(projects, func(p *Project) (string, string) {
+		return p.FullPath, p.Path
+	})
+}
+
+// author Возвращает автора коммита в формате "Имя (email)"
+func author(name string, email string) string {
+	if email == "" {
+		return name
+	}
+	return name + " (" + email + ")"
+}
+
+// title Возвращает первую строку сообщения коммита
+func title(message string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return title
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sources/config"
+	"sources/container"
+	"sources/depgraph"
+	"sources/dockerfile"
+	"sources/nexus"
+	"strings"
+	"testing"
+)
+
+// inExecutableDir Переходит в каталог тестового бинарника: пути сборки строятся от каталога исполняемого файла,
+// как при запуске утилиты из ее каталога. Созданный каталог dir удаляется после теста.
+func inExecutableDir(t *testing.T, dir string) string {
+	t.Helper()
+	ex, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exDir := filepath.Dir(ex)
+	if err := os.Chdir(exDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Join(exDir, dir))
+		os.Chdir(wd)
+	})
+	return exDir
+}
+
+func writeFile(t *testing.T, file string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildRunOptions(t *testing.T) {
+	Cfg = &config.Configuration{}
+	plan := &BuildPlan{
+		Image:    dockerfile.ParseImageRef("registry.local:5000/gradle:7.4.1-jdk11"),
+		Commands: []string{"./gradlew clean", "./gradlew build -x test"},
+	}
+	mvnPlan := &BuildPlan{
+		Image:    dockerfile.ParseImageRef("maven:3.8.6-openjdk-11"),
+		Commands: []string{"mvn -B package -DskipTests"},
+	}
+	tests := []struct {
+		name       string
+		tool       BuildTool
+		dir        string
+		initScript string
+		want       container.RunOptions
+	}{
+		{
+			name: "gradle",
+			tool: Gradle,
+			dir:  "/work/out dir/my svc/src",
+			want: container.RunOptions{
+				Image:   "registry.local:5000/gradle:7.4.1-jdk11",
+				User:    "1000",
+				WorkDir: "/home/gradle",
+				Env:     []string{"GRADLE_USER_HOME=gradle_cache"},
+				Mounts:  []container.Mount{{Source: "/work/out dir/my svc/src", Target: "/home/gradle"}},
+				Cmd:     []string{"bash", "-c", "./gradlew clean -g gradle_cache -q && ./gradlew build -x test -g gradle_cache -q"},
+			},
+		},
+		{
+			name:       "gradle with init script",
+			tool:       Gradle,
+			dir:        "/work/src",
+			initScript: "/work/out dir/gradle_configs/init.gradle",
+			want: container.RunOptions{
+				Image:   "registry.local:5000/gradle:7.4.1-jdk11",
+				User:    "1000",
+				WorkDir: "/home/gradle",
+				Env:     []string{"GRADLE_USER_HOME=gradle_cache"},
+				Mounts: []container.Mount{
+					{Source: "/work/src", Target: "/home/gradle"},
+					{Source: "/work/out dir/gradle_configs/init.gradle", Target: "/tmp/init.gradle", ReadOnly: true},
+				},
+				Cmd: []string{"bash", "-c", "./gradlew clean -g gradle_cache -I /tmp/init.gradle -q && ./gradlew build -x test -g gradle_cache -I /tmp/init.gradle -q"},
+			},
+		},
+		{
+			name: "maven",
+			tool: Maven,
+			dir:  "/work/my svc",
+			want: container.RunOptions{
+				Image:   "maven:3.8.6-openjdk-11",
+				User:    "1000",
+				WorkDir: "/build",
+				Mounts:  []container.Mount{{Source: "/work/my svc", Target: "/build"}},
+				Cmd:     []string{"bash", "-c", "mvn -B package -DskipTests -Dmaven.repo.local=/build/maven_cache -q"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := plan
+			if tt.tool == Maven {
+				p = mvnPlan
+			}
+			if got := buildRunOptions(tt.tool, tt.dir, p, tt.initScript); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildModule(t *testing.T) {
+	exDir := inExecutableDir(t, "out dir")
+	Cfg = &config.Configuration{Output_dir: "out dir", BuildStagePattern: config.DefaultBuildStagePattern, NexusAutoAddToGradle: true, NexusMavenUrl: "http://nexus.local/repository/maven"}
+	nexus.Cfg = Cfg
+	src := "out dir/my svc/src"
+	writeFile(t, src+"/Dockerfile", "FROM gradle:7.4.1-jdk11 AS build\nRUN gradle build\n")
+	writeFile(t, src+"/build.gradle", "plugins { id 'java' }\n")
+	fake := newFakeRuntime()
+	// Первая сборка падает, повторная идет с init скриптом nexus
+	fake.OnRun = func(opts container.RunOptions) error {
+		if len(fake.Runs) == 1 {
+			return &container.ExitError{Code: 1}
+		}
+		return nil
+	}
+	Runtime = fake
+	m := &Module{Name: "root", Dockerfile: src + "/Dockerfile", Tool: Gradle}
+	if err := buildModule(context.Background(), "my svc", "group/my-svc", m, false); err != nil {
+		t.Fatalf("buildModule: %v", err)
+	}
+	if m.Image != "gradle:7.4.1-jdk11" || m.Stage != "build" || !m.InitScript {
+		t.Errorf("module = %+v", m)
+	}
+	if !reflect.DeepEqual(fake.Pulls, []string{"gradle:7.4.1-jdk11"}) {
+		t.Errorf("pulls = %v", fake.Pulls)
+	}
+	// Сборка, повторная сборка с init скриптом и задача графа зависимостей
+	if len(fake.Runs) != 3 {
+		t.Fatalf("runs = %d, want 3", len(fake.Runs))
+	}
+	mounts := []container.Mount{
+		{Source: exDir + "/" + src, Target: "/home/gradle"},
+		{Source: exDir + "/out dir/my svc/gradle_configs/init.gradle", Target: "/tmp/init.gradle", ReadOnly: true},
+	}
+	if !reflect.DeepEqual(fake.Runs[0].Mounts, mounts[:1]) {
+		t.Errorf("first run mounts = %+v", fake.Runs[0].Mounts)
+	}
+	if !reflect.DeepEqual(fake.Runs[1].Mounts, mounts) {
+		t.Errorf("retry mounts = %+v", fake.Runs[1].Mounts)
+	}
+	if fake.Runs[1].Network != "" || !reflect.DeepEqual(fake.Runs[1].Env, []string{"GRADLE_USER_HOME=gradle_cache"}) {
+		t.Errorf("retry network %q, env %v", fake.Runs[1].Network, fake.Runs[1].Env)
+	}
+	if cmd := fake.Runs[2].Cmd[2]; !strings.Contains(cmd, "-I /tmp/init.gradle") || !strings.HasSuffix(cmd, " "+depgraph.TaskName) {
+		t.Errorf("graph command = %q", cmd)
+	}
+	if b, err := os.ReadFile("out dir/my svc/gradle_configs/init.gradle"); err != nil || !strings.Contains(string(b), Cfg.NexusMavenUrl) {
+		t.Errorf("init script not created: %v", err)
+	}
+	if _, err := os.Stat("out dir/my svc/gradle_configs/build.gradle"); err != nil {
+		t.Errorf("build.gradle not copied: %v", err)
+	}
+}
+
+func TestBuildModuleFailed(t *testing.T) {
+	inExecutableDir(t, "out dir")
+	Cfg = &config.Configuration{Output_dir: "out dir", BuildStagePattern: config.DefaultBuildStagePattern}
+	src := "out dir/svc/src"
+	writeFile(t, src+"/Dockerfile", "FROM maven:3.8.6-openjdk-11 AS build\nRUN mvn -B package\n")
+	fake := newFakeRuntime("maven:3.8.6-openjdk-11")
+	fake.OnRun = func(opts container.RunOptions) error {
+		opts.Stdout.Write([]byte("[ERROR] Failed to execute goal\n"))
+		return &container.ExitError{Code: 1}
+	}
+	Runtime = fake
+	m := &Module{Name: "root", Dockerfile: src + "/Dockerfile", Tool: Maven}
+	if err := buildModule(context.Background(), "svc", "group/svc", m, false); err == nil {
+		t.Fatal("expected build error")
+	}
+	if len(fake.Pulls) != 0 || len(fake.Runs) != 1 {
+		t.Errorf("pulls = %v, runs = %d", fake.Pulls, len(fake.Runs))
+	}
+	if fake.Runs[0].WorkDir != "/build" || fake.Runs[0].Mounts[0].Target != "/build" {
+		t.Errorf("run = %+v", fake.Runs[0])
+	}
+}
+
+func TestExportImages(t *testing.T) {
+	Cfg = &config.Configuration{Output_dir: filepath.Join(t.TempDir(), "out dir")}
+	dir := Cfg.Output_dir + "/my svc/docker_images"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeRuntime("gradle:7.4.1-jdk11")
+	Runtime = fake
+	modules := []Module{
+		{Name: "app", Image: "gradle:7.4.1-jdk11"},
+		{Name: "lib", Image: "registry.local:5000/tools/maven:3.8.6"},
+		{Name: "cli", Image: "gradle:7.4.1-jdk11"},
+	}
+	if err := exportImages(context.Background(), "my svc", modules); err != nil {
+		t.Fatalf("exportImages: %v", err)
+	}
+	want := []saveCall{
+		{Image: "gradle:7.4.1-jdk11", File: dir + "/gradle_7_4_1_jdk11.tar"},
+		{Image: "registry.local:5000/tools/maven:3.8.6", File: dir + "/registry_local_5000_tools_maven_3_8_6.tar"},
+	}
+	if !reflect.DeepEqual(fake.Saves, want) {
+		t.Errorf("saves = %+v\nwant %+v", fake.Saves, want)
+	}
+	if !reflect.DeepEqual(fake.Pulls, []string{"registry.local:5000/tools/maven:3.8.6"}) {
+		t.Errorf("pulls = %v", fake.Pulls)
+	}
+	// Уже выгруженные образы пропускаются
+	if err := exportImages(context.Background(), "my svc", modules); err != nil {
+		t.Fatalf("exportImages: %v", err)
+	}
+	if len(fake.Saves) != 2 {
+		t.Errorf("saves after second export = %d, want 2", len(fake.Saves))
+	}
+	// Образ из архива загружается в рантайм при проверке оффлайн сборки
+	loaded := newFakeRuntime()
+	if err := loaded.Load(context.Background(), want[1].File); err != nil || !loaded.Images[want[1].Image] {
+		t.Errorf("image not loaded from %s: %v", want[1].File, err)
+	}
+}
+
+func TestExportImagesPullError(t *testing.T) {
+	Cfg = &config.Configuration{Output_dir: t.TempDir()}
+	os.MkdirAll(Cfg.Output_dir+"/svc/docker_images", 0755)
+	fake := newFakeRuntime()
+	fake.PullErr = errors.New("registry unavailable")
+	Runtime = fake
+	err := exportImages(context.Background(), "svc", []Module{{Name: "root", Image: "gradle:7.4.1"}})
+	if !errors.Is(err, fake.PullErr) {
+		t.Errorf("err = %v, want %v", err, fake.PullErr)
+	}
+	if len(fake.Saves) != 0 {
+		t.Errorf("saves = %+v", fake.Saves)
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"os"
+	"sources/container"
+	"sync"
+)
+
+// saveCall Вызов выгрузки образа в архив
+type saveCall struct {
+	Image string
+	File  string
+}
+
+// fakeRuntime Рантайм в памяти для тестов: запоминает вызовы и хранит список образов без обращения к docker или podman.
+// Save записывает в архив имя образа, Load добавляет образ из такого архива в хранилище.
+type fakeRuntime struct {
+	mu     sync.Mutex
+	Images map[string]bool //Images образы в локальном хранилище
+	Runs   []container.RunOptions
+	Pulls  []string
+	Saves  []saveCall
+	Loads  []string
+	// OnRun Вызывается при запуске контейнера и эмулирует сборку, результат возвращается из Run. Может быть nil.
+	OnRun func(opts container.RunOptions) error
+	// PullErr Ошибка, которую возвращает Pull
+	PullErr error
+}
+
+// newFakeRuntime Создает рантайм в памяти с образами images в локальном хранилище
+func newFakeRuntime(images ...string) *fakeRuntime {
+	f := &fakeRuntime{Images: make(map[string]bool)}
+	for _, i := range images {
+		f.Images[i] = true
+	}
+	return f
+}
+
+// Run Запоминает параметры запуска и вызывает OnRun
+func (f *fakeRuntime) Run(ctx context.Context, opts container.RunOptions) error {
+	f.mu.Lock()
+	f.Runs = append(f.Runs, opts)
+	onRun := f.OnRun
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if onRun != nil {
+		return onRun(opts)
+	}
+	return nil
+}
+
+// Pull Запоминает загрузку и добавляет образ в хранилище, если не задана PullErr
+func (f *fakeRuntime) Pull(ctx context.Context, image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Pulls = append(f.Pulls, image)
+	if f.PullErr != nil {
+		return f.PullErr
+	}
+	f.Images[image] = true
+	return nil
+}
+
+// Inspect Возвращает сведения об образе из хранилища или container.ErrImageNotFound
+func (f *fakeRuntime) Inspect(ctx context.Context, image string) (*container.ImageInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.Images[image] {
+		return nil, container.ErrImageNotFound
+	}
+	return &container.ImageInfo{ID: image, RepoTags: []string{image}}, nil
+}
+
+// Save Запоминает выгрузку и записывает имя образа в file
+func (f *fakeRuntime) Save(ctx context.Context, image string, file string) error {
+	f.mu.Lock()
+	f.Saves = append(f.Saves, saveCall{Image: image, File: file})
+	known := f.Images[image]
+	f.mu.Unlock()
+	if !known {
+		return container.ErrImageNotFound
+	}
+	return os.WriteFile(file, []byte(image), 0644)
+}
+
+// Load Запоминает загрузку и добавляет в хранилище образ, имя которого записано в file
+func (f *fakeRuntime) Load(ctx context.Context, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Loads = append(f.Loads, file)
+	f.Images[string(b)] = true
+	return nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sources/depgraph"
+	"sources/logger"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// graphWorkDir Рабочий каталог задачи выгрузки графа зависимостей внутри каталога модуля, удаляется после чтения графа
+const graphWorkDir = ".services-revision-tool"
+
+// graphDir Каталог итогового архива с графами зависимостей модулей сервиса
+const graphDir = "dependency_graph"
+
+// resolveGraph Запускает в образе сборщика задачу init скрипта depgraph после успешной сборки модуля
+// и возвращает разрешенный граф зависимостей. dir - абсолютный путь к каталогу модуля,
+// initScript - init скрипт с локальным nexus, если он использовался при сборке.
+func resolveGraph(ctx context.Context, svcName string, m *Module, plan *BuildPlan, dir string, initScript string) (*depgraph.Graph, error) {
+	work := dir + "/" + graphWorkDir
+	err := os.RemoveAll(work)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+	err = depgraph.CreateScript(work + "/dependency-graph.gradle")
+	if err != nil {
+		return nil, err
+	}
+	// Задача запускается в контейнере от другого пользователя, каталог для результата должен быть доступен на запись
+	err = os.MkdirAll(work+"/graph", 0777)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(work+"/graph", 0777)
+	if err != nil {
+		return nil, err
+	}
+	opts := buildRunOptions(Gradle, dir, plan, initScript)
+	flags := " -g " + Gradle.CacheDir()
+	if initScript != "" {
+		flags = flags + " -I /tmp/init.gradle"
+	}
+	flags = flags + " -I /home/gradle/" + graphWorkDir + "/dependency-graph.gradle -D" + depgraph.DirProperty + "=/home/gradle/" + graphWorkDir + "/graph -q"
+	opts.Cmd = []string{"bash", "-c", withProxy(plan.Executable()) + graphArgs(plan.Commands[len(plan.Commands)-1]) + flags + " " + depgraph.TaskName}
+	var outb, errb bytes.Buffer
+	logger.Log.Debugf("Resolve dependency graph for service %s, module %s", svcName, m.Name)
+	if err := runBuild(ctx, opts, &outb, &errb); err != nil {
+		logger.Log.Tracef("Dependency graph task stdout: %s, stderr: %s", outb.String(), errb.String())
+		return nil, err
+	}
+	return depgraph.Load(work + "/graph")
+}
+
+// graphOptions Ключи gradle, которые выбирают проект сборки и задают его свойства. Значение указывается
+// через пробел, для длинных ключей - и через "=", для -P и -D - и слитно (-Pversion=1.0)
+var graphOptions = []string{"-p", "--project-dir", "-b", "--build-file", "-c", "--settings-file", "--include-build",
+	"-I", "--init-script", "-P", "--project-prop", "-D", "--system-prop"}
+
+// graphArgs Возвращает ключи graphOptions со значениями из команды сборки command, чтобы задача графа
+// разрешала тот же проект, что и сборка. Задачи сборки и остальные ключи отбрасываются.
+func graphArgs(command string) string {
+	var args []string
+	fields := strings.Fields(command)
+	for i := 1; i < len(fields); i++ {
+		f := fields[i]
+		name, _, hasValue := strings.Cut(f, "=")
+		switch {
+		case slices.Contains(graphOptions, f):
+			if i+1 < len(fields) {
+				args = append(args, f, fields[i+1])
+				i++
+			}
+		case hasValue && strings.HasPrefix(name, "--") && slices.Contains(graphOptions, name):
+			args = append(args, f)
+		case strings.HasPrefix(f, "-P") || strings.HasPrefix(f, "-D"):
+			args = append(args, f)
+		}
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	return " " + strings.Join(args, " ")
+}
+
+// graphDeps Возвращает true, если зависимости модуля берутся из графа: граф выгружен и все зависимости
+// продуктовых конфигураций в нём разрешены. Иначе граф неполон и зависимости ищутся в кеше сборщика
+func graphDeps(m Module) bool {
+	return m.Graph != nil && len(m.Graph.Unresolved()) == 0
+}
+
+// saveGraphs Сохраняет графы зависимостей модулей в каталог dependency_graph итогового архива, по файлу на модуль
+func saveGraphs(svcName string, modules []Module) error {
+	for _, m := range modules {
+		if m.Graph == nil {
+			continue
+		}
+		err := os.MkdirAll(Cfg.Output_dir+"/"+svcName+"/"+graphDir, 0744)
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(m.Graph, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(graphFile(svcName, m), b, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadGraphs Читает графы зависимостей модулей, сохраненные на стадии сборки, при продолжении обработки сервиса.
+// Модули без файла графа остаются без графа, их зависимости ищутся в кеше сборки.
+func loadGraphs(svcName string, modules []Module) error {
+	for i := range modules {
+		m := &modules[i]
+		b, err := os.ReadFile(graphFile(svcName, *m))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.Graph = &depgraph.Graph{}
+		err = json.Unmarshal(b, m.Graph)
+		if err != nil {
+			return fmt.Errorf("unable to parse dependency graph of module %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// graphFile Возвращает файл графа зависимостей модуля m в каталоге dependency_graph сервиса
+func graphFile(svcName string, m Module) string {
+	return Cfg.Output_dir + "/" + svcName + "/" + graphDir + "/" + strings.ReplaceAll(m.Name, "/", "_") + ".json"
+}
+
+// graphConflicts Возвращает зависимости модулей, для которых gradle выбрал версию, отличную от запрошенной,
+// в формате "группа:артефакт:запрошенная -> выбранная (причина)" без повторов
+func graphConflicts(modules []Module) []string {
+	var result []string
+	for _, m := range modules {
+		if m.Graph == nil {
+			continue
+		}
+		for _, c := range m.Graph.Conflicts() {
+			s := c.Requested.String() + " -> " + c.Selected.Version + " (" + c.Reason + ")"
+			if c.Requested.Group != c.Selected.Group || c.Requested.Module != c.Selected.Module {
+				s = c.Requested.String() + " -> " + c.Selected.String() + " (" + c.Reason + ")"
+			}
+			if !slices.Contains(result, s) {
+				result = append(result, s)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
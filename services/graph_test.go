@@ -0,0 +1,53 @@
+package services
+
+import (
+	"sources/depgraph"
+	"testing"
+)
+
+func TestGraphArgs(t *testing.T) {
+	tests := map[string]string{
+		"./gradlew build": "",
+		"./gradlew clean build -x test --no-daemon":                                 "",
+		"./gradlew -p app build -x test -Pversion=1 --settings-file s.gradle -Dx=y": " -p app -Pversion=1 --settings-file s.gradle -Dx=y",
+		"gradle --project-dir=app -b app/build.gradle assemble":                     " --project-dir=app -b app/build.gradle",
+		"gradle -P version=1 -D x=y --project-prop=a=b --system-prop c=d build":     " -P version=1 -D x=y --project-prop=a=b --system-prop c=d",
+		"gradle -I init.gradle --include-build ../lib --offline build":              " -I init.gradle --include-build ../lib",
+		"gradle build -p": "",
+	}
+	for command, want := range tests {
+		if got := graphArgs(command); got != want {
+			t.Errorf("graphArgs(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestGraphDeps(t *testing.T) {
+	resolved := &depgraph.Graph{Projects: []depgraph.Project{{Project: ":", Configurations: []depgraph.Configuration{
+		{Name: "compileClasspath", Edges: []depgraph.Edge{{
+			Requested: depgraph.Component{Group: "com.example", Module: "lib", Version: "1.0"},
+			Selected:  &depgraph.Component{Group: "com.example", Module: "lib", Version: "1.0"},
+		}}},
+		{Name: "testCompileClasspath", Edges: []depgraph.Edge{{
+			Requested:  depgraph.Component{Group: "com.example", Module: "test-missing", Version: "1.0"},
+			Unresolved: "Could not find com.example:test-missing:1.0.",
+		}}},
+	}}}}
+	unresolved := &depgraph.Graph{Projects: []depgraph.Project{{Project: ":", Configurations: []depgraph.Configuration{
+		{Name: "runtimeClasspath", Error: "Could not resolve all dependencies"},
+	}}}}
+	tests := []struct {
+		name  string
+		graph *depgraph.Graph
+		want  bool
+	}{
+		{"no graph", nil, false},
+		{"resolved", resolved, true},
+		{"unresolved", unresolved, false},
+	}
+	for _, tt := range tests {
+		if got := graphDeps(Module{Graph: tt.graph}); got != tt.want {
+			t.Errorf("%s: graphDeps() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
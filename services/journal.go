@@ -0,0 +1,358 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sources/config"
+	"sources/logger"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+	"golang.org/x/exp/slices"
+)
+
+// Стадии обработки сервиса в порядке выполнения
+const (
+	StageFetch        = "fetch"        // загрузка архива исходных кодов из gitlab
+	StageExtract      = "extract"      // распаковка архива исходных кодов
+	StageBuild        = "build"        // сборка модулей и разрешение графа зависимостей
+	StageImage        = "image"        // выгрузка образов сборщика
+	StageDependencies = "dependencies" // сбор зависимостей из кеша сборки и загрузка их исходных кодов
+	StagePack         = "pack"         // подготовка каталога сервиса и упаковка вложенных архивов
+	StageVerify       = "verify"       // проверка оффлайн сборки и воспроизводимости
+	StageBundle       = "bundle"       // упаковка итогового архива сервиса
+	StageHash         = "hash"         // расчет хеша итогового архива
+	StageUpload       = "upload"       // загрузка итогового архива в nexus
+)
+
+// Stages Стадии обработки сервиса в порядке выполнения
+var Stages = []string{StageFetch, StageExtract, StageBuild, StageImage, StageDependencies, StagePack, StageVerify, StageBundle, StageHash, StageUpload}
+
+// journalFile Файл журнала стадий в каталоге output_dir
+const journalFile = "journal.json"
+
+// Journal Журнал обработки сервисов: завершенные стадии каждого сервиса, ключ - путь проекта, как в service_list
+type Journal struct {
+	Services map[string]*ServiceState `json:"services"`
+}
+
+// ServiceState Состояние обработки сервиса: завершенные стадии по порядку и модули, найденные при сборке
+type ServiceState struct {
+	Stages  []StageRecord `json:"stages"`
+	Modules []Module      `json:"modules,omitempty"`
+}
+
+// StageRecord Завершенная стадия и данные отчета сервиса на момент ее завершения,
+// из которых восстанавливается отчет при продолжении обработки
+type StageRecord struct {
+	Stage    string        `json:"stage"`
+	Finished time.Time     `json:"finished"`
+	Report   ServiceReport `json:"report"`
+}
+
+// ServiceReport Данные отчета и README одного сервиса
+type ServiceReport struct {
+	Known_deps          []string              `json:"known_deps,omitempty"`
+	Unknown_deps        []string              `json:"unknown_deps,omitempty"`
+	Unknown_sx_deps     []string              `json:"unknown_sx_deps,omitempty"`
+	Unknown_sx_deps_ver []string              `json:"unknown_sx_deps_ver,omitempty"`
+	Without_src_deps    []string              `json:"without_src_deps,omitempty"`
+	Modules_deps        map[string][]string   `json:"modules_deps,omitempty"`
+	Build_descriptors   []string              `json:"build_descriptors,omitempty"`
+	Verify_errors       string                `json:"verify_errors,omitempty"`
+	Classifier_deps     []string              `json:"classifier_deps,omitempty"`
+	Deps_origin         map[string]string     `json:"deps_origin,omitempty"`
+	Checksum_errors     []string              `json:"checksum_errors,omitempty"`
+	Repro_results       string                `json:"repro_results,omitempty"`
+	Sources_provenance  *Provenance           `json:"sources_provenance,omitempty"`
+	Deps_provenance     map[string]Provenance `json:"deps_provenance,omitempty"`
+	Scm_sources         map[string]Provenance `json:"scm_sources,omitempty"`
+}
+
+var (
+	journal      = &Journal{Services: make(map[string]*ServiceState)}
+	journalMutex = sync.Mutex{}
+)
+
+// LoadJournal Читает журнал стадий из каталога output_dir. Если журнала нет, используется пустой журнал.
+func LoadJournal() error {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	journal = &Journal{Services: make(map[string]*ServiceState)}
+	b, err := os.ReadFile(Cfg.Output_dir + "/" + journalFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, journal)
+	if err != nil {
+		return errors.New("Unable to parse journal " + Cfg.Output_dir + "/" + journalFile + ": " + err.Error())
+	}
+	if journal.Services == nil {
+		journal.Services = make(map[string]*ServiceState)
+	}
+	return nil
+}
+
+// saveJournal Записывает журнал через временный файл, чтобы прерванная запись не повредила журнал. Вызывается под journalMutex.
+func saveJournal() error {
+	b, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := Cfg.Output_dir + "/" + journalFile
+	err = os.WriteFile(file+".part", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".part", file)
+}
+
+// done Проверяет, что стадия завершена
+func (s *ServiceState) done(stage string) bool {
+	return slices.ContainsFunc(s.Stages, func(r StageRecord) bool { return r.Stage == stage })
+}
+
+// next Возвращает первую незавершенную стадию, пустую строку, если завершены все стадии
+func (s *ServiceState) next() string {
+	for _, stage := range Stages {
+		if !s.done(stage) {
+			return stage
+		}
+	}
+	return ""
+}
+
+// CompleteStage Отмечает стадию сервиса завершенной и сохраняет журнал вместе с модулями и данными отчета сервиса
+func CompleteStage(svc *gitlab.Project, stage string, modules []Module) error {
+	svcPath := strings.TrimSpace(svc.Path)
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	st := journal.Services[svcPath]
+	if st == nil {
+		st = &ServiceState{}
+		journal.Services[svcPath] = st
+	}
+	if modules != nil {
+		st.Modules = modules
+	}
+	st.Stages = append(st.Stages, StageRecord{Stage: stage, Finished: time.Now(), Report: snapshotReport(strings.TrimSpace(svc.Name))})
+	return saveJournal()
+}
+
+// Processed Проверяет, что все стадии сервиса завершены и повторная обработка сервиса не запрошена ключами -force-*
+func Processed(svc *gitlab.Project) bool {
+	svcPath := strings.TrimSpace(svc.Path)
+	if forced(svcPath) {
+		return false
+	}
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	st := journal.Services[svcPath]
+	return st != nil && st.next() == ""
+}
+
+// RestoreProcessed Восстанавливает из журнала данные отчета сервиса, все стадии которого завершены при предыдущем запуске,
+// чтобы пропущенный с ключом -resume сервис попал в отчет
+func RestoreProcessed(svc *gitlab.Project) {
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	st := journal.Services[strings.TrimSpace(svc.Path)]
+	if st == nil || len(st.Stages) == 0 {
+		return
+	}
+	restoreReport(strings.TrimSpace(svc.Name), st.Stages[len(st.Stages)-1].Report)
+}
+
+// forced Проверяет, что для сервиса запрошена повторная обработка ключами -force-stage или -force-service
+func forced(svcPath string) bool {
+	if config.ForceStage == "" && config.ForceService == "" {
+		return false
+	}
+	return config.ForceService == "" || config.ForceService == svcPath
+}
+
+// PrepareResume Определяет стадию, с которой начинается обработка сервиса, и готовит к ней каталог сервиса.
+// Без ключа -resume сервис обрабатывается с начала. С ключом -resume обработка продолжается с первой незавершенной стадии,
+// ключ -force-stage переносит начало на указанную стадию. Если результатов предыдущих стадий на диске уже нет
+// (например, каталог сервиса упакован), обработка начинается с ближайшей стадии, для которой они есть.
+// Незавершенные стадии удаляются из журнала, данные отчета восстанавливаются на момент начала стадии.
+func PrepareResume(svc *gitlab.Project) (string, error) {
+	svcName := strings.TrimSpace(svc.Name)
+	svcPath := strings.TrimSpace(svc.Path)
+	journalMutex.Lock()
+	defer journalMutex.Unlock()
+	st := journal.Services[svcPath]
+	if st == nil {
+		st = &ServiceState{}
+	}
+	start := StageFetch
+	if config.Resume || forced(svcPath) {
+		start = st.next()
+	}
+	if forced(svcPath) {
+		if config.ForceStage == "" {
+			start = StageFetch
+		} else if start == "" || slices.Index(Stages, config.ForceStage) < slices.Index(Stages, start) {
+			start = config.ForceStage
+		}
+	}
+	if start == "" {
+		start = StageFetch
+	}
+	for start != StageFetch && !stageReady(svc, st, start) {
+		prev := Stages[slices.Index(Stages, start)-1]
+		logger.Log.Debugf("Results of stage %s of service %s not found, resume from stage %s", start, svcName, prev)
+		start = prev
+	}
+	idx := slices.Index(Stages, start)
+	kept := &ServiceState{}
+	for _, r := range st.Stages {
+		if slices.Index(Stages, r.Stage) < idx {
+			kept.Stages = append(kept.Stages, r)
+		}
+	}
+	if idx > slices.Index(Stages, StageBuild) {
+		kept.Modules = st.Modules
+	}
+	journal.Services[svcPath] = kept
+	if len(kept.Stages) > 0 {
+		restoreReport(svcName, kept.Stages[len(kept.Stages)-1].Report)
+	}
+	err := resetStage(svc, start)
+	if err != nil {
+		return "", err
+	}
+	return start, saveJournal()
+}
+
+// stageReady Проверяет, что на диске есть результаты стадий, предшествующих stage. Дерево исходных кодов
+// с кешами сборки сохраняется до стадии pack, на ней кеши переносятся в каталог сервиса, а исходные коды удаляются.
+func stageReady(svc *gitlab.Project, st *ServiceState, stage string) bool {
+	svcName := strings.TrimSpace(svc.Name)
+	svcPath := strings.TrimSpace(svc.Path)
+	treeIntact := slices.Index(Stages, st.next()) <= slices.Index(Stages, StageDependencies)
+	switch stage {
+	case StageExtract:
+		return treeIntact && fileExists(Cfg.Output_dir+"/"+svcPath+"."+Cfg.Archive_format)
+	case StageBuild:
+		return treeIntact && fileExists(Cfg.Output_dir+"/"+svcPath+"."+Cfg.Archive_format) && fileExists(Cfg.Output_dir+"/"+svcName)
+	case StageImage, StageDependencies:
+		return treeIntact && len(st.Modules) > 0 && fileExists(Cfg.Output_dir+"/"+svcName)
+	case StagePack, StageVerify, StageBundle:
+		return fileExists(Cfg.Output_dir + "/" + svcName)
+	case StageHash, StageUpload:
+		return fileExists(Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format)
+	}
+	return true
+}
+
+// resetStage Удаляет результаты, которые стадия stage формирует заново
+func resetStage(svc *gitlab.Project, stage string) error {
+	svcName := strings.TrimSpace(svc.Name)
+	switch stage {
+	case StageFetch:
+		cleanupService(svc)
+	case StageExtract:
+		for _, p := range []string{
+			Cfg.Output_dir + "/" + svcName,
+			Cfg.Output_dir + "/" + verifyDir + "/" + svcName,
+			Cfg.Output_dir + "/" + artifactsRoot + "/" + svcName,
+		} {
+			err := os.RemoveAll(p)
+			if err != nil {
+				return err
+			}
+		}
+	case StageBuild:
+		return os.RemoveAll(Cfg.Output_dir + "/" + svcName + "/" + graphDir)
+	case StageImage:
+		err := os.RemoveAll(Cfg.Output_dir + "/" + svcName + "/docker_images")
+		if err != nil {
+			return err
+		}
+		return os.Mkdir(Cfg.Output_dir+"/"+svcName+"/docker_images", 0744)
+	case StageDependencies:
+		return os.RemoveAll(Cfg.Output_dir + "/" + svcName + "/deps_sources")
+	}
+	return nil
+}
+
+// snapshotReport Возвращает копию данных отчета сервиса
+func snapshotReport(svcName string) ServiceReport {
+	MapMutex.RLock()
+	defer MapMutex.RUnlock()
+	var sources *Provenance
+	if p, ok := Sources_provenance[svcName]; ok {
+		sources = &p
+	}
+	return ServiceReport{
+		Known_deps:          slices.Clone(Known_deps[svcName]),
+		Unknown_deps:        slices.Clone(Unknown_deps[svcName]),
+		Unknown_sx_deps:     slices.Clone(Unknown_sx_deps[svcName]),
+		Unknown_sx_deps_ver: slices.Clone(Unknown_sx_deps_ver[svcName]),
+		Without_src_deps:    slices.Clone(Without_src_deps[svcName]),
+		Modules_deps:        cloneMap(Modules_deps[svcName]),
+		Build_descriptors:   slices.Clone(Build_descriptors[svcName]),
+		Verify_errors:       Verify_errors[svcName],
+		Classifier_deps:     slices.Clone(Classifier_deps[svcName]),
+		Deps_origin:         cloneMap(Deps_origin[svcName]),
+		Checksum_errors:     slices.Clone(Checksum_errors[svcName]),
+		Repro_results:       Repro_results[svcName],
+		Sources_provenance:  sources,
+		Deps_provenance:     cloneMap(Deps_provenance[svcName]),
+		Scm_sources:         cloneMap(Scm_sources[svcName]),
+	}
+}
+
+// restoreReport Восстанавливает данные отчета сервиса из журнала
+func restoreReport(svcName string, r ServiceReport) {
+	MapMutex.Lock()
+	defer MapMutex.Unlock()
+	Known_deps[svcName] = r.Known_deps
+	Unknown_deps[svcName] = r.Unknown_deps
+	Unknown_sx_deps[svcName] = r.Unknown_sx_deps
+	Unknown_sx_deps_ver[svcName] = r.Unknown_sx_deps_ver
+	Without_src_deps[svcName] = r.Without_src_deps
+	Build_descriptors[svcName] = r.Build_descriptors
+	Classifier_deps[svcName] = r.Classifier_deps
+	Checksum_errors[svcName] = r.Checksum_errors
+	if r.Modules_deps != nil {
+		Modules_deps[svcName] = cloneMap(r.Modules_deps)
+	}
+	if r.Deps_origin != nil {
+		Deps_origin[svcName] = cloneMap(r.Deps_origin)
+	}
+	if r.Verify_errors != "" {
+		Verify_errors[svcName] = r.Verify_errors
+	}
+	if r.Repro_results != "" {
+		Repro_results[svcName] = r.Repro_results
+	}
+	if r.Sources_provenance != nil {
+		Sources_provenance[svcName] = *r.Sources_provenance
+	}
+	if r.Deps_provenance != nil {
+		Deps_provenance[svcName] = cloneMap(r.Deps_provenance)
+	}
+	if r.Scm_sources != nil {
+		Scm_sources[svcName] = cloneMap(r.Scm_sources)
+	}
+}
+
+// cloneMap Возвращает копию карты, записи журнала не должны изменяться при дальнейшей обработке сервиса
+func cloneMap[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sources/config"
+	"sources/depgraph"
+	"strings"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+	"golang.org/x/exp/slices"
+)
+
+// setupJournal Готовит output_dir во временном каталоге с журналом, в котором завершены стадии done сервиса svc,
+// и файлами files (каталоги оканчиваются на /). Данные отчета каждой стадии - зависимость dep-<стадия>.
+func setupJournal(t *testing.T, svc *gitlab.Project, done []string, files []string) {
+	t.Helper()
+	Init()
+	Cfg = &config.Configuration{Output_dir: t.TempDir(), Archive_format: "tgz"}
+	journal = &Journal{Services: make(map[string]*ServiceState)}
+	if done != nil {
+		st := &ServiceState{Modules: []Module{{Name: "root", Tool: Gradle}}}
+		for _, s := range done {
+			st.Stages = append(st.Stages, StageRecord{Stage: s, Report: ServiceReport{Known_deps: []string{"dep-" + s}}})
+		}
+		journal.Services[svc.Path] = st
+		if err := saveJournal(); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadJournal(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range files {
+		p := filepath.Join(Cfg.Output_dir, f)
+		if strings.HasSuffix(f, "/") {
+			os.MkdirAll(p, 0755)
+			continue
+		}
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(f), 0644)
+	}
+}
+
+// setFlags Задает ключи запуска -resume, -force-stage и -force-service на время теста
+func setFlags(t *testing.T, resume bool, forceStage string, forceService string) {
+	t.Helper()
+	config.Resume, config.ForceStage, config.ForceService = resume, forceStage, forceService
+	t.Cleanup(func() {
+		config.Resume, config.ForceStage, config.ForceService = false, "", ""
+	})
+}
+
+func TestPrepareResume(t *testing.T) {
+	svc := &gitlab.Project{Name: "my service", Path: "my-svc"}
+	// Дерево исходных кодов и кеши сборки до стадии pack
+	tree := []string{"my-svc.tgz", "my service/src/build.gradle", "my service/docker_images/gradle.tar"}
+	tests := []struct {
+		name         string
+		done         []string
+		files        []string
+		resume       bool
+		forceStage   string
+		forceService string
+		want         string
+		removed      []string
+		kept         []string
+	}{
+		{
+			name:    "resume without journal",
+			files:   tree,
+			resume:  true,
+			want:    StageFetch,
+			removed: tree,
+		},
+		{
+			name:    "without resume service starts from fetch",
+			done:    Stages[:4],
+			files:   tree,
+			want:    StageFetch,
+			removed: tree,
+		},
+		{
+			name:   "half-finished pack",
+			done:   Stages[:5],
+			files:  []string{"my-svc.tgz", "my service/deps_sources.tgz", "my service/gradle_cache/"},
+			resume: true,
+			want:   StagePack,
+			kept:   []string{"my service/deps_sources.tgz", "my service/gradle_cache/"},
+		},
+		{
+			name:       "force stage earlier than next stage",
+			done:       Stages[:4],
+			files:      tree,
+			forceStage: StageBuild,
+			want:       StageBuild,
+			kept:       tree,
+		},
+		{
+			name:       "force stage resets its results",
+			done:       Stages[:4],
+			files:      tree,
+			forceStage: StageImage,
+			want:       StageImage,
+			removed:    []string{"my service/docker_images/gradle.tar"},
+			kept:       []string{"my-svc.tgz", "my service/src/build.gradle", "my service/docker_images/"},
+		},
+		{
+			name:       "force stage later than next stage",
+			done:       Stages[:3],
+			files:      tree,
+			forceStage: StagePack,
+			want:       StageImage,
+			removed:    []string{"my service/docker_images/gradle.tar"},
+			kept:       []string{"my-svc.tgz", "my service/src/build.gradle"},
+		},
+		{
+			name:         "force service with resume",
+			done:         Stages[:4],
+			files:        tree,
+			resume:       true,
+			forceStage:   StageFetch,
+			forceService: "my-svc",
+			want:         StageFetch,
+			removed:      tree,
+		},
+		{
+			name:         "other service forced, resume",
+			done:         Stages[:4],
+			files:        tree,
+			resume:       true,
+			forceStage:   StageBuild,
+			forceService: "other-svc",
+			want:         StageDependencies,
+			kept:         tree,
+		},
+		{
+			name:         "other service forced, without resume",
+			done:         Stages[:4],
+			files:        tree,
+			forceStage:   StageBuild,
+			forceService: "other-svc",
+			want:         StageFetch,
+			removed:      tree,
+		},
+		{
+			name:       "tree missing after pack",
+			done:       Stages[:6],
+			files:      []string{"my-svc.tgz", "my service/deps_sources.tgz"},
+			forceStage: StageBuild,
+			want:       StageFetch,
+			removed:    []string{"my-svc.tgz", "my service/deps_sources.tgz"},
+		},
+		{
+			name:   "service directory missing after verify",
+			done:   Stages[:7],
+			files:  []string{"my-svc.tgz"},
+			resume: true,
+			want:   StageFetch,
+		},
+		{
+			name:    "bundle archive missing",
+			done:    Stages[:8],
+			files:   []string{"my service/deps_sources.tgz"},
+			resume:  true,
+			want:    StageBundle,
+			kept:    []string{"my service/deps_sources.tgz"},
+			removed: []string{"my service.tgz"},
+		},
+		{
+			name:   "all stages done, resume",
+			done:   Stages,
+			files:  []string{"my service.tgz"},
+			resume: true,
+			want:   StageFetch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupJournal(t, svc, tt.done, tt.files)
+			setFlags(t, tt.resume, tt.forceStage, tt.forceService)
+			start, err := PrepareResume(svc)
+			if err != nil {
+				t.Fatalf("PrepareResume: %v", err)
+			}
+			if start != tt.want {
+				t.Errorf("start = %s, want %s", start, tt.want)
+			}
+			for _, f := range tt.removed {
+				if _, err := os.Stat(filepath.Join(Cfg.Output_dir, f)); !os.IsNotExist(err) {
+					t.Errorf("%s must be removed", f)
+				}
+			}
+			for _, f := range tt.kept {
+				if _, err := os.Stat(filepath.Join(Cfg.Output_dir, f)); err != nil {
+					t.Errorf("%s must be kept: %v", f, err)
+				}
+			}
+			// В сохраненном журнале остаются только стадии до начальной, отчет восстановлен на момент ее начала
+			if err := LoadJournal(); err != nil {
+				t.Fatal(err)
+			}
+			st := journal.Services[svc.Path]
+			var stages []string
+			for _, r := range st.Stages {
+				stages = append(stages, r.Stage)
+			}
+			idx := slices.Index(Stages, start)
+			if want := Stages[:idx]; !reflect.DeepEqual(stages, want) && !(len(stages) == 0 && idx == 0) {
+				t.Errorf("journal stages = %v, want %v", stages, want)
+			}
+			if idx > 0 && !reflect.DeepEqual(Known_deps[svc.Name], []string{"dep-" + Stages[idx-1]}) {
+				t.Errorf("restored known deps = %v, want dep-%s", Known_deps[svc.Name], Stages[idx-1])
+			}
+			if hasModules := len(st.Modules) > 0; hasModules != (idx > slices.Index(Stages, StageBuild)) {
+				t.Errorf("modules kept = %v for start stage %s", hasModules, start)
+			}
+		})
+	}
+}
+
+func TestProcessed(t *testing.T) {
+	svc := &gitlab.Project{Name: "my service", Path: "my-svc"}
+	tests := []struct {
+		name         string
+		done         []string
+		forceStage   string
+		forceService string
+		want         bool
+	}{
+		{name: "all stages done", done: Stages, want: true},
+		{name: "upload not done", done: Stages[:len(Stages)-1], want: false},
+		{name: "not in journal", want: false},
+		{name: "force stage", done: Stages, forceStage: StagePack, want: false},
+		{name: "force this service", done: Stages, forceService: "my-svc", want: false},
+		{name: "force other service", done: Stages, forceStage: StagePack, forceService: "other-svc", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupJournal(t, svc, tt.done, nil)
+			setFlags(t, false, tt.forceStage, tt.forceService)
+			if got := Processed(svc); got != tt.want {
+				t.Errorf("Processed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreProcessed(t *testing.T) {
+	svc := &gitlab.Project{Name: "my service", Path: "my-svc"}
+	setupJournal(t, svc, Stages, nil)
+	RestoreProcessed(svc)
+	if want := []string{"dep-" + StageUpload}; !reflect.DeepEqual(Known_deps["my service"], want) {
+		t.Errorf("Known_deps = %q, want %q", Known_deps["my service"], want)
+	}
+	RestoreProcessed(&gitlab.Project{Name: "other", Path: "other"})
+	if _, ok := Known_deps["other"]; ok {
+		t.Errorf("report of service without journal restored: %q", Known_deps["other"])
+	}
+}
+
+func TestJournalGraphs(t *testing.T) {
+	svc := &gitlab.Project{Name: "my service", Path: "my-svc"}
+	setupJournal(t, svc, nil, []string{"my service/src/"})
+	graph := &depgraph.Graph{Projects: []depgraph.Project{{Project: ":", Configurations: []depgraph.Configuration{
+		{Name: "runtimeClasspath", Edges: []depgraph.Edge{{
+			Requested: depgraph.Component{Group: "com.example", Module: "lib", Version: "1.0"},
+			Selected:  &depgraph.Component{Group: "com.example", Module: "lib", Version: "1.1"},
+			Reason:    "conflict resolution",
+		}}},
+	}}}}
+	modules := []Module{{Name: "root", Tool: Gradle, Graph: graph}, {Name: "app/web", Tool: Gradle, Graph: graph}, {Name: "maven", Tool: Maven}}
+	if err := saveGraphs("my service", modules); err != nil {
+		t.Fatal(err)
+	}
+	for _, stage := range Stages[:3] {
+		if err := CompleteStage(svc, stage, modules); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Граф хранится только в dependency_graph, в журнал записываются модули без графа
+	b, err := os.ReadFile(filepath.Join(Cfg.Output_dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "runtimeClasspath") {
+		t.Errorf("journal contains dependency graph:\n%s", b)
+	}
+	if err := LoadJournal(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := slices.Clone(journal.Services["my-svc"].Modules)
+	if err := loadGraphs("my service", loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, modules) {
+		t.Errorf("modules = %+v, want %+v", loaded, modules)
+	}
+	// Повторная сборка формирует графы заново
+	if err := resetStage(svc, StageBuild); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(Cfg.Output_dir, "my service", graphDir)); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", graphDir, err)
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sources/logger"
+	"sources/maven"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// downloadFromMaven Скачивает файлы зависимости d из первого репозитория maven, в котором она найдена:
+// pom, .module, jar, -sources.jar и артефакты с классификатором вместе с их исходниками.
+// Исходные коды зависимости без -sources.jar получаются по scm из pom, если включен scm_fallback.
+// Пути вычисляются по координатам, html листинг каталога используется только при включенном maven_listings.
+// Для маркера плагина gradle дополнительно скачивается артефакт реализации плагина.
+func downloadFromMaven(ctx context.Context, mvn *maven.Client, d ProjectXml, saveto string, svcName string) error {
+	a := maven.Artifact{GroupId: d.GroupId, ArtifactId: d.ArtifactId, Version: d.Version}
+	repo, err := mvn.Find(ctx, a)
+	if errors.Is(err, maven.ErrNotFound) {
+		logger.Log.Debugf("Dependency %s not found in maven repositories", d.Key())
+		MapMutex.Lock()
+		Unknown_deps[svcName] = append(Unknown_deps[svcName], d.Key())
+		MapMutex.Unlock()
+		return nil
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		MapMutex.Lock()
+		Without_src_deps[svcName] = append(Without_src_deps[svcName], d.Key())
+		MapMutex.Unlock()
+		return err
+	}
+	logger.Log.Debugf("Dependency %s found in repository %s", d.Key(), repo.Name)
+	MapMutex.Lock()
+	if Deps_origin[svcName] == nil {
+		Deps_origin[svcName] = make(map[string]string)
+	}
+	Deps_origin[svcName][d.Key()] = repo.Name
+	MapMutex.Unlock()
+	dir := saveto + "/" + d.GroupId + "/" + d.ArtifactId + "/" + d.Version
+	files := []maven.Artifact{a.WithFile("", "pom"), a.WithFile("", "module"), a.WithFile("", "jar"), a.WithFile("sources", "jar")}
+	for _, c := range d.Classifiers {
+		files = append(files, a.WithFile(c, "jar"), a.WithFile(c+"-sources", "jar"))
+	}
+	j, s := false, false
+	var downloaded []string
+	var mismatches []string
+	for _, f := range files {
+		file, err := mvn.Download(ctx, repo, f, dir)
+		if errors.Is(err, maven.ErrNotFound) {
+			logger.Log.Tracef("File %s not found in %s", f, repo.Name)
+			continue
+		}
+		if errors.Is(err, maven.ErrChecksumMismatch) {
+			mismatches = append(mismatches, err.Error())
+			continue
+		}
+		if err != nil {
+			logger.Log.Errorf("Unable to download %s from %s: %v", f, repo.Name, err)
+			continue
+		}
+		downloaded = append(downloaded, filepath.Base(file))
+		j = j || (f.Extension == "jar" && f.Classifier == "")
+		s = s || f.Classifier == "sources"
+	}
+	if Cfg.MavenListings {
+		// Листинг каталога дополняет вычисленные пути файлами, которые не удалось угадать по координатам
+		names, err := mvn.Listing(ctx, repo, a)
+		if err != nil {
+			logger.Log.Debugf("No directory listing for %s in %s: %v", d.Key(), repo.Name, err)
+		}
+		for _, name := range names {
+			if slices.Contains(downloaded, name) || strings.HasSuffix(name, "maven-metadata.xml") {
+				continue
+			}
+			_, err := mvn.DownloadPath(ctx, repo, a.Dir()+"/"+name, dir+"/"+name)
+			if errors.Is(err, maven.ErrChecksumMismatch) {
+				mismatches = append(mismatches, err.Error())
+				continue
+			}
+			if err != nil {
+				logger.Log.Errorf("Unable to download %s from %s: %v", name, repo.Name, err)
+				continue
+			}
+			downloaded = append(downloaded, name)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	mismatches = append(mismatches, verifyCacheChecksums(d, dir, downloaded)...)
+	if len(mismatches) > 0 {
+		// Зависимость с несовпадающей контрольной суммой не попадает ни в архив, ни в кеш утилиты
+		logger.Log.Errorf("Checksum verification failed for dependency %s: %s", d.Key(), strings.Join(mismatches, "; "))
+		MapMutex.Lock()
+		for _, m := range mismatches {
+			Checksum_errors[svcName] = append(Checksum_errors[svcName], d.Key()+": "+m)
+		}
+		MapMutex.Unlock()
+		err := os.RemoveAll(dir)
+		if err != nil {
+			logger.Log.Errorf("Unable to remove files of dependency %s: %v", d.Key(), err)
+		}
+		return fmt.Errorf("dependency %s: %w", d.Key(), maven.ErrChecksumMismatch)
+	}
+	for _, name := range downloaded {
+		ext := filepath.Ext(name)
+		if ext == ".jar" || ext == ".pom" {
+			err := hashFile(dir + "/" + name)
+			if err != nil {
+				logger.Log.Errorf("Error calc hash for file: %v", err)
+			}
+		}
+		if Cfg.Cache {
+			cacheFiles := []string{name}
+			if ext == ".jar" || ext == ".pom" {
+				cacheFiles = append(cacheFiles, name+".gost")
+			}
+			for _, f := range cacheFiles {
+				if !fileExists(dir + "/" + f) {
+					continue
+				}
+				err := SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, f, dir+"/"+f)
+				if err != nil {
+					logger.Log.Errorf("Could not save %s file to cache dir: %v", d.Key(), err)
+				}
+			}
+		}
+	}
+	if j && !s {
+		logger.Log.Tracef("Sources not found for dependency %s", d.Key())
+		withoutSources(ctx, mvn, d, dir, svcName)
+	}
+	if a.IsPluginMarker() {
+		impl, err := mvn.PluginMarker(ctx, repo, a)
+		if err != nil {
+			logger.Log.Warnf("Unable to resolve implementation of gradle plugin %s: %v", d.Key(), err)
+			return nil
+		}
+		logger.Log.Debugf("Gradle plugin %s is implemented by %s", d.Key(), impl)
+		dep := ProjectXml{GroupId: impl.GroupId, ArtifactId: impl.ArtifactId, Version: impl.Version}
+		MapMutex.Lock()
+		known := slices.Contains(Known_deps[svcName], dep.Key())
+		if !known {
+			Known_deps[svcName] = append(Known_deps[svcName], dep.Key())
+		}
+		MapMutex.Unlock()
+		if known {
+			return nil
+		}
+		return downloadFromMaven(ctx, mvn, dep, saveto, svcName)
+	}
+	return nil
+}
+
+// verifyCacheChecksums Сравнивает скачанные файлы зависимости с SHA-1 тех же файлов в кеше сборки.
+// Возвращает описания несовпадений, файлы, которых нет в кеше, не проверяются.
+func verifyCacheChecksums(d ProjectXml, dir string, downloaded []string) []string {
+	var mismatches []string
+	for _, name := range downloaded {
+		expected, ok := d.Checksums[name]
+		if !ok {
+			continue
+		}
+		actual, err := maven.FileChecksum(dir+"/"+name, "sha1")
+		if err != nil {
+			logger.Log.Errorf("Unable to calc checksum of %s: %v", dir+"/"+name, err)
+			continue
+		}
+		if !maven.SameChecksum(expected, actual) {
+			e := &maven.ChecksumError{File: name, Algorithm: "sha1", Source: "build cache", Expected: expected, Actual: actual}
+			mismatches = append(mismatches, e.Error())
+		}
+	}
+	return mismatches
+}
+
+// mavenRepositories Возвращает репозитории из конфига в порядке поиска
+func mavenRepositories() []maven.Repository {
+	var repos []maven.Repository
+	for _, r := range Cfg.Repositories {
+		user, pass := r.Credentials()
+		repos = append(repos, maven.Repository{
+			Name:     r.Name,
+			Url:      r.Url,
+			Username: user,
+			Password: pass,
+			Groups:   r.Groups,
+			Timeout:  time.Duration(r.Timeout) * time.Second,
+		})
+	}
+	return repos
+}
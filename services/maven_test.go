@@ -0,0 +1,68 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sources/config"
+	"sources/maven"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheChecksums(t *testing.T) {
+	// Имя каталога в кеше gradle - SHA-1 файла без ведущих нулей: sha1("content-110") = 007784269c...
+	cache := t.TempDir()
+	jar := filepath.Join(cache, "com.example/lib/1.0/7784269c7d6043e862dd7429f2266e69b2395b/lib-1.0.jar")
+	pom := filepath.Join(cache, "com.example/lib/1.0/not-a-sha1/lib-1.0.pom")
+	for _, f := range []string{jar, pom} {
+		os.MkdirAll(filepath.Dir(f), 0755)
+		os.WriteFile(f, []byte("content-110"), 0644)
+	}
+	sums := cacheChecksums([]string{jar, pom}, Gradle)
+	if want := map[string]string{"lib-1.0.jar": "7784269c7d6043e862dd7429f2266e69b2395b"}; !reflect.DeepEqual(sums, want) {
+		t.Errorf("gradle sums = %v, want %v", sums, want)
+	}
+	// В локальном репозитории maven сумма берется из файла .sha1 рядом с файлом
+	m2 := t.TempDir()
+	mjar := filepath.Join(m2, "lib-1.0.jar")
+	os.WriteFile(mjar, []byte("content-110"), 0644)
+	os.WriteFile(mjar+".sha1", []byte("007784269C7D6043E862DD7429F2266E69B2395B  lib-1.0.jar\n"), 0644)
+	sums = cacheChecksums([]string{mjar, mjar + ".sha1", filepath.Join(m2, "lib-1.0.pom")}, Maven)
+	if want := map[string]string{"lib-1.0.jar": "007784269c7d6043e862dd7429f2266e69b2395b"}; !reflect.DeepEqual(sums, want) {
+		t.Errorf("maven sums = %v, want %v", sums, want)
+	}
+}
+
+func TestVerifyCacheChecksums(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/lib-1.0.jar", []byte("content-110"), 0644)
+	os.WriteFile(dir+"/lib-1.0-sources.jar", []byte("sources"), 0644)
+	os.WriteFile(dir+"/lib-1.0.pom", []byte("pom"), 0644)
+	d := ProjectXml{GroupId: "com.example", ArtifactId: "lib", Version: "1.0", Checksums: map[string]string{
+		// Ведущие нули отброшены, как в имени каталога кеша gradle
+		"lib-1.0.jar":         "7784269c7d6043e862dd7429f2266e69b2395b",
+		"lib-1.0-sources.jar": "0000000000000000000000000000000000000001",
+	}}
+	mismatches := verifyCacheChecksums(d, dir, []string{"lib-1.0.jar", "lib-1.0-sources.jar", "lib-1.0.pom"})
+	if len(mismatches) != 1 || !strings.HasPrefix(mismatches[0], "lib-1.0-sources.jar: sha1 mismatch") || !strings.Contains(mismatches[0], "(build cache)") {
+		t.Errorf("mismatches = %q", mismatches)
+	}
+}
+
+func TestMavenRepositories(t *testing.T) {
+	t.Setenv("NEXUS_USER", "env-user")
+	t.Setenv("NEXUS_PASSWORD", "env-secret")
+	Cfg = &config.Configuration{Repositories: []config.Repository{
+		{Name: "nexus", Url: "https://nexus.local/maven", UsernameEnv: "NEXUS_USER", PasswordEnv: "NEXUS_PASSWORD", Timeout: 60},
+		{Name: "spring", Url: "https://repo.spring.io", Username: "reader", Password: "secret", Groups: []string{"org.springframework*"}},
+	}}
+	want := []maven.Repository{
+		{Name: "nexus", Url: "https://nexus.local/maven", Username: "env-user", Password: "env-secret", Timeout: time.Minute},
+		{Name: "spring", Url: "https://repo.spring.io", Username: "reader", Password: "secret", Groups: []string{"org.springframework*"}},
+	}
+	if got := mavenRepositories(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mavenRepositories() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sources/logger"
+	"sources/maven"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// ModuleMetadata Gradle Module Metadata (файл .module), публикуется gradle вместе с pom или вместо него
+type ModuleMetadata struct {
+	FormatVersion string          `json:"formatVersion"`
+	Component     ModuleComponent `json:"component"`
+	Variants      []ModuleVariant `json:"variants"`
+}
+
+// ModuleComponent Координаты компонента в Gradle Module Metadata
+type ModuleComponent struct {
+	Group   string `json:"group"`
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	Url     string `json:"url"`
+}
+
+// ModuleVariant Вариант компонента (api, runtime, sources, платформенные варианты). Если задан AvailableAt,
+// файлы варианта опубликованы в другом компоненте, например kotlinx-coroutines-core-jvm для kotlinx-coroutines-core.
+type ModuleVariant struct {
+	Name        string                 `json:"name"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	Files       []ModuleFile           `json:"files"`
+	AvailableAt *ModuleComponent       `json:"available-at"`
+}
+
+// ModuleFile Файл варианта компонента
+type ModuleFile struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// parseModuleMetadata Разбирает файл Gradle Module Metadata
+func parseModuleMetadata(file string) (*ModuleMetadata, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &ModuleMetadata{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// isSourcesVariant Проверяет, что вариант содержит исходные коды (атрибут org.gradle.docstype = sources)
+func isSourcesVariant(v ModuleVariant) bool {
+	return v.Attributes["org.gradle.docstype"] == "sources"
+}
+
+// classifierOf Возвращает классификатор файла артефакта artifactId-version-classifier.ext.
+// Для основного артефакта, pom, module и контрольных сумм возвращает пустую строку.
+func classifierOf(file string, artifactId string, version string) string {
+	prefix := artifactId + "-" + version + "-"
+	if !strings.HasPrefix(file, prefix) {
+		return ""
+	}
+	rest := strings.TrimPrefix(file, prefix)
+	ext := filepath.Ext(rest)
+	if ext != ".jar" && ext != ".aar" && ext != ".zip" && ext != ".so" && ext != ".dll" && ext != ".klib" {
+		return ""
+	}
+	return strings.TrimSuffix(rest, ext)
+}
+
+// componentFiles Возвращает имена файлов компонента: для кеша gradle файлы лежат в подкаталогах
+// с контрольной суммой (group/artifact/version/<sha1>/file), для maven - в каталоге версии
+func componentFiles(dir string, tool BuildTool) []string {
+	var files []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Log.Errorf("Unable to read component dir %s: %v", dir, err)
+		return nil
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			files = append(files, filepath.Join(dir, e.Name()))
+			continue
+		}
+		if tool == Maven {
+			continue
+		}
+		sub, err := os.ReadDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		for _, s := range sub {
+			if !s.IsDir() {
+				files = append(files, filepath.Join(dir, e.Name(), s.Name()))
+			}
+		}
+	}
+	return files
+}
+
+// componentFromCache Определяет компонент по файлам каталога версии в кеше: координаты берутся из .module, затем из pom,
+// затем из пути. Артефакты с классификатором собираются по файлам кеша и по файлам вариантов .module.
+// Дополнительно возвращаются компоненты, на которые ссылаются варианты через available-at.
+func componentFromCache(depspath string, dir string, tool BuildTool) (ProjectXml, []ProjectXml) {
+	var d ProjectXml
+	var redirects []ProjectXml
+	files := componentFiles(dir, tool)
+	var meta *ModuleMetadata
+	for _, f := range files {
+		if strings.HasSuffix(f, ".module") {
+			m, err := parseModuleMetadata(f)
+			if err != nil {
+				logger.Log.Errorf("Error parsing gradle module metadata %s: %v", f, err)
+				continue
+			}
+			meta = m
+		}
+	}
+	if meta != nil && meta.Component.Module != "" {
+		d = ProjectXml{GroupId: meta.Component.Group, ArtifactId: meta.Component.Module, Version: meta.Component.Version}
+	} else {
+		for _, f := range files {
+			if strings.HasSuffix(f, ".pom") {
+				d = GetDepInfoFromPom(f)
+			}
+		}
+	}
+	if d.Version == "" || d.GroupId == "" || d.ArtifactId == "" || strings.Contains(d.GroupId, "$") || strings.Contains(d.Version, "$") {
+		rel, _ := filepath.Rel(depspath, dir)
+		parts := strings.Split(rel, string(os.PathSeparator))
+		if len(parts) < 3 {
+			return ProjectXml{}, nil
+		}
+		if tool == Maven {
+			// Раскладка maven репозитория: group/id/parts/artifactId/version
+			d.GroupId = strings.Join(parts[:len(parts)-2], ".")
+		} else {
+			d.GroupId = parts[len(parts)-3]
+		}
+		d.ArtifactId = parts[len(parts)-2]
+		d.Version = parts[len(parts)-1]
+		logger.Log.Tracef("No coordinates in pom or module file, get from dir %v", d)
+	}
+	names := make(map[string]bool)
+	for _, f := range files {
+		names[filepath.Base(f)] = true
+	}
+	if meta != nil {
+		for _, v := range meta.Variants {
+			if isSourcesVariant(v) && len(v.Files) > 0 {
+				d.Sources = true
+			}
+			for _, f := range v.Files {
+				names[f.Name] = true
+			}
+			if v.AvailableAt != nil && v.AvailableAt.Module != "" {
+				r := ProjectXml{GroupId: v.AvailableAt.Group, ArtifactId: v.AvailableAt.Module, Version: v.AvailableAt.Version}
+				if !slices.ContainsFunc(redirects, func(x ProjectXml) bool { return x.Key() == r.Key() }) {
+					logger.Log.Tracef("Variant %s of %s:%s:%s is available at %v", v.Name, d.GroupId, d.ArtifactId, d.Version, r)
+					redirects = append(redirects, r)
+				}
+			}
+		}
+	}
+	for name := range names {
+		c := classifierOf(name, d.ArtifactId, d.Version)
+		switch {
+		case c == "":
+		case c == "sources":
+			d.Sources = true
+		case c == "javadoc" || strings.HasSuffix(c, "-sources") || strings.HasSuffix(c, "-javadoc"):
+		default:
+			if !slices.Contains(d.Classifiers, c) {
+				d.Classifiers = append(d.Classifiers, c)
+			}
+		}
+	}
+	sort.Strings(d.Classifiers)
+	d.Checksums = cacheChecksums(files, tool)
+	return d, redirects
+}
+
+// sha1Dir Имя каталога файла в кеше gradle - SHA-1 файла в hex без ведущих нулей
+var sha1Dir = regexp.MustCompile(`^[0-9a-f]{1,40}$`)
+
+// cacheChecksums Возвращает SHA-1 файлов компонента, записанные сборщиком в кеше: для gradle это имя каталога файла,
+// для maven - содержимое файла .sha1 рядом с файлом, если сборщик его сохранил
+func cacheChecksums(files []string, tool BuildTool) map[string]string {
+	sums := make(map[string]string)
+	for _, f := range files {
+		name := filepath.Base(f)
+		if maven.IsChecksumFile(name) {
+			continue
+		}
+		if tool == Gradle {
+			if dir := filepath.Base(filepath.Dir(f)); sha1Dir.MatchString(dir) {
+				sums[name] = dir
+			}
+			continue
+		}
+		b, err := os.ReadFile(f + ".sha1")
+		if err != nil {
+			continue
+		}
+		if fields := strings.Fields(string(b)); len(fields) > 0 {
+			sums[name] = strings.ToLower(fields[0])
+		}
+	}
+	return sums
+}
+
+// classifierSources Возвращает описание наличия исходных кодов артефакта с классификатором в каталоге зависимости dir
+func classifierSources(d ProjectXml, classifier string, dir string) string {
+	own := d.ArtifactId + "-" + d.Version + "-" + classifier + "-sources.jar"
+	common := d.ArtifactId + "-" + d.Version + "-sources.jar"
+	switch {
+	case fileExists(dir + "/" + own):
+		return "исходники " + own
+	case fileExists(dir + "/" + common):
+		return "общие исходники " + common
+	case fileExists(dir + "/" + d.ArtifactId + "." + Cfg.Archive_format):
+		return "исходники из git"
+	case d.Sources:
+		return "исходники опубликованы, но не скачаны"
+	}
+	return "без исходников"
+}
+
+func fileExists(f string) bool {
+	_, err := os.Stat(f)
+	return err == nil
+}
+
+// reportClassifiers Записывает в Classifier_deps артефакты зависимостей с классификатором и наличие их исходных кодов
+func reportClassifiers(deps []ProjectXml, saveto string, svcName string) {
+	for _, d := range deps {
+		dir := saveto + "/" + d.GroupId + "/" + d.ArtifactId + "/" + d.Version
+		for _, c := range d.Classifiers {
+			s := d.GroupId + ":" + d.ArtifactId + ":" + d.Version + ":" + c + " - " + classifierSources(d, c, dir)
+			MapMutex.Lock()
+			Classifier_deps[svcName] = append(Classifier_deps[svcName], s)
+			MapMutex.Unlock()
+		}
+	}
+}
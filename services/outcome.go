@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"sort"
+)
+
+// Статусы обработки сервиса
+const (
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusSkipped     = "skipped"
+	StatusInterrupted = "interrupted"
+)
+
+// Коды завершения утилиты
+const (
+	ExitOk          = 0   // все сервисы обработаны или пропущены
+	ExitFatal       = 1   // ошибка запуска: конфигурация, окружение, gitlab недоступен
+	ExitPartial     = 2   // обработка части сервисов завершилась с ошибкой
+	ExitInterrupted = 130 // обработка прервана сигналом
+)
+
+// Outcome Результат обработки сервиса: статус, стадия, на которой обработка завершилась ошибкой или была прервана, и причина
+type Outcome struct {
+	Status string
+	Stage  string
+	Reason string
+}
+
+// String Возвращает результат в виде строки отчета
+func (o Outcome) String() string {
+	s := o.Status
+	if o.Stage != "" {
+		s = s + " at stage " + o.Stage
+	}
+	if o.Reason != "" {
+		s = s + ": " + o.Reason
+	}
+	return s
+}
+
+// StageError Ошибка обработки сервиса на стадии Stage
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// SetOutcome Сохраняет результат обработки сервиса svc
+func SetOutcome(svc string, o Outcome) {
+	MapMutex.Lock()
+	Outcomes[svc] = o
+	MapMutex.Unlock()
+}
+
+// Failure Возвращает результат для ошибки обработки сервиса, стадия берется из StageError
+func Failure(err error, stage string) Outcome {
+	var se *StageError
+	if errors.As(err, &se) {
+		return Outcome{Status: StatusFailed, Stage: se.Stage, Reason: se.Err.Error()}
+	}
+	return Outcome{Status: StatusFailed, Stage: stage, Reason: err.Error()}
+}
+
+// ExitCode Возвращает код завершения по результатам обработки сервисов
+func ExitCode() int {
+	MapMutex.RLock()
+	defer MapMutex.RUnlock()
+	code := ExitOk
+	for _, o := range Outcomes {
+		switch o.Status {
+		case StatusInterrupted:
+			return ExitInterrupted
+		case StatusFailed:
+			code = ExitPartial
+		}
+	}
+	return code
+}
+
+// outcomeServices Возвращает имена сервисов с результатом обработки в порядке сортировки
+func outcomeServices() []string {
+	var svcs []string
+	for svc := range Outcomes {
+		svcs = append(svcs, svc)
+	}
+	sort.Strings(svcs)
+	return svcs
+}
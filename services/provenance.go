@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	gitlab_helper "sources/gitlab"
+	"sources/logger"
+	"sources/scm"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// provenanceFile Файл с происхождением исходных кодов в каталоге сервиса и в каталоге зависимости из gitlab
+const provenanceFile = "provenance.json"
+
+// cloneDir Каталог в output_dir для клонирования сервисов, удаляется после упаковки архива
+const cloneDir = ".clone"
+
+// Provenance Происхождение архива исходных кодов: проект gitlab, запрошенный ref и коммит, по SHA которого получен архив.
+// Match - способ, которым найден ref версии внутренней зависимости (тег, ветка или сообщение коммита по шаблону),
+// Provider - хранилище проекта, если это не gitlab.
+type Provenance struct {
+	Provider string    `json:"provider,omitempty"`
+	Project  string    `json:"project"`
+	Url      string    `json:"url"`
+	Ref      string    `json:"ref"`
+	Match    string    `json:"match,omitempty"`
+	Sha      string    `json:"sha"`
+	Date     time.Time `json:"date"`
+	Author   string    `json:"author"`
+	// Submodules Подмодули, исходные коды которых включены в архив при получении клонированием
+	Submodules []gitlab_helper.Submodule `json:"submodules,omitempty"`
+	// Scm Адрес репозитория из pom, по которому найдены исходные коды сторонней зависимости без -sources.jar
+	Scm string `json:"scm,omitempty"`
+}
+
+// String Возвращает происхождение в виде строки отчета
+func (p Provenance) String() string {
+	ref := p.Ref
+	if p.Match != "" {
+		ref = ref + " [" + p.Match + "]"
+	}
+	project := p.Project
+	if p.Provider != "" {
+		project = p.Provider + ":" + project
+	}
+	return project + " " + ref + " (commit " + p.Sha + ", " + p.Date.Format(time.RFC3339) + ", " + p.Author + ")"
+}
+
+// Manifest Происхождение исходных кодов сервиса, его внутренних зависимостей из git и сторонних зависимостей,
+// исходные коды которых получены по scm (ключ - group:artifact:version)
+type Manifest struct {
+	Service         Provenance            `json:"service"`
+	Dependencies    map[string]Provenance `json:"dependencies,omitempty"`
+	ScmDependencies map[string]Provenance `json:"scm_dependencies,omitempty"`
+}
+
+// resolveProvenance Разрешает ref проекта хранилища provider в коммит и возвращает происхождение исходных кодов этого коммита
+func resolveProvenance(ctx context.Context, provider scm.SourceProvider, project *scm.Project, ref string) (Provenance, error) {
+	commit, err := provider.ResolveRef(ctx, project, ref)
+	if err != nil {
+		return Provenance{}, err
+	}
+	p := Provenance{
+		Project: project.FullPath,
+		Url:     project.Url,
+		Ref:     ref,
+		Sha:     commit.Sha,
+		Date:    commit.Date,
+		Author:  commit.Author,
+	}
+	if provider.Name() != "gitlab" {
+		p.Provider = provider.Name()
+	}
+	logger.Log.Debugf("Ref %s of project %s resolved to commit %s", ref, project.FullPath, commit.Sha)
+	return p, nil
+}
+
+// FetchArchive Разрешает ref проекта хранилища provider в коммит и скачивает архив исходных кодов по SHA коммита,
+// чтобы архив соответствовал зафиксированному коммиту, даже если ветка изменится во время обработки
+func FetchArchive(ctx context.Context, provider scm.SourceProvider, project *scm.Project, ref string) ([]byte, Provenance, error) {
+	p, err := resolveProvenance(ctx, provider, project, ref)
+	if err != nil {
+		return nil, Provenance{}, err
+	}
+	archive, err := provider.Archive(ctx, project, p.Sha, Cfg.Archive_format)
+	if err != nil {
+		return nil, Provenance{}, err
+	}
+	return archive, p, nil
+}
+
+// FetchClone Разрешает ref проекта в коммит, клонирует проект на этом коммите с подмодулями и объектами Git LFS
+// и упаковывает исходные коды без .git в архив file. Каталог верхнего уровня в архиве называется как в архиве gitlab.
+func FetchClone(ctx context.Context, project *scm.Project, ref string, file string) (Provenance, error) {
+	p, err := resolveProvenance(ctx, gitlabSource, project, ref)
+	if err != nil {
+		return Provenance{}, err
+	}
+	dir := Cfg.Output_dir + "/" + cloneDir + "/" + project.Path
+	defer os.RemoveAll(dir)
+	root := dir + "/" + project.Path + "-" + p.Sha + "-" + p.Sha
+	p.Submodules, err = gitlab_helper.CloneCommit(ctx, project.Url, ref, p.Sha, root, CloneAuth)
+	if err != nil {
+		return Provenance{}, err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return Provenance{}, err
+	}
+	err = createTgz(ctx, root, dir, f)
+	f.Close()
+	if err != nil {
+		os.Remove(file)
+		return Provenance{}, err
+	}
+	return p, nil
+}
+
+// FetchService Скачивает архив исходных кодов сервиса из ветки branch в файл file и сохраняет его происхождение.
+// Способ получения задается fetch_mode: архив gitlab или клонирование с подмодулями и Git LFS.
+// Архив пишется под временным именем, чтобы в журнал не попал неполный архив.
+func FetchService(ctx context.Context, svc *gitlab.Project, branch string, file string) error {
+	var p Provenance
+	var err error
+	project := scm.GitlabProject(svc)
+	if Cfg.ServiceFetchMode(project.Path) == "clone" {
+		p, err = FetchClone(ctx, project, branch, file+".part")
+	} else {
+		var archive []byte
+		archive, p, err = FetchArchive(ctx, gitlabSource, project, branch)
+		if err == nil {
+			err = os.WriteFile(file+".part", archive, 0644)
+		}
+	}
+	if err == nil {
+		err = os.Rename(file+".part", file)
+	}
+	if err != nil {
+		os.Remove(file + ".part")
+		return err
+	}
+	MapMutex.Lock()
+	Sources_provenance[strings.TrimSpace(svc.Name)] = p
+	MapMutex.Unlock()
+	return nil
+}
+
+// setDepProvenance Сохраняет происхождение зависимости сервиса для README, отчета и манифеста
+func setDepProvenance(svcName string, dep string, p Provenance) {
+	MapMutex.Lock()
+	if Deps_provenance[svcName] == nil {
+		Deps_provenance[svcName] = make(map[string]Provenance)
+	}
+	Deps_provenance[svcName][dep] = p
+	MapMutex.Unlock()
+}
+
+// writeProvenance Записывает происхождение в файл provenanceFile каталога dir
+func writeProvenance(dir string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dir+"/"+provenanceFile, b, 0644)
+}
+
+// readProvenance Читает происхождение зависимости из каталога dir, например после копирования зависимости из кеша
+func readProvenance(dir string) (Provenance, bool) {
+	var p Provenance
+	b, err := os.ReadFile(dir + "/" + provenanceFile)
+	if err != nil {
+		return p, false
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		logger.Log.Warnf("Unable to parse %s: %v", dir+"/"+provenanceFile, err)
+		return p, false
+	}
+	return p, true
+}
+
+// writeManifest Записывает манифест происхождения исходных кодов сервиса и его зависимостей в каталог сервиса
+func writeManifest(svcName string) error {
+	MapMutex.RLock()
+	m := Manifest{Service: Sources_provenance[svcName], Dependencies: Deps_provenance[svcName], ScmDependencies: Scm_sources[svcName]}
+	MapMutex.RUnlock()
+	return writeProvenance(Cfg.Output_dir+"/"+svcName, m)
+}
+
+// depsProvenance Возвращает происхождение внутренних зависимостей сервиса в формате "зависимость: проект ref (commit ...)"
+func depsProvenance(svcName string) []string {
+	var result []string
+	for d, p := range Deps_provenance[svcName] {
+		result = append(result, d+": "+p.String())
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"sources/config"
+	"sources/logger"
+	"sources/scm"
+	"strings"
+)
+
+// refTemplate Подставляет координаты зависимости d в шаблон ref
+func refTemplate(t string, d ProjectXml) string {
+	return strings.NewReplacer("{version}", d.Version, "{artifactId}", d.ArtifactId, "{groupId}", d.GroupId).Replace(t)
+}
+
+// FindRef Находит ref исходных кодов версии зависимости d в проекте хранилища provider по настройкам источника l: тег по шаблонам,
+// затем релизную ветку, затем коммит по сообщению. Возвращает ref (для коммита - SHA) и способ, которым он найден.
+// Пустой ref - версия не найдена.
+func FindRef(ctx context.Context, provider scm.SourceProvider, project *scm.Project, d ProjectXml, l config.RefLookup) (string, string, error) {
+	if len(l.TagTemplates) > 0 {
+		tags, err := provider.ListTags(ctx, project)
+		if err != nil {
+			return "", "", err
+		}
+		names := make(map[string]bool)
+		for _, t := range tags {
+			names[t] = true
+		}
+		for _, t := range l.TagTemplates {
+			if ref := refTemplate(t, d); names[ref] {
+				return ref, "tag " + t, nil
+			}
+		}
+	}
+	for _, t := range l.BranchTemplates {
+		ref := refTemplate(t, d)
+		found, err := provider.HasBranch(ctx, project, ref)
+		if err != nil {
+			return "", "", err
+		}
+		if found {
+			return ref, "branch " + t, nil
+		}
+	}
+	if len(l.CommitTemplates) == 0 {
+		return "", "", nil
+	}
+	var matched string
+	commit, err := provider.FindCommit(ctx, project, l.CommitSearchDepth, func(c *scm.Commit) bool {
+		for _, t := range l.CommitTemplates {
+			if containsRef(c.Message, refTemplate(t, d)) {
+				matched = t
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil || commit == nil {
+		return "", "", err
+	}
+	logger.Log.Debugf("Version %s found by commit message %q in project %s", d.Key(), strings.TrimSpace(commit.Title), project.FullPath)
+	return commit.Sha, "commit message \"" + matched + "\"", nil
+}
+
+// containsRef Проверяет, что msg содержит s без учета регистра, перед s нет цифр номера версии и за s не следует
+// продолжение версии, чтобы "release 1.2" не совпадало с "release 1.2.3", а "1.2" - с "11.2" и "0.1.2"
+func containsRef(msg string, s string) bool {
+	msg, s = strings.ToLower(msg), strings.ToLower(s)
+	if s == "" {
+		return false
+	}
+	for i := strings.Index(msg, s); i >= 0; {
+		rest := msg[i+len(s):]
+		before := i == 0 || !isDigit(msg[i-1]) && !(msg[i-1] == '.' && i > 1 && isDigit(msg[i-2]))
+		after := rest == "" || !versionChar(rest[0]) && !(rest[0] == '.' && len(rest) > 1 && versionChar(rest[1]))
+		if before && after {
+			return true
+		}
+		next := strings.Index(msg[i+1:], s)
+		if next < 0 {
+			break
+		}
+		i = i + 1 + next
+	}
+	return false
+}
+
+// versionChar Проверяет, что символ может продолжать номер версии
+func versionChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c == '-' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
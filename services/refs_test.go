@@ -0,0 +1,50 @@
+package services
+
+import "testing"
+
+func TestContainsRef(t *testing.T) {
+	tests := []struct {
+		msg  string
+		ref  string
+		want bool
+	}{
+		{"Release 1.2", "release 1.2", true},
+		{"release 1.2.3", "release 1.2", false},
+		{"release 1.2.3", "release 1.2.3", true},
+		{"release 1.2-SNAPSHOT", "release 1.2", false},
+		{"release 1.2_hotfix", "release 1.2", false},
+		{"release 1.2rc1", "release 1.2", false},
+		{"Release 1.2.", "release 1.2", true},
+		{"release 1.2, fixes #12", "release 1.2", true},
+		{"[1.2] release", "[1.2]", true},
+		{"release 1.2.3 after release 1.2", "release 1.2", true},
+		{"bump to 11.2", "1.2", false},
+		{"bump to 0.1.2", "1.2", false},
+		{"bump to v1.2", "1.2", true},
+		{"bump to 1.2\nsee 11.2", "1.2", true},
+		{"version 2.0.0", "2.0", false},
+		{"ver 1.2", "", false},
+		{"", "1.2", false},
+	}
+	for _, tt := range tests {
+		if got := containsRef(tt.msg, tt.ref); got != tt.want {
+			t.Errorf("containsRef(%q, %q) = %v, want %v", tt.msg, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestRefTemplate(t *testing.T) {
+	d := ProjectXml{GroupId: "sx.microservices", ArtifactId: "auth-lib", Version: "1.2.3"}
+	tests := map[string]string{
+		"v{version}":               "v1.2.3",
+		"{artifactId}-{version}":   "auth-lib-1.2.3",
+		"release/{version}":        "release/1.2.3",
+		"{groupId}:{artifactId}":   "sx.microservices:auth-lib",
+		"{version}-{version}-tail": "1.2.3-1.2.3-tail",
+	}
+	for tmpl, want := range tests {
+		if got := refTemplate(tmpl, d); got != want {
+			t.Errorf("refTemplate(%q) = %q, want %q", tmpl, got, want)
+		}
+	}
+}
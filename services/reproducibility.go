@@ -0,0 +1,336 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sources/logger"
+	"strings"
+
+	cp "github.com/otiai10/copy"
+)
+
+// artifactsRoot Каталог для артефактов онлайн и оффлайн сборки при проверке воспроизводимости
+const artifactsRoot = ".artifacts"
+
+// reproReport Имя файла с результатом сравнения артефактов в архиве сервиса
+const reproReport = "reproducibility.txt"
+
+// Результаты сравнения артефакта онлайн и оффлайн сборки
+const (
+	reproIdentical   = "идентичны"
+	reproSameContent = "совпадает содержимое (отличаются только метаданные архива)"
+	reproDifferent   = "различаются"
+	reproNoOffline   = "нет в оффлайн сборке"
+	reproNoOnline    = "нет в онлайн сборке"
+)
+
+// ArtifactComparison Результат сравнения одного артефакта онлайн и оффлайн сборки
+type ArtifactComparison struct {
+	Path          string
+	Result        string
+	OnlineSha256  string
+	OfflineSha256 string
+	OnlineGost    string
+	OfflineGost   string
+	Diff          []string
+}
+
+// artifactsDir Возвращает каталог артефактов модуля для сборки kind (online или offline)
+func artifactsDir(svcName string, kind string, module string) string {
+	return Cfg.Output_dir + "/" + artifactsRoot + "/" + svcName + "/" + kind + "/" + module
+}
+
+// isArtifact Проверяет, что файл rel (путь относительно каталога модуля) является результатом сборки:
+// build/libs для gradle или target для maven
+func isArtifact(rel string, tool BuildTool) bool {
+	ext := filepath.Ext(rel)
+	if ext != ".jar" && ext != ".war" && ext != ".ear" {
+		return false
+	}
+	dir := "/" + filepath.ToSlash(filepath.Dir(rel)) + "/"
+	if tool == Maven {
+		return strings.HasSuffix(dir, "/target/")
+	}
+	return strings.HasSuffix(dir, "/build/libs/")
+}
+
+// captureArtifacts Копирует артефакты сборки модуля из dir в dest с сохранением относительных путей
+func captureArtifacts(dir string, tool BuildTool, dest string) error {
+	err := os.RemoveAll(dest)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			switch fi.Name() {
+			case tool.CacheDir(), tool.DepsDir(), ".gradle", ".git", "node_modules":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || !isArtifact(rel, tool) {
+			return err
+		}
+		logger.Log.Tracef("Save build artifact %s to %s", file, dest+"/"+rel)
+		return cp.Copy(file, dest+"/"+rel)
+	})
+}
+
+// listArtifacts Возвращает относительные пути файлов в каталоге dir
+func listArtifacts(dir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	return files, err
+}
+
+// CheckReproducibility Сравнивает артефакты онлайн сборки и оффлайн сборки из архива сервиса:
+// хеши SHA-256 и ГОСТ каждого файла и содержимое jar по записям без учета времени изменения.
+// Результат сохраняется в файл reproducibility.txt каталога сервиса, краткий итог - в Repro_results для отчета.
+func CheckReproducibility(svcName string) error {
+	root := Cfg.Output_dir + "/" + artifactsRoot + "/" + svcName
+	defer os.RemoveAll(root)
+	online, err := listArtifacts(root + "/online")
+	if err != nil {
+		return err
+	}
+	offline, err := listArtifacts(root + "/offline")
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for p := range online {
+		paths = append(paths, p)
+	}
+	for p := range offline {
+		if !online[p] {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	counts := make(map[string]int)
+	var different []string
+	var result []ArtifactComparison
+	for _, p := range paths {
+		c := ArtifactComparison{Path: p}
+		if online[p] {
+			c.OnlineSha256, c.OnlineGost = artifactHashes(root + "/online/" + p)
+		}
+		if offline[p] {
+			c.OfflineSha256, c.OfflineGost = artifactHashes(root + "/offline/" + p)
+		}
+		switch {
+		case !offline[p]:
+			c.Result = reproNoOffline
+		case !online[p]:
+			c.Result = reproNoOnline
+		case c.OnlineSha256 == c.OfflineSha256:
+			c.Result = reproIdentical
+		default:
+			c.Diff, err = diffJarFiles(root+"/online/"+p, root+"/offline/"+p)
+			if err != nil {
+				logger.Log.Warnf("Unable to compare contents of %s: %v", p, err)
+				c.Diff = []string{"ошибка сравнения содержимого: " + err.Error()}
+			}
+			c.Result = reproSameContent
+			if len(c.Diff) > 0 {
+				c.Result = reproDifferent
+			}
+		}
+		if c.Result != reproIdentical && c.Result != reproSameContent {
+			different = append(different, p)
+		}
+		counts[c.Result]++
+		result = append(result, c)
+	}
+	err = writeReproReport(Cfg.Output_dir+"/"+svcName+"/"+reproReport, result)
+	if err != nil {
+		return err
+	}
+	summary := fmt.Sprintf("артефактов %d, идентичны %d, совпадает содержимое %d, различаются %d, отсутствуют %d",
+		len(result), counts[reproIdentical], counts[reproSameContent], counts[reproDifferent], counts[reproNoOffline]+counts[reproNoOnline])
+	if len(result) == 0 {
+		summary = "артефакты сборки не найдены"
+	}
+	if len(different) > 0 {
+		summary = summary + " (" + strings.Join(different, ", ") + ")"
+	}
+	MapMutex.Lock()
+	Repro_results[svcName] = summary
+	MapMutex.Unlock()
+	logger.Log.Infof("Reproducibility of service %s: %s", svcName, summary)
+	return nil
+}
+
+// artifactHashes Возвращает хеши SHA-256 и ГОСТ файла. Ошибка вычисления записывается вместо хеша.
+func artifactHashes(file string) (string, string) {
+	sha, err := sha256File(file)
+	if err != nil {
+		sha = "ошибка: " + err.Error()
+	}
+	gost, err := gostHash(file)
+	if err != nil {
+		logger.Log.Warnf("Unable to calc GOST hash for %s: %v", file, err)
+		gost = "ошибка: " + err.Error()
+	}
+	return sha, strings.TrimSpace(gost)
+}
+
+func sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func diffJarFiles(a string, b string) ([]string, error) {
+	ab, err := os.ReadFile(a)
+	if err != nil {
+		return nil, err
+	}
+	bb, err := os.ReadFile(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffJars(ab, bb, "")
+}
+
+// jarEntry Запись jar без учета времени изменения
+type jarEntry struct {
+	crc  uint32
+	size uint64
+	file *zip.File
+}
+
+func jarEntries(data []byte) (map[string]jarEntry, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]jarEntry)
+	for _, f := range r.File {
+		entries[f.Name] = jarEntry{crc: f.CRC32, size: f.UncompressedSize64, file: f}
+	}
+	return entries, nil
+}
+
+// diffJars Сравнивает записи двух jar по имени, размеру и CRC32, время изменения записей не учитывается.
+// Вложенные jar (например BOOT-INF/lib) с разной CRC сравниваются рекурсивно.
+// Формат различий: "- запись" только в онлайн сборке, "+ запись" только в оффлайн, "* запись" изменена.
+func diffJars(a []byte, b []byte, prefix string) ([]string, error) {
+	ea, err := jarEntries(a)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := jarEntries(b)
+	if err != nil {
+		return nil, err
+	}
+	var diff []string
+	for name, x := range ea {
+		y, ok := eb[name]
+		if !ok {
+			diff = append(diff, "- "+prefix+name)
+			continue
+		}
+		if x.crc == y.crc && x.size == y.size {
+			continue
+		}
+		if strings.HasSuffix(name, ".jar") {
+			nested, err := diffNestedJars(x.file, y.file, prefix+name+"!/")
+			if err == nil {
+				diff = append(diff, nested...)
+				continue
+			}
+			logger.Log.Debugf("Unable to compare nested jar %s: %v", prefix+name, err)
+		}
+		diff = append(diff, "* "+prefix+name)
+	}
+	for name := range eb {
+		if _, ok := ea[name]; !ok {
+			diff = append(diff, "+ "+prefix+name)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i][2:] < diff[j][2:] })
+	return diff, nil
+}
+
+func diffNestedJars(a *zip.File, b *zip.File, prefix string) ([]string, error) {
+	ab, err := readZipFile(a)
+	if err != nil {
+		return nil, err
+	}
+	bb, err := readZipFile(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffJars(ab, bb, prefix)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writeReproReport Записывает результат сравнения артефактов в файл
+func writeReproReport(file string, result []ArtifactComparison) error {
+	var b strings.Builder
+	b.WriteString("Сравнение артефактов онлайн сборки и оффлайн сборки из архива\n")
+	b.WriteString("Содержимое jar сравнивается по именам, размерам и CRC32 записей, время изменения записей не учитывается.\n")
+	b.WriteString("Формат различий: \"- запись\" есть только в онлайн сборке, \"+ запись\" только в оффлайн сборке, \"* запись\" изменена.\n")
+	if len(result) == 0 {
+		b.WriteString("\nАртефакты сборки (build/libs, target) не найдены\n")
+	}
+	for _, c := range result {
+		b.WriteString("\n----------------\nФайл: " + c.Path + "\n")
+		b.WriteString("Результат: " + c.Result + "\n")
+		if c.OnlineSha256 != "" {
+			b.WriteString("SHA-256 онлайн: " + c.OnlineSha256 + "\n")
+			b.WriteString("ГОСТ онлайн: " + c.OnlineGost + "\n")
+		}
+		if c.OfflineSha256 != "" {
+			b.WriteString("SHA-256 оффлайн: " + c.OfflineSha256 + "\n")
+			b.WriteString("ГОСТ оффлайн: " + c.OfflineGost + "\n")
+		}
+		if len(c.Diff) > 0 {
+			b.WriteString("Различия:\n")
+			for _, d := range c.Diff {
+				b.WriteString("  " + d + "\n")
+			}
+		}
+	}
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
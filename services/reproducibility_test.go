@@ -0,0 +1,157 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"reflect"
+	"sources/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jarFile Создает jar с записями name: содержимое, время изменения записей - modified
+func jarFile(t *testing.T, modified time.Time, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range entries {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCaptureArtifacts(t *testing.T) {
+	tests := []struct {
+		name  string
+		tool  BuildTool
+		files []string
+		want  []string
+	}{
+		{
+			name: "gradle",
+			tool: Gradle,
+			files: []string{
+				"build/libs/app.jar", "build/libs/app.txt", "build/tmp/app.jar", "web/build/libs/web.war",
+				"gradle_cache/caches/lib.jar", "gradle_dependencies/lib/build/libs/lib.jar", ".gradle/build/libs/x.jar",
+			},
+			want: []string{"build/libs/app.jar", "web/build/libs/web.war"},
+		},
+		{
+			name:  "maven",
+			tool:  Maven,
+			files: []string{"target/app.jar", "target/classes/App.class", "core/target/core.jar", "maven_cache/com/lib/target/lib.jar"},
+			want:  []string{"core/target/core.jar", "target/app.jar"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dest := t.TempDir(), t.TempDir()+"/online/root"
+			for _, f := range tt.files {
+				writeFile(t, dir+"/"+f, f)
+			}
+			// Артефакты прошлой сборки удаляются
+			writeFile(t, dest+"/build/libs/old.jar", "old")
+			if err := captureArtifacts(dir, tt.tool, dest); err != nil {
+				t.Fatalf("captureArtifacts: %v", err)
+			}
+			files, err := listArtifacts(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]bool)
+			for _, f := range tt.want {
+				got[f] = true
+			}
+			if !reflect.DeepEqual(files, got) {
+				t.Errorf("captured %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckReproducibility(t *testing.T) {
+	Init()
+	Cfg = &config.Configuration{Output_dir: t.TempDir()}
+	online, offline := artifactsDir("svc", "online", "root"), artifactsDir("svc", "offline", "root")
+	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	classes := map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n", "App.class": "app"}
+	same := jarFile(t, t1, classes)
+	nested := map[string]string{"BOOT-INF/lib/lib.jar": string(jarFile(t, t1, map[string]string{"Lib.class": "lib"})), "App.class": "app"}
+	changed := map[string]string{"BOOT-INF/lib/lib.jar": string(jarFile(t, t1, map[string]string{"Lib.class": "lib-2", "Extra.class": "x"})), "App.class": "app"}
+	files := map[string][2]string{
+		"build/libs/identical.jar":  {string(same), string(same)},
+		"build/libs/timestamps.jar": {string(jarFile(t, t1, classes)), string(jarFile(t, t2, classes))},
+		"build/libs/content.jar":    {string(jarFile(t, t1, nested)), string(jarFile(t, t1, changed))},
+		"build/libs/online.jar":     {"online", ""},
+	}
+	for f, c := range files {
+		writeFile(t, online+"/"+f, c[0])
+		if c[1] != "" {
+			writeFile(t, offline+"/"+f, c[1])
+		}
+	}
+	os.MkdirAll(Cfg.Output_dir+"/svc", 0755)
+	if err := CheckReproducibility("svc"); err != nil {
+		t.Fatalf("CheckReproducibility: %v", err)
+	}
+	want := "артефактов 4, идентичны 1, совпадает содержимое 1, различаются 1, отсутствуют 1 (root/build/libs/content.jar, root/build/libs/online.jar)"
+	if Repro_results["svc"] != want {
+		t.Errorf("summary = %q\nwant %q", Repro_results["svc"], want)
+	}
+	b, err := os.ReadFile(Cfg.Output_dir + "/svc/" + reproReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(b)
+	for _, s := range []string{
+		"Файл: root/build/libs/timestamps.jar\nРезультат: " + reproSameContent,
+		"Файл: root/build/libs/identical.jar\nРезультат: " + reproIdentical,
+		"Файл: root/build/libs/online.jar\nРезультат: " + reproNoOffline,
+		"Различия:\n  + BOOT-INF/lib/lib.jar!/Extra.class\n  * BOOT-INF/lib/lib.jar!/Lib.class\n",
+	} {
+		if !strings.Contains(report, s) {
+			t.Errorf("report does not contain %q:\n%s", s, report)
+		}
+	}
+	if _, err := os.Stat(Cfg.Output_dir + "/" + artifactsRoot + "/svc"); !os.IsNotExist(err) {
+		t.Errorf("artifacts directory is not removed: %v", err)
+	}
+}
+
+func TestCheckReproducibilityWithoutArtifacts(t *testing.T) {
+	Init()
+	Cfg = &config.Configuration{Output_dir: t.TempDir()}
+	os.MkdirAll(Cfg.Output_dir+"/svc", 0755)
+	if err := CheckReproducibility("svc"); err != nil {
+		t.Fatalf("CheckReproducibility: %v", err)
+	}
+	if Repro_results["svc"] != "артефакты сборки не найдены" {
+		t.Errorf("summary = %q", Repro_results["svc"])
+	}
+}
+
+func TestDiffJars(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	a := jarFile(t, t1, map[string]string{"A.class": "a", "B.class": "b", "C.class": "c"})
+	b := jarFile(t, t1.Add(time.Minute), map[string]string{"A.class": "a", "B.class": "b2", "D.class": "d"})
+	diff, err := diffJars(a, b, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"* B.class", "- C.class", "+ D.class"}; !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %v, want %v", diff, want)
+	}
+	if _, err := diffJars([]byte("not a jar"), b, ""); err == nil {
+		t.Error("expected error for invalid jar")
+	}
+}
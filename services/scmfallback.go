@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sources/config"
+	"sources/logger"
+	"sources/maven"
+	"sources/scm"
+	"strings"
+)
+
+// scmMirror Зеркало репозиториев сторонних библиотек из scm_fallback с его хранилищем
+type scmMirror struct {
+	config.ScmMirror
+	scm      *regexp.Regexp
+	provider scm.SourceProvider
+}
+
+var scmMirrors []*scmMirror //scmMirrors зеркала scm_fallback в порядке конфигурации
+
+// initScmMirrors Создает хранилища зеркал scm_fallback и компилирует их шаблоны адресов
+func initScmMirrors() error {
+	scmMirrors = nil
+	if Cfg.ScmFallback == nil {
+		return nil
+	}
+	for _, sm := range Cfg.ScmFallback.Mirrors {
+		m := &scmMirror{ScmMirror: sm}
+		var err error
+		m.provider, err = providerFor(sm.Provider, sm.Url, sm.TokenEnv)
+		if err != nil {
+			return fmt.Errorf("scm_fallback mirror %q: %w", sm.Scm, err)
+		}
+		m.scm, err = regexp.Compile(sm.Scm)
+		if err != nil {
+			return fmt.Errorf("invalid scm pattern of scm_fallback mirror %q: %w", sm.Scm, err)
+		}
+		scmMirrors = append(scmMirrors, m)
+	}
+	logger.Log.Debugf("SCM fallback for dependencies without sources enabled with %d mirrors", len(scmMirrors))
+	return nil
+}
+
+// normalizeScmUrl Приводит адрес репозитория из pom к виду host/path: отбрасывает префиксы scm:git:, схему,
+// пользователя, порт, параметры запроса и суффикс .git, адрес вида git@host:path заменяет на host/path
+func normalizeScmUrl(u string) string {
+	u = strings.TrimSpace(u)
+	for strings.HasPrefix(u, "scm:") {
+		_, u, _ = strings.Cut(u[len("scm:"):], ":")
+	}
+	scheme := false
+	if i := strings.Index(u, "://"); i >= 0 {
+		u, scheme = u[i+len("://"):], true
+	}
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		u = u[:i]
+	}
+	host, path, _ := strings.Cut(u, "/")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if h, rest, ok := strings.Cut(host, ":"); ok {
+		host = h
+		// Без схемы двоеточие отделяет путь (git@host:org/repo), со схемой - порт
+		if !scheme && rest != "" {
+			path = strings.TrimPrefix(rest+"/"+path, "/")
+		}
+	}
+	u = strings.ToLower(host) + "/" + strings.Trim(path, "/")
+	return strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git")
+}
+
+// scmProject Находит проект зеркала для адресов репозитория urls из pom: первое зеркало, шаблон которого подходит
+// под адрес и в хранилище которого есть проект по пути из шаблона. Возвращает nil, если проект не найден.
+func scmProject(ctx context.Context, urls []string) (*scmMirror, *scm.Project, string) {
+	for _, u := range urls {
+		norm := normalizeScmUrl(u)
+		for _, m := range scmMirrors {
+			idx := m.scm.FindStringSubmatchIndex(norm)
+			if idx == nil {
+				continue
+			}
+			path := string(m.scm.ExpandString(nil, m.Project, norm, idx))
+			project, err := m.provider.Project(ctx, path)
+			if errors.Is(err, scm.ErrNotFound) {
+				logger.Log.Debugf("Repository %s not found in %s mirror as project %s", u, m.provider.Name(), path)
+				continue
+			}
+			if err != nil {
+				logger.Log.Warnf("Unable to get project %s of repository %s from %s mirror: %v", path, u, m.provider.Name(), err)
+				continue
+			}
+			return m, project, u
+		}
+	}
+	return nil, nil, ""
+}
+
+// downloadFromScm Получает исходные коды сторонней зависимости d, у которой нет -sources.jar, по секции scm ее pom:
+// находит проект зеркала по адресу репозитория и тег версии (tag из scm, затем по шаблонам scm_fallback) и сохраняет
+// архив тега с происхождением рядом с jar в каталоге dir. Возвращает false, если исходные коды не получены.
+func downloadFromScm(ctx context.Context, mvn *maven.Client, d ProjectXml, dir string, svcName string) bool {
+	a := maven.Artifact{GroupId: d.GroupId, ArtifactId: d.ArtifactId, Version: d.Version, Extension: "pom"}
+	var pom *maven.Pom
+	f, err := os.Open(dir + "/" + a.FileName(d.Version))
+	if err == nil {
+		pom, err = maven.ParsePom(f, SetCharsetReader)
+		f.Close()
+	} else {
+		pom, err = mvn.Pom(ctx, a)
+	}
+	if err != nil {
+		logger.Log.Debugf("Unable to read pom of dependency %s for scm lookup: %v", d.Key(), err)
+		return false
+	}
+	s, err := mvn.Scm(ctx, pom)
+	if err != nil {
+		logger.Log.Debugf("Unable to read scm of dependency %s: %v", d.Key(), err)
+	}
+	if len(s.Urls()) == 0 {
+		logger.Log.Debugf("No scm section in pom of dependency %s", d.Key())
+		return false
+	}
+	m, project, scmUrl := scmProject(ctx, s.Urls())
+	if project == nil {
+		logger.Log.Debugf("Repository of dependency %s (%s) not found in scm_fallback mirrors", d.Key(), strings.Join(s.Urls(), ", "))
+		return false
+	}
+	tags, err := m.provider.ListTags(ctx, project)
+	if err != nil {
+		logger.Log.Warnf("Unable to get tags of %s project %s: %v", m.provider.Name(), project.FullPath, err)
+		return false
+	}
+	names := make(map[string]bool)
+	for _, t := range tags {
+		names[t] = true
+	}
+	var tag, match string
+	if s.Tag != "" && s.Tag != "HEAD" && names[s.Tag] {
+		tag, match = s.Tag, "pom scm tag"
+	} else {
+		for _, t := range Cfg.ScmFallback.TagTemplates {
+			if ref := refTemplate(t, d); names[ref] {
+				tag, match = ref, "tag "+t
+				break
+			}
+		}
+	}
+	if tag == "" {
+		logger.Log.Warnf("Not found tag of dependency %s in %s project %s", d.Key(), m.provider.Name(), project.FullPath)
+		return false
+	}
+	archive, prov, err := FetchArchive(ctx, m.provider, project, tag)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Log.Errorf("Unable to download archive of %s from %s: %v", d.Key(), m.provider.Name(), err)
+		}
+		return false
+	}
+	file := d.ArtifactId + "-" + d.Version + "-scm-sources." + Cfg.Archive_format
+	err = os.WriteFile(dir+"/"+file, archive, 0644)
+	if err != nil {
+		logger.Log.Errorf("Error writing archive file: %v", err)
+		return false
+	}
+	err = hashFile(dir + "/" + file)
+	if err != nil {
+		logger.Log.Errorf("Error calc hash for file: %v", err)
+	}
+	prov.Match = match
+	prov.Scm = scmUrl
+	logger.Log.Debugf("Sources of dependency %s fetched from %s project %s tag %s", d.Key(), m.provider.Name(), project.FullPath, tag)
+	setScmSources(svcName, d.Key(), prov)
+	err = writeProvenance(dir, prov)
+	if err != nil {
+		logger.Log.Errorf("Error writing provenance of %s: %v", d.Key(), err)
+	}
+	if Cfg.Cache {
+		for _, f := range []string{provenanceFile, file, file + ".gost"} {
+			err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, f, dir+"/"+f)
+			if err != nil {
+				logger.Log.Errorf("Could not save %s file to cache dir: %v", d.Key(), err)
+			}
+		}
+	}
+	return true
+}
+
+// withoutSources Пробует получить исходные коды зависимости d без -sources.jar по scm, если включен scm_fallback,
+// иначе добавляет зависимость в отчет о зависимостях без исходных кодов
+func withoutSources(ctx context.Context, mvn *maven.Client, d ProjectXml, dir string, svcName string) {
+	if Cfg.ScmFallback != nil && downloadFromScm(ctx, mvn, d, dir, svcName) {
+		return
+	}
+	MapMutex.Lock()
+	Without_src_deps[svcName] = append(Without_src_deps[svcName], d.Key())
+	MapMutex.Unlock()
+}
+
+// setScmSources Сохраняет происхождение исходных кодов сторонней зависимости, полученных по scm, для README, отчета и манифеста
+func setScmSources(svcName string, dep string, p Provenance) {
+	MapMutex.Lock()
+	if Scm_sources[svcName] == nil {
+		Scm_sources[svcName] = make(map[string]Provenance)
+	}
+	Scm_sources[svcName][dep] = p
+	MapMutex.Unlock()
+}
+
+// scmSources Возвращает сторонние зависимости сервиса, исходные коды которых получены по scm,
+// в формате "зависимость: sources from SCM tag тег: проект ref [способ поиска тега] (commit ...)"
+func scmSources(svcName string) []string {
+	var result []string
+	for d, p := range Scm_sources[svcName] {
+		result = append(result, d+": sources from SCM tag "+p.Ref+": "+p.String())
+	}
+	return result
+}
@@ -0,0 +1,23 @@
+package services
+
+import "testing"
+
+func TestNormalizeScmUrl(t *testing.T) {
+	tests := map[string]string{
+		"scm:git:git@github.com:org/repo.git":                  "github.com/org/repo",
+		"  git@github.com:org/repo.git\n":                      "github.com/org/repo",
+		"https://host:8443/org/repo.git":                       "host/org/repo",
+		"scm:git:ssh://git@host:2222/org/repo.git":             "host/org/repo",
+		"scm:git:https://user@GitHub.com/Org/Repo":             "github.com/Org/Repo",
+		"git://github.com/org/repo.git":                        "github.com/org/repo",
+		"http://github.com/org/repo/":                          "github.com/org/repo",
+		"https://github.com/org/repo/tree/master?tab=readme#x": "github.com/org/repo/tree/master",
+		"scm:svn:http://svn.example.com/repo":                  "svn.example.com/repo",
+		"gitlab.example.com:group/sub/repo.git":                "gitlab.example.com/group/sub/repo",
+	}
+	for u, want := range tests {
+		if got := normalizeScmUrl(u); got != want {
+			t.Errorf("normalizeScmUrl(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
@@ -3,58 +3,134 @@ package services
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"sources/config"
-	"sources/nexus"
+	"sources/container"
+	"sources/depgraph"
+	"sources/dockerfile"
 	gitlab_helper "sources/gitlab"
+	"sources/nexus"
 	"sources/logger"
+	"sources/maven"
+	"sources/scheduler"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/cavaliergopher/grab/v3"
 	cp "github.com/otiai10/copy"
 	"github.com/xanzy/go-gitlab"
 	"golang.org/x/exp/slices"
-	"golang.org/x/net/html"
 	"golang.org/x/net/proxy"
 	"golang.org/x/text/encoding/charmap"
 )
 
 var (
 	Cfg                 *config.Configuration //Cfg переменная с полями основного конфигурационного файла утилиты
-	ProjectsMap         map[string]*gitlab.Project
-	ProjectsRtlDepsMap  map[string]*gitlab.Project
 	GitClient           *gitlab.Client
+	Runtime             container.Runtime //Runtime контейнерный рантайм для запуска сборок и выгрузки образов
+	Downloads           *scheduler.Scheduler //Downloads общий планировщик загрузки зависимостей
 	Unknown_sx_deps     map[string][]string
 	Unknown_sx_deps_ver map[string][]string
 	Unknown_deps        map[string][]string
 	Known_deps          map[string][]string
 	Without_src_deps    map[string][]string
+	Modules_deps        map[string]map[string][]string
+	Build_descriptors   map[string][]string
+	Verify_errors       map[string]string
+	Classifier_deps     map[string][]string
+	Deps_origin         map[string]map[string]string
+	Checksum_errors     map[string][]string
+	Repro_results       map[string]string
+	Outcomes            map[string]Outcome //Outcomes результаты обработки сервисов для отчета и кода завершения
+	Sources_provenance  map[string]Provenance //Sources_provenance происхождение архива исходных кодов сервиса
+	Deps_provenance     map[string]map[string]Provenance //Deps_provenance происхождение исходных кодов внутренних зависимостей из gitlab
+	Scm_sources         map[string]map[string]Provenance //Scm_sources происхождение исходных кодов сторонних зависимостей, полученных по scm из pom
 	MapMutex            = sync.RWMutex{}
 	UnknownProjects     []string
+	CloneAuth           gitlab_helper.CloneOptions //CloneAuth токен и настройки TLS для клонирования репозиториев gitlab
+	AmbiguousProjects   map[string][]string //AmbiguousProjects пути проектов сервисов, которые есть в нескольких подгруппах, с полными путями проектов
+	AmbiguousSources    map[string][]string //AmbiguousSources пути проектов источников зависимостей, которые есть в нескольких группах или подгруппах
 )
 
 // ProjectXml Тип реализующий структуру maven зависимостей
 type ProjectXml struct {
-	GroupId    string `xml:"groupId"`
-	ArtifactId string `xml:"artifactId"`
-	Version    string `xml:"version"`
+	GroupId     string            `xml:"groupId"`
+	ArtifactId  string            `xml:"artifactId"`
+	Version     string            `xml:"version"`
+	Classifiers []string          `xml:"-"` // Артефакты с классификатором (natives, all и т.п.) из кеша и Gradle Module Metadata
+	Sources     bool              `xml:"-"` // В кеше или в Gradle Module Metadata есть артефакт с исходными кодами
+	Checksums   map[string]string `xml:"-"` // SHA-1 файлов зависимости из кеша сборки (имя файла - сумма)
+}
+
+// Key Возвращает координаты зависимости в формате group:artifact:version
+func (p ProjectXml) Key() string {
+	return p.GroupId + ":" + p.ArtifactId + ":" + p.Version
+}
+
+// Module Модуль сервиса, который собирается по отдельному файлу сборки (по умолчанию Dockerfile.pgs2)
+type Module struct {
+	Name       string
+	Dockerfile string
+	Descriptor string
+	Stage      string
+	Tool       BuildTool
+	Image      string
+	InitScript bool
+	// Graph Граф зависимостей модуля не хранится в журнале, при продолжении обработки он читается из каталога dependency_graph
+	Graph *depgraph.Graph `json:"-"`
+}
+
+// BuildTool Тип системы сборки сервиса
+type BuildTool string
+
+const (
+	Gradle BuildTool = "gradle"
+	Maven  BuildTool = "maven"
+)
+
+// Command Возвращает имя команды системы сборки, которая используется в Dockerfile
+func (t BuildTool) Command() string {
+	if t == Maven {
+		return "mvn"
+	}
+	return "gradle"
+}
+
+// CacheDir Возвращает имя каталога с локальным кешем зависимостей, который заполняется при сборке
+func (t BuildTool) CacheDir() string {
+	if t == Maven {
+		return "maven_cache"
+	}
+	return "gradle_cache"
+}
+
+// DepsDir Возвращает имя каталога с зависимостями для оффлайн сборки в итоговом архиве
+func (t BuildTool) DepsDir() string {
+	if t == Maven {
+		return "maven_dependencies"
+	}
+	return "gradle_dependencies"
+}
+
+// DepsLookupDir Возвращает каталог внутри кеша сборки, в котором ищутся *.pom файлы зависимостей
+func (t BuildTool) DepsLookupDir() string {
+	if t == Maven {
+		return t.CacheDir() + "/"
+	}
+	return t.CacheDir() + "/caches/modules-2/files-2.1/"
 }
 
 // Init функция инициализации, создает необходимые map
@@ -64,6 +140,28 @@ func Init() {
 	Unknown_sx_deps = make(map[string][]string)
 	Unknown_sx_deps_ver = make(map[string][]string)
 	Without_src_deps = make(map[string][]string)
+	Modules_deps = make(map[string]map[string][]string)
+	Build_descriptors = make(map[string][]string)
+	Verify_errors = make(map[string]string)
+	Classifier_deps = make(map[string][]string)
+	Deps_origin = make(map[string]map[string]string)
+	Checksum_errors = make(map[string][]string)
+	Repro_results = make(map[string]string)
+	Outcomes = make(map[string]Outcome)
+	Sources_provenance = make(map[string]Provenance)
+	Deps_provenance = make(map[string]map[string]Provenance)
+	Scm_sources = make(map[string]map[string]Provenance)
+}
+
+// depWithModules Возвращает зависимость сервиса с перечислением модулей, в которых она используется.
+// Для сервисов из одного модуля возвращается зависимость без изменений.
+func depWithModules(svc string, dep string) string {
+	MapMutex.RLock()
+	defer MapMutex.RUnlock()
+	if len(Modules_deps[svc]) == 0 {
+		return dep
+	}
+	return dep + " (" + strings.Join(Modules_deps[svc][dep], ", ") + ")"
 }
 
 func hashFile(f string) error {
@@ -71,11 +169,8 @@ func hashFile(f string) error {
 	if _, err := os.Stat(f); errors.Is(err, os.ErrNotExist) {
 		return err
 	}
-	cmd := exec.Command("bash", "-c", "cpverify -mk \""+f+"\"")
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-	if err := cmd.Run(); err != nil {
+	h, err := gostHash(f)
+	if err != nil {
 		return err
 	}
 	h_file, err := os.Create(f + ".gost")
@@ -83,14 +178,26 @@ func hashFile(f string) error {
 		return err
 	}
 	defer h_file.Close()
-	_, err = h_file.WriteString(outb.String())
+	_, err = h_file.WriteString(h)
 	if err != nil {
 		return err
 	}
-	logger.Log.Tracef("Hash for file %s: %s", f, outb.String())
+	logger.Log.Tracef("Hash for file %s: %s", f, h)
 	return nil
 }
 
+// gostHash Возвращает вывод cpverify -mk для файла f (хеш по ГОСТ Р 34.11-2012)
+func gostHash(f string) (string, error) {
+	cmd := exec.Command("bash", "-c", "cpverify -mk \""+f+"\"")
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return outb.String(), nil
+}
+
 func ExtractTgz(gzipStream io.Reader, output string) error {
 	uStream, err := gzip.NewReader(gzipStream)
 	if err != nil {
@@ -118,11 +225,15 @@ func ExtractTgz(gzipStream io.Reader, output string) error {
 		}
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := os.Mkdir(output+"/"+header.Name, 0755); err != nil {
+			if err := os.MkdirAll(output+"/"+header.Name, 0755); err != nil {
 				return fmt.Errorf("Error extracting, failed to create output dir: %s", err.Error())
 			}
 		case tar.TypeReg:
-			outFile, err := os.Create(output + "/" + header.Name)
+			if err := os.MkdirAll(filepath.Dir(output+"/"+header.Name), 0755); err != nil {
+				return fmt.Errorf("Error extracting, failed to create output dir: %s", err.Error())
+			}
+			// Сохраняем права файла, иначе теряется признак исполнения у gradlew/mvnw
+			outFile, err := os.OpenFile(output+"/"+header.Name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm()|0600)
 			if err != nil {
 				return fmt.Errorf("Error extracting, failed create output file failed: %s", err.Error())
 			}
@@ -136,7 +247,14 @@ func ExtractTgz(gzipStream io.Reader, output string) error {
 	return nil
 }
 
-func CreateTgz(src string, buf io.Writer) error {
+// CreateTgz Упаковывает файл или каталог src в tgz поток buf, упаковка прерывается при отмене ctx
+func CreateTgz(ctx context.Context, src string, buf io.Writer) error {
+	return createTgz(ctx, src, "", buf)
+}
+
+// createTgz Упаковывает файл или каталог src в tgz поток buf. Если задан base, имена в архиве указываются относительно base,
+// иначе - путем файла. Символические ссылки сохраняются ссылками.
+func createTgz(ctx context.Context, src string, base string, buf io.Writer) error {
 	zr := gzip.NewWriter(buf)
 	tw := tar.NewWriter(zr)
 	fi, err := os.Stat(src)
@@ -160,26 +278,50 @@ func CreateTgz(src string, buf io.Writer) error {
 			return err
 		}
 	} else if mode.IsDir() {
-		filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-			header, err := tar.FileInfoHeader(fi, file)
+		err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(fi, link)
 			if err != nil {
 				return err
 			}
 			header.Name = filepath.ToSlash(file)
+			if base != "" {
+				rel, err := filepath.Rel(base, file)
+				if err != nil {
+					return err
+				}
+				header.Name = filepath.ToSlash(rel)
+			}
 			if err := tw.WriteHeader(header); err != nil {
 				return err
 			}
-			if !fi.IsDir() {
+			if fi.Mode().IsRegular() {
 				data, err := os.Open(file)
 				if err != nil {
 					return err
 				}
-				if _, err := io.Copy(tw, data); err != nil {
+				_, err = io.Copy(tw, data)
+				data.Close()
+				if err != nil {
 					return err
 				}
 			}
 			return nil
 		})
+		if err != nil {
+			return err
+		}
 	} else {
 		return fmt.Errorf("error: file type not supported")
 	}
@@ -192,86 +334,250 @@ func CreateTgz(src string, buf io.Writer) error {
 	return nil
 }
 
-// ParseDockerfile Фунция парсит докерфал и возвращает имя образа, команду для сборки и версию gradle
-func ParseDockerfile(file string) (image string, command string, version string, err error) {
+// BuildPlan План сборки модуля, полученный из Dockerfile: стадия сборки (имя или #номер), образ сборщика, команды сборки по порядку и версия системы сборки
+type BuildPlan struct {
+	Stage    string
+	Image    dockerfile.ImageRef
+	Commands []string
+	Version  string
+}
+
+// Command Возвращает команды сборки одной строкой через &&, к каждой команде добавляются флаги flags
+func (p *BuildPlan) Command(flags string) string {
+	var cmds []string
+	for _, c := range p.Commands {
+		cmds = append(cmds, c+flags)
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// Executable Возвращает исполняемый файл системы сборки из первой команды сборки, например ./gradlew
+func (p *BuildPlan) Executable() string {
+	return strings.Fields(p.Commands[0])[0]
+}
+
+// ParseDockerfile Фунция парсит докерфал и возвращает план сборки: стадию и образ сборщика, команды сборки и версию системы сборки
+func ParseDockerfile(file string, tool BuildTool, stagePattern string) (*BuildPlan, error) {
 	logger.Log.Debugf("Start parsing Dockerfile %s", file)
-	f, err := os.Open(file)
+	d, err := dockerfile.ParseFile(file)
 	if err != nil {
-		logger.Log.Errorf("Unable to open Dockerfile.pgs, error: %v", err)
-		return image, command, version, err
+		logger.Log.Errorf("Unable to parse Dockerfile %s, error: %v", file, err)
+		return nil, err
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	line := 1
-	for scanner.Scan() {
-		if strings.Contains(strings.ToLower(scanner.Text()), "as build") || strings.Contains(strings.ToLower(scanner.Text()), "as gradle_build") {
-			image = strings.Split(strings.TrimSpace(scanner.Text()), " ")[1]
-			if image == "" {
-				return image, command, version, errors.New("Failed to determine image to build")
+	stageRe, err := regexp.Compile("(?i)" + stagePattern)
+	if err != nil {
+		logger.Log.Errorf("Wrong build stage pattern %s: %v", stagePattern, err)
+		return nil, err
+	}
+	stage := builderStage(d, tool, stageRe)
+	if stage == nil {
+		return nil, errors.New("Failed to determine image to build")
+	}
+	plan := &BuildPlan{Stage: stage.Name}
+	if plan.Stage == "" {
+		// Безымянная стадия обозначается порядковым номером
+		plan.Stage = fmt.Sprintf("#%d", stage.Index)
+	}
+	// Стадия может строиться от другой стадии, образ сборщика и команды берем по всей цепочке
+	chain := []*dockerfile.Stage{stage}
+	for s := stage; s.BaseStage != nil; s = s.BaseStage {
+		chain = append([]*dockerfile.Stage{s.BaseStage}, chain...)
+	}
+	plan.Image = chain[0].ImageRef()
+	logger.Log.Debugf("Build stage: %s, image: %s", plan.Stage, plan.Image)
+	for _, s := range chain {
+		for _, run := range s.Runs() {
+			for _, c := range splitShell(run) {
+				if isToolCommand(c, tool) {
+					logger.Log.Debugf("Parsed command: %s", c)
+					plan.Commands = append(plan.Commands, withProxy(c))
+				}
 			}
-			logger.Log.Debugf("Build image: %s", image)
-			version = strings.Split(strings.Split(strings.TrimSpace(image), ":")[2], "-")[0]
-			logger.Log.Debugf("Gradle version: %s", version)
 		}
-		if strings.Contains(strings.ToLower(scanner.Text()), " gradle ") {
-			lens := len(scanner.Text())
-			lasts := strings.LastIndex(scanner.Text(), "gradle")
-			command = scanner.Text()[lasts:lens]
-			logger.Log.Debugf("Parsed command: %s", command)
+	}
+	if len(plan.Commands) == 0 {
+		return nil, errors.New("Failed to determine command to build")
+	}
+	plan.Version = toolVersion(plan.Image, filepath.Dir(file), tool)
+	if plan.Version == "" {
+		logger.Log.Warnf("Failed to determine %s version for %s", tool, file)
+	}
+	logger.Log.Debugf("%s version: %s", tool, plan.Version)
+	return plan, nil
+}
+
+// builderStage Возвращает стадию сборки: первую стадию с именем по шаблону stageRe, иначе первую стадию с командой системы сборки
+func builderStage(d *dockerfile.Dockerfile, tool BuildTool, stageRe *regexp.Regexp) *dockerfile.Stage {
+	for _, s := range d.Stages {
+		if s.Name != "" && stageRe.MatchString(s.Name) {
+			return s
+		}
+	}
+	for _, s := range d.Stages {
+		for _, run := range s.Runs() {
+			for _, c := range splitShell(run) {
+				if isToolCommand(c, tool) {
+					return s
+				}
+			}
 		}
-		line++
+	}
+	return nil
+}
 
-		if err := scanner.Err(); err != nil {
-			logger.Log.Errorf("Error parse Dockerfile: %v", err)
-			return image, command, version, err
+// splitShell Делит команду shell на простые команды по &&, ||, ; и переводам строк вне кавычек
+func splitShell(cmd string) []string {
+	var cmds []string
+	var cur strings.Builder
+	var quote byte
+	flush := func() {
+		if c := strings.TrimSpace(cur.String()); c != "" {
+			cmds = append(cmds, c)
+		}
+		cur.Reset()
+	}
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';' || c == '\n':
+			flush()
+			continue
+		case (c == '&' || c == '|') && i+1 < len(cmd) && cmd[i+1] == c:
+			flush()
+			i++
+			continue
 		}
+		cur.WriteByte(c)
+	}
+	flush()
+	return cmds
+}
+
+// isToolCommand Признак вызова системы сборки или ее wrapper (gradle, ./gradlew, mvn, ./mvnw)
+func isToolCommand(cmd string, tool BuildTool) bool {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return false
+	}
+	exe := path.Base(fields[0])
+	return exe == tool.Command() || exe == tool.Command()+"w"
+}
 
+// withProxy Добавляет в команду сборки параметры socks прокси, если прокси включен в конфиге
+func withProxy(cmd string) string {
+	if !Cfg.Proxy {
+		return cmd
 	}
-	if image == "" {
-		return image, command, version, errors.New("Failed to determine image to build")
+	exe, args, _ := strings.Cut(cmd, " ")
+	flags := " -DsocksProxyHost=" + Cfg.ProxyHost + " -DsocksProxyPort=" + Cfg.ProxyPort
+	if Cfg.ProxyUser != "nil" {
+		flags = flags + " -Djava.net.socks.username=" + Cfg.ProxyUser + " -Djava.net.socks.password=" + Cfg.ProxyPass
 	}
-	if command == "" {
-		return image, command, version, errors.New("Failed to determine command to build")
+	return exe + flags + " " + args
+}
+
+// toolVersion Определяет версию системы сборки по тегу образа сборщика (gradle:7.4.1-jdk11),
+// а если образ не официальный, то по файлу свойств wrapper в каталоге модуля
+func toolVersion(image dockerfile.ImageRef, dir string, tool BuildTool) string {
+	if image.Name() == string(tool) {
+		v := strings.Split(image.Tag, "-")[0]
+		if v != "" && v[0] >= '0' && v[0] <= '9' {
+			return v
+		}
 	}
-	if version == "" {
-		return image, command, version, errors.New("Failed to determine gradle version")
+	props := dir + "/gradle/wrapper/gradle-wrapper.properties"
+	re := regexp.MustCompile(`gradle-([0-9][^-/]*)-(bin|all)\.zip`)
+	if tool == Maven {
+		props = dir + "/.mvn/wrapper/maven-wrapper.properties"
+		re = regexp.MustCompile(`apache-maven-([0-9][^-/]*)-bin\.zip`)
 	}
-	if Cfg.Proxy {
-		if Cfg.ProxyUser != "nil" {
-			command = strings.Replace(command, "gradle ", " gradle -DsocksProxyHost="+Cfg.ProxyHost+" -DsocksProxyPort="+Cfg.ProxyPort+" -Djava.net.socks.username="+Cfg.ProxyUser+" -Djava.net.socks.password="+Cfg.ProxyPass+" ", 1)
-		} else {
-			command = strings.Replace(command, "gradle ", " gradle -DsocksProxyHost="+Cfg.ProxyHost+" -DsocksProxyPort="+Cfg.ProxyPort+" ", 1)
-		}
+	b, err := os.ReadFile(props)
+	if err != nil {
+		return ""
 	}
-
-	return image, command, version, nil
+	if m := re.FindSubmatch(b); m != nil {
+		return string(m[1])
+	}
+	return ""
 }
 
-func findDockerfile(rootdir string) string {
-	var dir string
-	var files []string
+// findDockerfiles Возвращает найденные в исходниках сервиса файлы сборки, по одному на модуль.
+// Имена файлов проверяются в порядке приоритета, используются файлы первого найденного имени.
+func findDockerfiles(rootdir string, descriptors []string) ([]string, error) {
+	found := make(map[string][]string)
 	err := filepath.Walk(rootdir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			logger.Log.Errorf("Error finding Dockerfile dir: %v", err)
 			return nil
 		}
 
-		if !info.IsDir() && filepath.Base(path) == "Dockerfile.pgs2" {
-			logger.Log.Debugf("Found Dockerfile.pgs2 file, path: %s dir: %s", path, filepath.Dir(path))
-			files = append(files, path)
+		if !info.IsDir() && slices.Contains(descriptors, filepath.Base(path)) {
+			logger.Log.Debugf("Found %s file, path: %s dir: %s", filepath.Base(path), path, filepath.Dir(path))
+			found[filepath.Base(path)] = append(found[filepath.Base(path)], path)
 		}
 
 		return nil
 	})
 	if err != nil {
 		logger.Log.Errorf("Error finding Dockerfile dir: %v", err)
-		return ""
+		return nil, err
+	}
+	for _, d := range descriptors {
+		files := found[d]
+		if len(files) == 0 {
+			continue
+		}
+		if len(files) > 1 {
+			logger.Log.Infof("Found %d %s files in %s, every module will be built", len(files), d, rootdir)
+		}
+		return files, nil
+	}
+	return nil, errors.New(strings.Join(descriptors, ", ") + " not found in " + rootdir)
+}
+
+// sourcesRoot Возвращает корневой каталог распакованных исходников сервиса, в котором находится dockerfile
+func sourcesRoot(rootdir string, dockerfile string) string {
+	rel, err := filepath.Rel(rootdir, dockerfile)
+	if err != nil {
+		return filepath.Dir(dockerfile)
+	}
+	return filepath.Join(rootdir, strings.Split(filepath.ToSlash(rel), "/")[0])
+}
+
+// moduleName Возвращает имя модуля как путь каталога с dockerfile относительно корня исходников, "root" для корневого модуля
+func moduleName(rootdir string, dockerfile string) string {
+	rel, err := filepath.Rel(sourcesRoot(rootdir, dockerfile), filepath.Dir(dockerfile))
+	if err != nil || rel == "." {
+		return "root"
 	}
-	if len(files) > 1 {
-		logger.Log.Warnf("Found more than 1 Dockerfile.pgs2 file, will user first found")
+	return filepath.ToSlash(rel)
+}
+
+// gradleScript Возвращает путь к скрипту gradle на Groovy (name) или Kotlin DSL (name.kts), либо пустую строку
+func gradleScript(dir string, name string) string {
+	for _, f := range []string{name, name + ".kts"} {
+		if _, err := os.Stat(dir + "/" + f); err == nil {
+			return dir + "/" + f
+		}
 	}
-	dir = files[0]
-	return dir
+	return ""
+}
+
+// detectBuildTool Определяет систему сборки сервиса по файлам в каталоге с Dockerfile.pgs2
+func detectBuildTool(dir string) (BuildTool, error) {
+	if gradleScript(dir, "build.gradle") != "" || gradleScript(dir, "settings.gradle") != "" {
+		return Gradle, nil
+	}
+	if _, err := os.Stat(dir + "/pom.xml"); err == nil {
+		return Maven, nil
+	}
+	return "", errors.New("Unable to find build.gradle(.kts) or pom.xml file in " + dir)
 }
 
 func SetCharsetReader(charset string, input io.Reader) (io.Reader, error) {
@@ -301,66 +607,61 @@ func GetDepInfoFromPom(pompath string) ProjectXml {
 	return pxml
 }
 
-func GetDependencies(depspath string) []ProjectXml {
+func GetDependencies(depspath string, tool BuildTool) []ProjectXml {
 	var deps []ProjectXml
+	var dirs []string
+	seen := make(map[string]bool)
 	logger.Log.Tracef("Looking deps in dir: %s", depspath)
 	err := filepath.Walk(depspath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			logger.Log.Errorf("Error building dependencies tree: %v", err)
 			return nil
 		}
-		m, err := filepath.Match("*.pom", filepath.Base(path))
-		logger.Log.Tracef("Lookup in path: %s", filepath.Base(path))
-		if err != nil {
-			logger.Log.Errorf("Error building dependencies tree: %v", err)
+		if info.IsDir() {
 			return nil
 		}
-		if !info.IsDir() && m {
-			logger.Log.Tracef("Found pom.xml file, path: %s dir: %s", path, filepath.Dir(path))
-			a := GetDepInfoFromPom(path)
-			if a.Version == "" || a.GroupId == "" || strings.Contains(a.GroupId, "$") || strings.Contains(a.Version, "$") {
-				parts := strings.Split(path, string(os.PathSeparator))
-				a.GroupId = parts[len(parts)-5]
-				a.ArtifactId = parts[len(parts)-4]
-				a.Version = parts[len(parts)-3]
-				logger.Log.Tracef("Error, no version in pom file, get from dir %v", a)
-
+		logger.Log.Tracef("Lookup in path: %s", filepath.Base(path))
+		// Каталог версии компонента: group/artifact/version/<sha1>/file в кеше gradle, .../artifactId/version/file в maven
+		dir := filepath.Dir(path)
+		if tool == Maven {
+			ext := filepath.Ext(path)
+			if ext != ".pom" && ext != ".module" && ext != ".jar" {
+				return nil
 			}
-			logger.Log.Tracef("Parsed dep: %v", a)
-			deps = append(deps, a)
+		} else {
+			dir = filepath.Dir(dir)
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
 		}
-
 		return nil
 	})
 	if err != nil {
 		logger.Log.Errorf("Error finding dependencies: %v", err)
 		return nil
 	}
-	return deps
-}
-
-func ParseLinks(body io.Reader) []string {
-	var links []string
-	logger.Log.Tracef("Start link parsing")
-	z := html.NewTokenizer(body)
-	for {
-		tt := z.Next()
-		switch tt {
-		case html.ErrorToken:
-			return links
-		case html.StartTagToken, html.EndTagToken:
-			token := z.Token()
-			if "a" == token.Data {
-				for _, attr := range token.Attr {
-					if attr.Key == "href" {
-						links = append(links, attr.Val)
-					}
-
-				}
-			}
-
+	found := make(map[string]bool)
+	var redirects []ProjectXml
+	for _, dir := range dirs {
+		d, r := componentFromCache(depspath, dir, tool)
+		if d.ArtifactId == "" || found[d.Key()] {
+			continue
+		}
+		logger.Log.Tracef("Parsed dep: %v", d)
+		found[d.Key()] = true
+		deps = append(deps, d)
+		redirects = append(redirects, r...)
+	}
+	// Платформенные варианты из .module, опубликованные в других компонентах, добавляются как отдельные зависимости
+	for _, r := range redirects {
+		if !found[r.Key()] {
+			logger.Log.Tracef("Add dependency %s from available-at of module metadata", r.Key())
+			found[r.Key()] = true
+			deps = append(deps, r)
 		}
 	}
+	return deps
 }
 
 func CheckInCache(p string) (haveSource bool, existInCache bool, err error) {
@@ -390,6 +691,9 @@ func CheckInCache(p string) (haveSource bool, existInCache bool, err error) {
 			if strings.Contains(file.Name(), ".tgz") && !strings.Contains(file.Name(), ".tgz.") {
 				s = true
 			}
+			if strings.Contains(file.Name(), "-scm-sources.") && !strings.HasSuffix(file.Name(), ".gost") {
+				s = true
+			}
 
 		}
 		if s == true || j == false {
@@ -406,7 +710,7 @@ func SaveToCache(d string, f string, s string) error {
 	if _, err := os.Stat(filepath.Join(Cfg.CacheDir, d)); os.IsNotExist(err) {
 		err := os.MkdirAll(filepath.Join(Cfg.CacheDir, d), 0744)
 		if err != nil {
-			logger.Log.Fatalf("Error creating dep output dir %s: %v", filepath.Join(Cfg.CacheDir, d), err)
+			logger.Log.Errorf("Error creating dep output dir %s: %v", filepath.Join(Cfg.CacheDir, d), err)
 			return err
 		}
 	}
@@ -429,16 +733,17 @@ func SaveToCache(d string, f string, s string) error {
 		return err
 	}
 	defer dst_file.Close()
-	io.Copy(dst_file, src_file)
-	return nil
+	_, err = io.Copy(dst_file, src_file)
+	return err
 }
 
-func DownloadDeps(deps []ProjectXml, saveto string, svcName string) error {
+// DownloadDeps Скачивает исходные коды зависимостей сервиса в каталог saveto. При отмене ctx новые загрузки не начинаются,
+// начатые прерываются, возвращается ошибка ctx. Ошибки загрузки зависимостей (кроме несовпадения контрольной суммы,
+// которое исключает только саму зависимость) собираются и возвращаются после завершения всех начатых загрузок.
+func DownloadDeps(ctx context.Context, deps []ProjectXml, saveto string, svcName string) error {
 	logger.Log.Tracef("Downloading deps")
-	var path string
 	var dwg sync.WaitGroup
 	client := &http.Client{}
-	grab_client := grab.NewClient()
 	if Cfg.Proxy {
 		logger.Log.Debugf("Using socks proxy for http requests")
 		auth := proxy.Auth{
@@ -447,31 +752,63 @@ func DownloadDeps(deps []ProjectXml, saveto string, svcName string) error {
 		}
 		dialer, err := proxy.SOCKS5("tcp", Cfg.ProxyHost+":"+Cfg.ProxyPort, &auth, proxy.Direct)
 		if err != nil {
-			logger.Log.Fatalf("Can't connect to the proxy: %v", err)
+			logger.Log.Errorf("Can't connect to the proxy: %v", err)
+			return err
 		}
 		tr := &http.Transport{Dial: dialer.Dial}
 		client = &http.Client{
 			Transport: tr,
 		}
-		grab_client.HTTPClient = client
 	}
-	for i, d := range deps {
+	client.Transport = Downloads.Transport(client.Transport)
+	mvn := maven.NewClient(client, mavenRepositories()...)
+	mvn.CharsetReader = SetCharsetReader
+	// Число одновременно обрабатываемых зависимостей сервиса ограничено общим лимитом загрузок
+	workers := make(chan struct{}, Downloads.Concurrency())
+	var errs []error
+	var errsMutex sync.Mutex
+	fail := func(err error) {
+		errsMutex.Lock()
+		errs = append(errs, err)
+		errsMutex.Unlock()
+	}
+	for _, d := range deps {
+		if ctx.Err() != nil {
+			break
+		}
 		MapMutex.Lock()
 		Known_deps[svcName] = append(Known_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
 		MapMutex.Unlock()
 		if Cfg.Cache {
 			src, c, err := CheckInCache(d.GroupId + "/" + d.ArtifactId + "/" + d.Version)
 			if err != nil {
-				logger.Log.Fatalf("Error reading cache files: %v", err)
-				return err
+				logger.Log.Errorf("Error reading cache files: %v", err)
+				fail(err)
+				break
 			}
 			if c {
 				logger.Log.Tracef("Copy dependecy %s files from cache", d.GroupId+":"+d.ArtifactId+":"+d.Version)
 				err := cp.Copy(filepath.Join(Cfg.CacheDir, d.GroupId, d.ArtifactId, d.Version), filepath.Join(saveto, d.GroupId, d.ArtifactId, d.Version))
 				if err != nil {
-					logger.Log.Fatalf("Error copying files from cache: %v", err)
+					logger.Log.Errorf("Error copying files from cache: %v", err)
+					fail(err)
+					break
 				}
-				if src == false {
+				if p, ok := readProvenance(filepath.Join(saveto, d.GroupId, d.ArtifactId, d.Version)); ok && p.Scm != "" {
+					setScmSources(svcName, d.Key(), p)
+				} else if ok {
+					setDepProvenance(svcName, d.Key(), p)
+				}
+				if src == false && Cfg.ScmFallback != nil {
+					// Исходные коды зависимости из кеша без -sources.jar ищутся по scm, если scm_fallback включен после ее кеширования
+					dwg.Add(1)
+					workers <- struct{}{}
+					go func(d ProjectXml) {
+						defer func() { <-workers }()
+						withoutSources(ctx, mvn, d, filepath.Join(saveto, d.GroupId, d.ArtifactId, d.Version), svcName)
+						dwg.Done()
+					}(d)
+				} else if src == false {
 					MapMutex.Lock()
 					Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
 					MapMutex.Unlock()
@@ -479,307 +816,313 @@ func DownloadDeps(deps []ProjectXml, saveto string, svcName string) error {
 				continue
 			}
 		}
-		a := [4]string{saveto, d.GroupId, d.ArtifactId, d.Version}
-		path = ""
-		for _, pathpart := range a {
-			path = path + pathpart + "/"
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				err := os.Mkdir(path, 0744)
-				if err != nil {
-					logger.Log.Fatalf("Error creating dep output dir %s: %v", path, err)
-					return err
-				}
-			}
+		err := os.MkdirAll(filepath.Join(saveto, d.GroupId, d.ArtifactId, d.Version), 0744)
+		if err != nil {
+			logger.Log.Errorf("Error creating dep output dir: %v", err)
+			fail(err)
+			break
 		}
 		dwg.Add(1)
-		go func(i int, d ProjectXml) error {
-			if d.GroupId == "sx.microservices" {
-				logger.Log.Tracef("Systematica dependency, download from Gitlab : %v", d)
-				if ProjectsMap[d.ArtifactId] != nil {
-					logger.Log.Tracef("Found dependency in gitlab, project: %v", ProjectsMap[d.ArtifactId].Name)
-					tags := gitlab_helper.GetProjectTag(GitClient, ProjectsMap[d.ArtifactId].ID)
-					tagf := false
-					for _, t := range tags {
-						if t.Name == "v"+d.Version {
-							tagf = true
-						}
-					}
-					if tagf {
-						ver := "v" + d.Version
-						logger.Log.Tracef("Trying to download service %s:%s:%s from gitlab", d.GroupId, d.ArtifactId, d.Version)
-						err := os.WriteFile(saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+d.ArtifactId+"."+Cfg.Archive_format,
-							gitlab_helper.GetProjectArchive(GitClient, ProjectsMap[d.ArtifactId].ID, &Cfg.Archive_format, &ver), 0644)
-						if err != nil {
-							logger.Log.Fatalf("Error writing archive file: %v", err)
-						}
-						err = hashFile(saveto + "/" + d.GroupId + "/" + d.ArtifactId + "/" + d.Version + "/" + d.ArtifactId + "." + Cfg.Archive_format)
-						if err != nil {
-							logger.Log.Errorf("Error calc hash for file: %v", err)
-						}
-						if Cfg.Cache {
-							err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, d.ArtifactId+"."+Cfg.Archive_format, saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+d.ArtifactId+"."+Cfg.Archive_format)
-							if err != nil {
-								logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-							}
-							err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, d.ArtifactId+"."+Cfg.Archive_format+".gost", saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+d.ArtifactId+"."+Cfg.Archive_format+".gost")
-							if err != nil {
-								logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-							}
-						}
-					} else {
-						logger.Log.Warnf("Not found version of dependency %s:%s:%s in gitlab", d.GroupId, d.ArtifactId, d.Version)
-						MapMutex.Lock()
-						Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-						Unknown_sx_deps_ver[svcName] = append(Unknown_sx_deps_ver[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-						MapMutex.Unlock()
-					}
-
-				} else {
-					logger.Log.Warnf("Service %s:%s:%s not found in gitlab", d.GroupId, d.ArtifactId, d.Version)
-					MapMutex.Lock()
-					Unknown_sx_deps[svcName] = append(Unknown_sx_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					MapMutex.Unlock()
-				}
-				dwg.Done()
-				return nil
-
-			} else if d.GroupId == "rtl.pgs" {
-				logger.Log.Tracef("Found rtl dependecy")
-				if ProjectsRtlDepsMap[d.ArtifactId] != nil {
-					logger.Log.Tracef("Found dependency in gitlab, project: %v", ProjectsRtlDepsMap[d.ArtifactId])
-					tags := gitlab_helper.GetProjectTag(GitClient, ProjectsRtlDepsMap[d.ArtifactId].ID)
-					tagf := false
-					ver := ""
-					for _, t := range tags {
-						if t.Name == "v"+d.Version {
-							tagf = true
-							ver = "v" + d.Version
-						} else if t.Name == d.Version {
-							tagf = true
-							ver = d.Version
-						}
-					}
-					if tagf {
-						logger.Log.Tracef("Trying to download service %s:%s:%s from gitlab", d.GroupId, d.ArtifactId, d.Version)
-						err := os.WriteFile(saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+d.ArtifactId+"."+Cfg.Archive_format,
-							gitlab_helper.GetProjectArchive(GitClient, ProjectsRtlDepsMap[d.ArtifactId].ID, &Cfg.Archive_format, &ver), 0644)
-						if err != nil {
-							logger.Log.Fatalf("Error writing archive file: %v", err)
-						}
-						err = hashFile(saveto + "/" + d.GroupId + "/" + d.ArtifactId + "/" + d.Version + "/" + d.ArtifactId + "." + Cfg.Archive_format)
-						if err != nil {
-							logger.Log.Errorf("Error calc hash for file: %v", err)
-						}
-						if Cfg.Cache {
-							err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, d.ArtifactId+"."+Cfg.Archive_format, saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+d.ArtifactId+"."+Cfg.Archive_format)
-							if err != nil {
-								logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-							}
-							err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, d.ArtifactId+"."+Cfg.Archive_format+".gost", saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+d.ArtifactId+"."+Cfg.Archive_format+".gost")
-							if err != nil {
-								logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-							}
-						}
-					} else {
-						logger.Log.Warnf("Not found version of dependency %s:%s:%s in gitlab", d.GroupId, d.ArtifactId, d.Version)
-						MapMutex.Lock()
-						Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-						Unknown_sx_deps_ver[svcName] = append(Unknown_sx_deps_ver[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-						MapMutex.Unlock()
-					}
-
-				} else {
-					logger.Log.Warnf("Service %s:%s:%s not found in gitlab", d.GroupId, d.ArtifactId, d.Version)
-					MapMutex.Lock()
-					Unknown_sx_deps[svcName] = append(Unknown_sx_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					MapMutex.Unlock()
-				}
-				dwg.Done()
-				return nil
-			}
-			url := Cfg.MavenUrl + "/" + strings.Replace(d.GroupId, ".", "/", -1) + "/" + d.ArtifactId + "/" + d.Version
-			logger.Log.Debugf("URL for deps download: %v", url)
-			req, err := http.NewRequest(http.MethodGet, url, nil)
-			if err != nil {
-				logger.Log.Errorf("Could not create http request: %v", err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				logger.Log.Errorf("Error get maven dependency index html, error %v", err)
-				MapMutex.Lock()
-				Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-				MapMutex.Unlock()
-			}
-			defer resp.Body.Close()
-			var l []string
-			if resp.StatusCode == 404 {
-				logger.Log.Debugf("Error get maven dependency index html, dependency %v. Will try Plugins repo", d)
-				url = Cfg.PluginsUrl + "/" + strings.Replace(d.GroupId, ".", "/", -1) + "/" + d.ArtifactId + "/" + d.Version
-				req, err := http.NewRequest(http.MethodGet, url, nil)
-				if err != nil {
-					logger.Log.Errorf("Could not create http request: %v", err)
-				}
-				resp, err := client.Do(req)
-				if resp.StatusCode == 404 || resp.StatusCode != 200 {
-					logger.Log.Debugf("Error get maven dependency index html, unknown dependency %v url %v.", d, url)
-					MapMutex.Lock()
-					Unknown_deps[svcName] = append(Unknown_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					MapMutex.Unlock()
-				} else {
-					l = ParseLinks(resp.Body)
-					if l == nil {
-						logger.Log.Errorf("Error parsing maven dependency links on page %s", url)
-						MapMutex.Lock()
-						Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-						MapMutex.Unlock()
-						os.Exit(1)
-					}
-					logger.Log.Tracef("Parsed links: %v", l)
-				}
-				if err != nil {
-					logger.Log.Errorf("Error get maven dependency index html, error %v", err)
-					MapMutex.Lock()
-					Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					MapMutex.Unlock()
-				}
-				defer resp.Body.Close()
-
-			} else if resp.StatusCode == 200 {
-				l = ParseLinks(resp.Body)
-				if l == nil {
-					logger.Log.Errorf("Error parsing maven dependency links on page %s", url)
-					MapMutex.Lock()
-					Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-					MapMutex.Unlock()
-					os.Exit(1)
-				}
-				logger.Log.Tracef("Parsed links: %v", l)
+		workers <- struct{}{}
+		go func(d ProjectXml) {
+			defer dwg.Done()
+			defer func() { <-workers }()
+			var err error
+			if m := sourceFor(d.GroupId); m != nil {
+				err = downloadFromSource(ctx, m, d, saveto, svcName)
 			} else {
-				logger.Log.Errorf("Error get maven dependecy, http request failed, error %v", err)
-				MapMutex.Lock()
-				Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-				MapMutex.Unlock()
-			}
-
-			j := false
-			s := false
-			for _, v := range l {
-				if v != "../" {
-					if strings.Contains(v, ".jar") && !strings.Contains(v, "javadoc.jar") && !strings.Contains(v, ".jar.") {
-						j = true
-					}
-					if j && strings.Contains(v, "sources") {
-						s = true
-					}
-
-					logger.Log.Tracef("Downloading : %v url: %s", d, url+"/"+v)
-					req, err := grab.NewRequest(saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version, url+"/"+v)
-					if err != nil {
-						logger.Log.Fatalf("Could not create new request: %v", err)
-					}
-					resp := grab_client.Do(req)
-					err = resp.Err()
-					//resp, err := grab.Get(saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version, url+"/"+v)
-					if err != nil {
-						r := 5 + rand.Intn(10)
-						logger.Log.Debugf("Unable to download dependency file, wait %ds and retry. Service: %s, dependecy: %s", r, svcName, d.GroupId+":"+d.ArtifactId+":"+d.Version)
-						time.Sleep(time.Duration(r) * time.Second)
-						req, err := grab.NewRequest(saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version, url+"/"+v)
-						if err != nil {
-							logger.Log.Fatalf("Could not create new request: %v", err)
-						}
-						resp := grab_client.Do(req)
-						err = resp.Err()
-						//resp, err = grab.Get(saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version, url+"/"+v)
-						if err != nil {
-							logger.Log.Errorf("Unable to download dependency file: %s : %v", d, err)
-							MapMutex.Lock()
-							Unknown_sx_deps[svcName] = append(Unknown_sx_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-							Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-							MapMutex.Unlock()
-							return err
-						}
-						if Cfg.Cache {
-							err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, v, saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+v)
-							if err != nil {
-								logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-							}
-						}
-					}
-					if strings.Contains(v, ".jar") || strings.Contains(v, ".pom") {
-						if !strings.Contains(v, ".jar.") && !strings.Contains(v, ".pom.") {
-							err := hashFile(saveto + "/" + d.GroupId + "/" + d.ArtifactId + "/" + d.Version + "/" + v)
-							if err != nil {
-								logger.Log.Errorf("Error calc hash for file: %v", err)
-							}
-							if Cfg.Cache {
-								err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, v+".gost", saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+v+".gost")
-								if err != nil {
-									logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-								}
-							}
-						}
-					}
-					if Cfg.Cache {
-						err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, v, saveto+"/"+d.GroupId+"/"+d.ArtifactId+"/"+d.Version+"/"+v)
-						if err != nil {
-							logger.Log.Fatalf("Could not save %s:%s:%s file to cache dir: %v", d.GroupId, d.ArtifactId, d.Version, err)
-						}
-					}
-					if resp.HTTPResponse.StatusCode == 404 {
-						logger.Log.Errorf("Unable to download dependency %s, maybe broken link", url)
-
-					}
-					if resp.IsComplete() {
-						logger.Log.Tracef("Downloaded dependency %s with responce code: %v, ContentLength: %d bytes", d, resp.HTTPResponse.StatusCode, resp.HTTPResponse.ContentLength)
-					}
+				err = downloadFromMaven(ctx, mvn, d, saveto, svcName)
+				if err != nil && ctx.Err() == nil {
+					logger.Log.Errorf("Unable to download dependency %s: %v", d.Key(), err)
 				}
 			}
-			if !s && j {
-				logger.Log.Tracef("Sources not found for dependency %s", d.GroupId+":"+d.ArtifactId+":"+d.Version)
-				MapMutex.Lock()
-				Without_src_deps[svcName] = append(Without_src_deps[svcName], d.GroupId+":"+d.ArtifactId+":"+d.Version)
-				MapMutex.Unlock()
+			// Зависимость с несовпадающей контрольной суммой уже исключена и учтена в отчете
+			if err != nil && ctx.Err() == nil && !errors.Is(err, maven.ErrChecksumMismatch) {
+				fail(fmt.Errorf("dependency %s: %w", d.Key(), err))
 			}
-			dwg.Done()
-			return err
-		}(i, d)
+		}(d)
 	}
+	// Начатые загрузки дожидаются и при ошибке, чтобы они не писали в каталог сервиса после возврата
 	dwg.Wait()
-	return nil
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	reportClassifiers(deps, saveto, svcName)
+	return errors.Join(errs...)
+}
+
+// buildRunOptions Формирует параметры запуска сборки сервиса в образе сборщика.
+// Для gradle кеш зависимостей собирается в gradle_cache, для maven локальный репозиторий собирается в maven_cache.
+// Если задан initScript, он монтируется в контейнер и передается gradle ключом -I.
+func buildRunOptions(tool BuildTool, dir string, plan *BuildPlan, initScript string) container.RunOptions {
+	opts := container.RunOptions{
+		Image: plan.Image.String(),
+		User:  "1000",
+	}
+	if tool == Maven {
+		opts.WorkDir = "/build"
+		opts.Mounts = []container.Mount{{Source: dir, Target: "/build"}}
+		opts.Cmd = []string{"bash", "-c", plan.Command(" -Dmaven.repo.local=/build/" + tool.CacheDir() + " -q")}
+		return opts
+	}
+	opts.WorkDir = "/home/gradle"
+	opts.Env = []string{"GRADLE_USER_HOME=" + tool.CacheDir()}
+	opts.Mounts = []container.Mount{{Source: dir, Target: "/home/gradle"}}
+	flags := " -g " + tool.CacheDir() + " -q"
+	if initScript != "" {
+		opts.Mounts = append(opts.Mounts, container.Mount{Source: initScript, Target: "/tmp/init.gradle", ReadOnly: true})
+		flags = " -g " + tool.CacheDir() + " -I /tmp/init.gradle -q"
+	}
+	opts.Cmd = []string{"bash", "-c", plan.Command(flags)}
+	return opts
+}
+
+// runBuild Запускает сборку в контейнере, вывод сборки сохраняется в outb и errb
+func runBuild(ctx context.Context, opts container.RunOptions, outb *bytes.Buffer, errb *bytes.Buffer) error {
+	opts.Stdout = outb
+	opts.Stderr = errb
+	logger.Log.Debugf("Build command %v in image %s, mounts %v", opts.Cmd, opts.Image, opts.Mounts)
+	return Runtime.Run(ctx, opts)
 }
 
 // Функция для упрощения создания конечного архива файлов.
 // На вход принимает путь вида /tmp/folder, на выходе создаст архив /tmp/folder.tgz и удалит папку.
-func packFolder(path string) error {
+// При ошибке или отмене ctx неполный архив удаляется, папка остается. Уже упакованная папка пропускается.
+func packFolder(ctx context.Context, path string) error {
+	if !fileExists(path) && fileExists(path+"."+Cfg.Archive_format) {
+		logger.Log.Debugf("Folder %s already packed", path)
+		return nil
+	}
 	fdest_src, err := os.Create(path + "." + Cfg.Archive_format)
 	if err != nil {
 		return errors.New("Error creating final archive file")
 	}
-	defer fdest_src.Close()
-	err = CreateTgz(path, fdest_src)
+	err = CreateTgz(ctx, path, fdest_src)
+	fdest_src.Close()
 	if err != nil {
+		os.Remove(path + "." + Cfg.Archive_format)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return errors.New("Error creating dependencies sources archive file")
 	}
 	err = os.RemoveAll(path)
 	if err != nil {
 		return errors.New("Could not delete folder")
 	}
-	err = hashFile(path + "." + Cfg.Archive_format)
+	return nil
+
+}
+
+// buildModule Собирает модуль сервиса в docker образе сборщика, выгружает образ и копирует конфиги gradle.
+// Для сервисов из нескольких модулей конфиги gradle раскладываются по подкаталогам с именем модуля.
+func buildModule(ctx context.Context, svcName string, svcPath string, m *Module, multi bool) error {
+	dockerfile := m.Dockerfile
+	tool := m.Tool
+	logger.Log.Debugf("Build tool for service %s, module %s: %s", svcName, m.Name, tool)
+	configsDir := Cfg.Output_dir + "/" + svcName + "/gradle_configs"
+	if multi {
+		configsDir = configsDir + "/" + m.Name
+	}
+	if tool == Gradle {
+		if _, err := os.Stat(configsDir); os.IsNotExist(err) {
+			err := os.MkdirAll(configsDir, 0744)
+			if err != nil {
+				logger.Log.Errorf("Error creating gradle_configs dir: %v", err)
+				return err
+			}
+		}
+	}
+	plan, err := ParseDockerfile(dockerfile, tool, Cfg.StagePattern(svcPath))
 	if err != nil {
-		return errors.New("Could not calc hash for file")
+		logger.Log.Errorf("Unable to build service: %s", err)
+		return err
+	}
+	image := plan.Image.String()
+	m.Image = image
+	m.Stage = plan.Stage
+	logger.Log.Debugf("Builder image %s, %s version %s", image, tool, plan.Version)
+	buildScript := gradleScript(path.Dir(dockerfile), "build.gradle")
+	settingsScript := gradleScript(path.Dir(dockerfile), "settings.gradle")
+	ex, err := os.Executable()
+	if err != nil {
+		logger.Log.Errorf("Unable to get current executable path: %v", err)
+		return err
+	}
+	initScript := Cfg.Output_dir + "/" + svcName + "/gradle_configs/init.gradle"
+	if tool == Gradle && Cfg.NexusForceAddToGradle == true {
+		err = nexus.CreateInitScript(initScript)
+		if err != nil {
+			logger.Log.Errorf("Unable to create gradle init script for service %s: %s", svcName, err)
+			return err
+		}
+		m.InitScript = true
+	}
+	err = container.EnsureImage(ctx, Runtime, image)
+	if err != nil {
+		logger.Log.Errorf("Unable to pull builder image %s: %v", image, err)
+		return err
+	}
+	logger.Log.Debugf("Run %s build", tool)
+	opts := buildRunOptions(tool, filepath.Dir(ex)+"/"+filepath.Dir(dockerfile), plan, "")
+	if m.InitScript {
+		opts = buildRunOptions(tool, filepath.Dir(ex)+"/"+filepath.Dir(dockerfile), plan, filepath.Dir(ex)+"/"+initScript)
+	}
+	var outb, errb bytes.Buffer
+	if err := runBuild(ctx, opts, &outb, &errb); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if tool == Gradle && Cfg.NexusAutoAddToGradle && !m.InitScript {
+			logger.Log.Debugf("Error run docker build for: %s, try to add local nexus repo with init script", svcName)
+			err = nexus.CreateInitScript(initScript)
+			if err != nil {
+				logger.Log.Errorf("Unable to create gradle init script for service %s: %s", svcName, err)
+				return err
+			}
+			m.InitScript = true
+			logger.Log.Tracef("Run docker build again for service: %s ", svcName)
+			var outb2, errb2 bytes.Buffer
+			if err := runBuild(ctx, buildRunOptions(tool, filepath.Dir(ex)+"/"+filepath.Dir(dockerfile), plan, filepath.Dir(ex)+"/"+initScript), &outb2, &errb2); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logger.Log.Errorf("Error run docker build, second run, %s: %v, Build stdout: %s, stderr: %s", svcName, err, outb2.String(), errb2.String())
+				return errors.New("Build command retry unsuccessfull")
+			} else {
+
+				logger.Log.Tracef("Build stdout: %s, stderr: %s", outb2.String(), errb2.String())
+			}
+		} else {
+			logger.Log.Errorf("Error run docker build %s: %v, Build stdout: %s, stderr: %s", svcName, err, outb.String(), errb.String())
+			return errors.New("Build command unsuccessfull")
+		}
+	} else {
+		logger.Log.Tracef("Build stdout: %s, stderr: %s", outb.String(), errb.String())
+	}
+	if tool == Gradle {
+		graphInit := ""
+		if m.InitScript {
+			graphInit = filepath.Dir(ex) + "/" + initScript
+		}
+		m.Graph, err = resolveGraph(ctx, svcName, m, plan, filepath.Dir(ex)+"/"+filepath.Dir(dockerfile), graphInit)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.Log.Warnf("Unable to resolve dependency graph for service %s, module %s, dependencies will be found in gradle cache: %v", svcName, m.Name, err)
+			m.Graph = nil
+		}
+		if m.Graph != nil && len(m.Graph.Unresolved()) > 0 {
+			logger.Log.Warnf("Dependency graph of service %s, module %s has unresolved dependencies, dependencies will be found in gradle cache: %s", svcName, m.Name, strings.Join(m.Graph.Unresolved(), "; "))
+		}
+	}
+	if tool == Gradle {
+		logger.Log.Debugf("Copy gradle configs for service %s, module %s", svcName, m.Name)
+		for _, f := range []string{buildScript, settingsScript} {
+			if f == "" {
+				continue
+			}
+			err = cp.Copy(f, configsDir+"/"+filepath.Base(f))
+			if err != nil {
+				logger.Log.Errorf("Error copying files gradle configs: %v", err)
+				return err
+			}
+		}
 	}
 	return nil
+}
 
+// ProcessService Собирает сервис из архива исходных кодов svcArchive и формирует итоговый архив.
+// Обработка начинается с первой стадии, не завершенной по журналу, завершение каждой стадии записывается в журнал.
+// При ошибке или отмене ctx результаты завершенных стадий сохраняются для продолжения обработки с ключом -resume,
+// при отмене ctx сервис отмечается в отчете как прерванный.
+func ProcessService(ctx context.Context, svcArchive string, svc *gitlab.Project) (err error) {
+	svcName := strings.TrimSpace(svc.Name)
+	journalMutex.Lock()
+	st := journal.Services[strings.TrimSpace(svc.Path)]
+	if st == nil {
+		st = &ServiceState{}
+	}
+	var done []string
+	for _, r := range st.Stages {
+		done = append(done, r.Stage)
+	}
+	modules := slices.Clone(st.Modules)
+	journalMutex.Unlock()
+	stage := StageExtract
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			Interrupt(svc, stage)
+			err = ctx.Err()
+			return
+		}
+		if err != nil {
+			err = &StageError{Stage: stage, Err: err}
+		}
+	}()
+	if len(modules) > 0 {
+		// Модули есть в журнале только после завершения сборки, графы зависимостей читаются из ее результатов
+		stage = StageBuild
+		err = loadGraphs(svcName, modules)
+		if err != nil {
+			return err
+		}
+	}
+	for _, stage = range Stages {
+		if slices.Contains(done, stage) {
+			continue
+		}
+		logger.Log.Debugf("Service %s, stage %s", svcName, stage)
+		switch stage {
+		case StageFetch:
+			err = errors.New("Sources archive of service " + svcName + " was not fetched")
+		case StageExtract:
+			err = extractService(svcArchive, svcName)
+		case StageBuild:
+			modules, err = buildService(ctx, svc)
+		case StageImage:
+			err = exportImages(ctx, svcName, modules)
+		case StageDependencies:
+			err = collectDependencies(ctx, svcName, modules)
+		case StagePack:
+			err = packService(ctx, svc, modules)
+		case StageVerify:
+			err = verifyService(ctx, svc, modules)
+		case StageBundle:
+			err = packFolder(ctx, Cfg.Output_dir+"/"+svcName)
+			if err != nil {
+				logger.Log.Errorf("Error processing folder %s : %v", Cfg.Output_dir+"/"+svcName, err)
+			}
+		case StageHash:
+			err = hashFile(Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format)
+			if err != nil {
+				logger.Log.Errorf("Could not calc hash for file %s: %v", Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format, err)
+			}
+		case StageUpload:
+			err = uploadService(ctx, svcName)
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		err = CompleteStage(svc, stage, modules)
+		if err != nil {
+			logger.Log.Errorf("Unable to save journal: %v", err)
+			return err
+		}
+	}
+	return nil
 }
 
-func ProcessService(svcArchive string, svc *gitlab.Project) error {
+// extractService Распаковывает архив исходных кодов сервиса в каталог сервиса
+func extractService(svcArchive string, svcName string) error {
 	if _, err := os.Stat(svcArchive); os.IsNotExist(err) {
-		log.Fatalf("Unable to build service, archive not fount: %v", err)
+		logger.Log.Errorf("Unable to build service, archive not found: %v", err)
+		return err
 	}
-	svcName := strings.TrimSpace(svc.Name)
 	logger.Log.Debugf("Start builder")
 	logger.Log.Debugf("Trying to open archive %s", svcArchive)
 	r, err := os.Open(svcArchive)
@@ -794,95 +1137,75 @@ func ProcessService(svcArchive string, svc *gitlab.Project) error {
 		logger.Log.Errorf("Error extracting archive %s %v", svcArchive, err)
 		return err
 	}
+	return nil
+}
+
+// buildService Находит модули сервиса по дескрипторам сборки и собирает их
+func buildService(ctx context.Context, svc *gitlab.Project) ([]Module, error) {
+	svcName := strings.TrimSpace(svc.Name)
 	if _, err := os.Stat(Cfg.Output_dir + "/" + svcName + "/docker_images"); os.IsNotExist(err) {
 		err := os.Mkdir(Cfg.Output_dir+"/"+svcName+"/docker_images", 0744)
 		if err != nil {
-			logger.Log.Fatalf("Error creating docker_images dir: %v", err)
+			logger.Log.Errorf("Error creating docker_images dir: %v", err)
+			return nil, err
 		}
 	}
-	if _, err := os.Stat(Cfg.Output_dir + "/" + svcName + "/gradle_configs"); os.IsNotExist(err) {
-		err := os.Mkdir(Cfg.Output_dir+"/"+svcName+"/gradle_configs", 0744)
-		if err != nil {
-			logger.Log.Fatalf("Error creating gradle_configs dir: %v", err)
-		}
+	dockerfiles, err := findDockerfiles(Cfg.Output_dir+"/"+svcName, Cfg.Descriptors(strings.TrimSpace(svc.Path)))
+	if err != nil {
+		logger.Log.Errorf("Unable to build service %s: %v", svcName, err)
+		return nil, err
 	}
-	dockerfile := findDockerfile(Cfg.Output_dir + "/" + svcName)
-	if _, err := os.Stat(path.Dir(dockerfile) + "/build.gradle"); os.IsNotExist(err) {
-		logger.Log.Errorf("Unable to find build.gradle file, maybe not gradle service, skipping: %v", err)
-		err = os.RemoveAll(Cfg.Output_dir + "/" + svcName)
+	var modules []Module
+	for _, dockerfile := range dockerfiles {
+		tool, err := detectBuildTool(path.Dir(dockerfile))
 		if err != nil {
-			logger.Log.Fatalf("Could not delete temp folder %s, error: %v", Cfg.Output_dir+"/"+svcName, err)
+			logger.Log.Warnf("Unable to determine build tool for %s, maybe not gradle or maven module, skipping: %v", dockerfile, err)
+			continue
 		}
-		err = os.RemoveAll(Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format)
-		if err != nil {
-			logger.Log.Fatalf("Could not delete temp folder %s, error: %v", Cfg.Output_dir+"/"+svcName, err)
-		}
-		return err
+		modules = append(modules, Module{Name: moduleName(Cfg.Output_dir+"/"+svcName, dockerfile), Dockerfile: dockerfile, Descriptor: filepath.Base(dockerfile), Tool: tool})
 	}
-	image, command, version, err := ParseDockerfile(dockerfile)
-	if err != nil {
-		logger.Log.Errorf("Unable to build service: %s", err)
-		return err
+	if len(modules) == 0 {
+		logger.Log.Errorf("Unable to find gradle or maven module in service %s, skipping", svcName)
+		return nil, errors.New("Unable to find build.gradle(.kts) or pom.xml file for any build descriptor")
 	}
-	logger.Log.Tracef("Start parsing build.gradle")
-	if Cfg.NexusForceAddToGradle == true {
-		err = nexus.AddNexusToBuildGradle(path.Dir(dockerfile)+"/build.gradle", version)
+	multi := len(modules) > 1
+	for i := range modules {
+		m := &modules[i]
+		err = buildModule(ctx, svcName, strings.TrimSpace(svc.Path), m, multi)
 		if err != nil {
-			logger.Log.Errorf("Unable to parse build.gradle service: %s", err)
-			return err
+			logger.Log.Errorf("Unable to build module %s of service %s: %v", m.Name, svcName, err)
+			return nil, err
 		}
-		err = nexus.AddNexusToSettingsGradle(path.Dir(dockerfile)+"/settings.gradle", version)
-		if err != nil {
-			logger.Log.Errorf("Unable to parse settings.gradle service: %s", err)
-			return err
+		if Cfg.ReproducibilityCheck {
+			err = captureArtifacts(filepath.Dir(m.Dockerfile), m.Tool, artifactsDir(svcName, "online", m.Name))
+			if err != nil {
+				logger.Log.Errorf("Unable to save build artifacts of module %s of service %s: %v", m.Name, svcName, err)
+				return nil, err
+			}
 		}
 	}
-	logger.Log.Debugf("Run gradle build")
-	ex, err := os.Executable()
+	MapMutex.Lock()
+	Build_descriptors[svcName] = nil
+	for _, m := range modules {
+		source := "cache scan"
+		if graphDeps(m) {
+			source = "dependency graph"
+		} else if m.Graph != nil {
+			source = fmt.Sprintf("cache scan (dependency graph has %d unresolved dependencies)", len(m.Graph.Unresolved()))
+		}
+		Build_descriptors[svcName] = append(Build_descriptors[svcName], m.Name+": "+m.Descriptor+", stage "+m.Stage+", deps "+source)
+	}
+	MapMutex.Unlock()
+	err = saveGraphs(svcName, modules)
 	if err != nil {
-		logger.Log.Panicf("Unable to get current executable path, terminating. %v", err)
-		return err
+		logger.Log.Errorf("Error saving dependency graph for service %s: %v", svcName, err)
+		return nil, err
 	}
-	cmd := exec.Command("bash", "-c", "docker run --user 1000 -v \""+filepath.Dir(ex)+"/"+filepath.Dir(dockerfile)+"\":/home/gradle "+image+" bash -c \"export GRADLE_USER_HOME=gradle_cache && "+command+" -g gradle_cache -q \"")
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-	logger.Log.Debugf("Build command %v", cmd)
-	if err := cmd.Run(); err != nil {
-		if Cfg.NexusAutoAddToGradle {
-			logger.Log.Debugf("Error run docker build for: %s, try to add local nexus repo", svc.Name)
-			err = nexus.AddNexusToBuildGradle(path.Dir(dockerfile)+"/build.gradle", version)
-			if err != nil {
-				logger.Log.Errorf("Unable to parse build.gradle service: %s", err)
-				return err
-			}
-			if strings.Contains(strings.ToLower(errb.String()), "gradle core plugins") {
-				err = nexus.AddNexusToSettingsGradle(path.Dir(dockerfile)+"/settings.gradle", version)
-				if err != nil {
-					logger.Log.Errorf("Unable to parse build.gradle service: %s", err)
-					return err
-				}
-			}
-			logger.Log.Tracef("Run docker build again for service: %s ", svc.Name)
-			cmd2 := exec.Command("bash", "-c", "docker run --user 1000 -v \""+filepath.Dir(ex)+"/"+filepath.Dir(dockerfile)+"\":/home/gradle "+image+" bash -c \"export GRADLE_USER_HOME=gradle_cache && "+command+" -g gradle_cache -q \"")
-			var outb2, errb2 bytes.Buffer
-			cmd2.Stdout = &outb2
-			cmd2.Stderr = &errb2
-			if err := cmd2.Run(); err != nil {
-				logger.Log.Errorf("Error run docker build, second run, %s: %v, Build stdout: %s, stderr: %s", svc.Name, err, outb2.String(), errb2.String())
-				return errors.New("Build command retry unsuccessfull")
-			} else {
+	return modules, nil
+}
 
-				logger.Log.Tracef("Build stdout: %s, stderr: %s", outb2.String(), errb2.String())
-			}
-		} else {
-			logger.Log.Errorf("Error run docker build %s: %v, Build stdout: %s, stderr: %s", svc.Name, err, outb.String(), errb.String())
-			return errors.New("Build command unsuccessfull")
-		}
-	} else {
-		logger.Log.Tracef("Build stdout: %s, stderr: %s", outb.String(), errb.String())
-	}
-	var outb3, errb3 bytes.Buffer
+// exportImages Выгружает образы сборщика модулей в каталог docker_images сервиса, уже выгруженные образы пропускаются
+func exportImages(ctx context.Context, svcName string, modules []Module) error {
 	rp := strings.NewReplacer(
 		"-", "_",
 		" ", "_",
@@ -891,114 +1214,292 @@ func ProcessService(svcArchive string, svc *gitlab.Project) error {
 		".", "_",
 		"/", "_",
 	)
-	image_f := rp.Replace(image)
-	cmd3 := exec.Command("bash", "-c", "docker image save "+image+" -o \""+Cfg.Output_dir+"/"+svcName+"/docker_images/"+image_f+".tar\"")
-	cmd3.Stdout = &outb3
-	cmd3.Stderr = &errb3
-	logger.Log.Tracef("Save docker image %s to %s", image, Cfg.Output_dir+"/"+svcName+"/docker_images/"+image_f+".tar")
-	if err := cmd3.Run(); err != nil {
-		logger.Log.Errorf("Error saving docker image %s, stdout: %s, stderr: %s", image, outb3.String(), errb3.String())
-		return errors.New("Build command unsuccessfull")
-	}
-	logger.Log.Debugf("Copy gradle configs for service %s", svcName)
-	err = cp.Copy(filepath.Dir(dockerfile)+"/build.gradle", Cfg.Output_dir+"/"+svcName+"/gradle_configs/build.gradle")
-	if err != nil {
-		logger.Log.Fatalf("Error copying files gradle configs: %v", err)
-	}
-	err = cp.Copy(filepath.Dir(dockerfile)+"/settings.gradle", Cfg.Output_dir+"/"+svcName+"/gradle_configs/settings.gradle")
-	if err != nil {
-		logger.Log.Fatalf("Error copying files gradle configs: %v", err)
+	for _, m := range modules {
+		image := m.Image
+		image_f := rp.Replace(image)
+		if _, err := os.Stat(Cfg.Output_dir + "/" + svcName + "/docker_images/" + image_f + ".tar"); err == nil {
+			logger.Log.Debugf("Docker image %s already saved for service %s", image, svcName)
+			continue
+		}
+		err := container.EnsureImage(ctx, Runtime, image)
+		if err != nil {
+			logger.Log.Errorf("Unable to pull builder image %s: %v", image, err)
+			return err
+		}
+		logger.Log.Tracef("Save docker image %s to %s", image, Cfg.Output_dir+"/"+svcName+"/docker_images/"+image_f+".tar")
+		if err := Runtime.Save(ctx, image, Cfg.Output_dir+"/"+svcName+"/docker_images/"+image_f+".tar"); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Log.Errorf("Error saving docker image %s: %v", image, err)
+			return errors.New("Build command unsuccessfull")
+		}
 	}
-	logger.Log.Debugf("Find dependencies for service %s", svcName)
-	deps := GetDependencies(filepath.Dir(dockerfile) + "/gradle_cache/caches/modules-2/files-2.1/")
-	if deps == nil {
-		logger.Log.Errorf("Unable to find any dependencies for service %s", svcName)
+	return nil
+}
+
+// collectDependencies Находит зависимости модулей по графу зависимостей или в кеше сборки,
+// скачивает их исходные коды и сохраняет графы зависимостей
+func collectDependencies(ctx context.Context, svcName string, modules []Module) error {
+	depsModules := make(map[string][]string)
+	var deps []ProjectXml
+	for _, m := range modules {
+		logger.Log.Debugf("Find dependencies for service %s, module %s", svcName, m.Name)
+		var mdeps []ProjectXml
+		if graphDeps(m) {
+			// Артефакты с классификатором и наличие исходников берутся из метаданных в кеше
+			cached := make(map[string]ProjectXml)
+			for _, d := range GetDependencies(filepath.Dir(m.Dockerfile)+"/"+m.Tool.DepsLookupDir(), m.Tool) {
+				cached[d.Key()] = d
+			}
+			for _, c := range m.Graph.Modules() {
+				d := ProjectXml{GroupId: c.Group, ArtifactId: c.Module, Version: c.Version}
+				d.Classifiers = cached[d.Key()].Classifiers
+				d.Sources = cached[d.Key()].Sources
+				d.Checksums = cached[d.Key()].Checksums
+				mdeps = append(mdeps, d)
+			}
+		} else {
+			mdeps = GetDependencies(filepath.Dir(m.Dockerfile)+"/"+m.Tool.DepsLookupDir(), m.Tool)
+		}
+		if mdeps == nil {
+			logger.Log.Errorf("Unable to find any dependencies for service %s, module %s", svcName, m.Name)
+		}
+		for j, dep := range mdeps {
+			logger.Log.Tracef("Found %d dep: %v", j, dep)
+			d := dep.GroupId + ":" + dep.ArtifactId + ":" + dep.Version
+			if _, ok := depsModules[d]; !ok {
+				deps = append(deps, dep)
+			}
+			if !slices.Contains(depsModules[d], m.Name) {
+				depsModules[d] = append(depsModules[d], m.Name)
+			}
+		}
 	}
-	for i, dep := range deps {
-		logger.Log.Tracef("Found %d dep: %s", i, dep)
+	if len(modules) > 1 {
+		MapMutex.Lock()
+		Modules_deps[svcName] = depsModules
+		MapMutex.Unlock()
 	}
 	logger.Log.Debugf("Trying to download dependencies for service %s", svcName)
-	err = DownloadDeps(deps, Cfg.Output_dir+"/"+svcName+"/deps_sources", svcName)
+	err := DownloadDeps(ctx, deps, Cfg.Output_dir+"/"+svcName+"/deps_sources", svcName)
 	if err != nil {
 		logger.Log.Errorf("Error downloading dependencies : %v", err)
 		return err
 	}
-	s, err := PrepareFinalDir(svc)
+	return nil
+}
+
+// packService Переносит кеши сборки в каталог сервиса, создает README и упаковывает вложенные каталоги
+func packService(ctx context.Context, svc *gitlab.Project, modules []Module) error {
+	svcName := strings.TrimSpace(svc.Name)
+	s, err := PrepareFinalDir(svc, modules)
 	if err != nil {
 		logger.Log.Errorf("Error processing final directory : %v", err)
 		return err
 	}
 	logger.Log.Tracef("Finali %v", s)
-	err = CreateReadme(svcName, svc.Path, s, Cfg.ReadmeTemplate)
+	err = CreateReadmes(svcName, svc.HTTPURLToRepo, s, modules)
 	if err != nil {
 		logger.Log.Errorf("Error creating Readme.md file: %v", err)
 	}
+	err = writeManifest(svcName)
+	if err != nil {
+		logger.Log.Errorf("Error writing provenance manifest of service %s: %v", svcName, err)
+		return err
+	}
 	// Создаем tgz для подпапок
-	folders := []string{"deps_sources", "docker_images", "gradle_dependencies", "gradle_configs"}
+	folders := []string{"deps_sources", "docker_images"}
+	for _, m := range modules {
+		if !slices.Contains(folders, m.Tool.DepsDir()) {
+			folders = append(folders, m.Tool.DepsDir())
+		}
+		if m.Tool == Gradle && !slices.Contains(folders, "gradle_configs") {
+			folders = append(folders, "gradle_configs")
+		}
+	}
 	for _, v := range folders {
-		err = packFolder(Cfg.Output_dir + "/" + svcName + "/" + v)
+		err = packFolder(ctx, Cfg.Output_dir+"/"+svcName+"/"+v)
+		if err == nil {
+			err = hashFile(Cfg.Output_dir + "/" + svcName + "/" + v + "." + Cfg.Archive_format)
+		}
+		if err != nil && ctx.Err() != nil {
+			return err
+		}
 		if err != nil {
 			logger.Log.Errorf("Error processing folder %s : %v", v, err)
 		}
 	}
-	err = packFolder(Cfg.Output_dir + "/" + svcName)
-	if err != nil {
-		logger.Log.Errorf("Error processing folder %s : %v", Cfg.Output_dir+"/"+svcName, err)
-	}
-	if Cfg.UploadToNexus {
-		logger.Log.Infof("Uploading to nexus %s", Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format)
-		err := nexus.UploadNexus(Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format, svcName+"."+Cfg.Archive_format)
-		if err != nil {
-			logger.Log.Fatalf("Error uploading to Nexus: %v", err)
-		}
-		err = nexus.UploadNexus(Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format+".gost", svcName+"."+Cfg.Archive_format+".gost")
+	return nil
+}
+
+// verifyService Проверяет оффлайн сборку и воспроизводимость сборки сервиса, если проверки включены
+func verifyService(ctx context.Context, svc *gitlab.Project, modules []Module) error {
+	svcName := strings.TrimSpace(svc.Name)
+	if Cfg.VerifyBuild || Cfg.ReproducibilityCheck {
+		logger.Log.Infof("Verify offline build of service %s", svcName)
+		err := VerifyBuild(ctx, svc, modules)
 		if err != nil {
-			logger.Log.Fatalf("Error uploading to Nexus: %v", err)
+			logger.Log.Errorf("Offline build verification failed for service %s: %v", svcName, err)
+			return err
 		}
-		err = os.RemoveAll(Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format)
+	}
+	if Cfg.ReproducibilityCheck {
+		err := CheckReproducibility(svcName)
 		if err != nil {
-			logger.Log.Fatalf("Could not delete uploaded archive %s, error: %v", Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format, err)
+			logger.Log.Errorf("Reproducibility check failed for service %s: %v", svcName, err)
+			return err
 		}
+	}
+	return nil
+}
 
+// uploadService Загружает итоговый архив сервиса и его хеш в nexus, если загрузка включена
+func uploadService(ctx context.Context, svcName string) error {
+	if !Cfg.UploadToNexus {
+		return nil
+	}
+	logger.Log.Infof("Uploading to nexus %s", Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format)
+	err := nexus.UploadNexus(ctx, Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format, svcName+"."+Cfg.Archive_format)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	if err != nil {
+		logger.Log.Errorf("Error uploading to Nexus: %v", err)
+		return err
+	}
+	err = nexus.UploadNexus(ctx, Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format+".gost", svcName+"."+Cfg.Archive_format+".gost")
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	if err != nil {
+		logger.Log.Errorf("Error uploading to Nexus: %v", err)
+		return err
+	}
+	err = os.RemoveAll(Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format)
+	if err != nil {
+		logger.Log.Errorf("Could not delete uploaded archive %s, error: %v", Cfg.Output_dir+"/"+svcName+"."+Cfg.Archive_format, err)
 	}
 	return nil
 }
 
-func PrepareFinalDir(svc *gitlab.Project) (string, error) {
+func PrepareFinalDir(svc *gitlab.Project, modules []Module) (string, error) {
 	var p string
 	svcName := strings.TrimSpace(svc.Name)
 	svcPath := strings.TrimSpace(svc.Path)
 	logger.Log.Debugf("Prepare final dir fir service: %s, path: %s", svcName, svcPath)
 	if _, err := os.Stat(Cfg.Output_dir + "/" + svcName); os.IsNotExist(err) {
-		logger.Log.Fatalf("Unable to find service dir %v", err)
-	}
-	dockerfile := findDockerfile(Cfg.Output_dir + "/" + svcName)
-	errf := os.Rename(filepath.Dir(dockerfile)+"/gradle_cache", Cfg.Output_dir+"/"+svcName+"/gradle_dependencies")
-	if errf != nil {
-		logger.Log.Fatalf("Unable to move dependencies dir to destination archive %v", errf)
+		logger.Log.Errorf("Unable to find service dir %v", err)
+		return "", err
+	}
+	// Каталог может быть частично подготовлен при прерванной обработке, уже перенесенные файлы пропускаются
+	sources := Cfg.Output_dir + "/" + svcPath + "." + Cfg.Archive_format
+	if fileExists(sources) || !fileExists(Cfg.Output_dir+"/"+svcName+"/"+svcPath+"."+Cfg.Archive_format) {
+		errf := os.Rename(sources, Cfg.Output_dir+"/"+svcName+"/"+svcPath+"."+Cfg.Archive_format)
+		if errf != nil {
+			logger.Log.Errorf("Unable to move sources archive to destination archive %v", errf)
+			return "", errf
+		}
 	}
-	errf = os.RemoveAll(filepath.Dir(dockerfile))
-	if errf != nil {
-		logger.Log.Fatalf("Unable to delete dependencies dir %v", errf)
+	var roots []string
+	for _, m := range modules {
+		depsDir := Cfg.Output_dir + "/" + svcName + "/" + m.Tool.DepsDir()
+		if len(modules) > 1 {
+			depsDir = depsDir + "/" + m.Name
+			errf := os.MkdirAll(filepath.Dir(depsDir), 0744)
+			if errf != nil {
+				logger.Log.Errorf("Unable to create dependencies dir %v", errf)
+				return "", errf
+			}
+		}
+		cache := filepath.Dir(m.Dockerfile) + "/" + m.Tool.CacheDir()
+		if fileExists(cache) || !fileExists(depsDir) {
+			errf := os.Rename(cache, depsDir)
+			if errf != nil {
+				logger.Log.Errorf("Unable to move dependencies dir to destination archive %v", errf)
+				return "", errf
+			}
+		}
+		root := sourcesRoot(Cfg.Output_dir+"/"+svcName, m.Dockerfile)
+		if !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
 	}
-	errf = os.Rename(Cfg.Output_dir+"/"+svcPath+"."+Cfg.Archive_format, Cfg.Output_dir+"/"+svcName+"/"+svcPath+"."+Cfg.Archive_format)
-	if errf != nil {
-		logger.Log.Fatalf("Unable to move sources archive to destination archive %v", errf)
+	for _, root := range roots {
+		errf := os.RemoveAll(root)
+		if errf != nil {
+			logger.Log.Errorf("Unable to delete dependencies dir %v", errf)
+			return "", errf
+		}
 	}
 	p = Cfg.Output_dir + "/" + svcName
 	return p, nil
 }
 
-func CreateReadme(svc string, svcPath string, path string, tmplFile string) error {
+// CreateReadmes Создает README сервиса по шаблону системы сборки его модулей. Если в сервисе есть модули gradle и maven,
+// README.md описывает модули системы сборки первого модуля, README_<система сборки>.md - модули другой системы сборки.
+func CreateReadmes(svc string, repoUrl string, path string, modules []Module) error {
+	var tools []BuildTool
+	for _, m := range modules {
+		if !slices.Contains(tools, m.Tool) {
+			tools = append(tools, m.Tool)
+		}
+	}
+	files := make(map[BuildTool]string)
+	for i, tool := range tools {
+		files[tool] = "README.md"
+		if i > 0 {
+			files[tool] = "README_" + string(tool) + ".md"
+		}
+	}
+	for _, tool := range tools {
+		tmpl := Cfg.ReadmeTemplate
+		if tool == Maven {
+			tmpl = Cfg.MavenReadmeTemplate
+		}
+		var toolModules []Module
+		for _, m := range modules {
+			if m.Tool == tool {
+				toolModules = append(toolModules, m)
+			}
+		}
+		var readmes []string
+		for _, t := range tools {
+			if t != tool {
+				readmes = append(readmes, files[t])
+			}
+		}
+		err := CreateReadme(svc, repoUrl, path+"/"+files[tool], tmpl, toolModules, len(modules) > 1, readmes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateReadme Создает файл README file по шаблону tmplFile для модулей modules одной системы сборки.
+// multiModule - в сервисе несколько модулей и их зависимости разложены по подкаталогам модулей,
+// readmes - файлы README модулей другой системы сборки.
+func CreateReadme(svc string, repoUrl string, file string, tmplFile string, modules []Module, multiModule bool, readmes []string) error {
 	type TemplateStrings struct {
 		SvcName         string
 		Branch          string
 		Url             string
+		InitScript      bool
+		MultiModule     bool
+		Modules         []Module
+		Readmes         []string
 		Deps            []string
 		Deps_no_sources []string
 		Deps_no_ver     []string
 		Deps_unknown    []string
 		Deps_sx_unknown []string
+		Deps_conflicts  []string
+		Deps_classified []string
+		Deps_checksum   []string
+		Provenance      Provenance
+		Deps_provenance []string
+		Deps_scm        []string
 	}
 	logger.Log.Debugf("Processing Readme.md file for service %s", svc)
 	sort.Strings(Known_deps[svc])
@@ -1006,35 +1507,56 @@ func CreateReadme(svc string, svcPath string, path string, tmplFile string) erro
 	sort.Strings(Unknown_sx_deps_ver[svc])
 	sort.Strings(Unknown_deps[svc])
 	sort.Strings(Unknown_sx_deps[svc])
+	sort.Strings(Classifier_deps[svc])
+	sort.Strings(Checksum_errors[svc])
+	depsProv := depsProvenance(svc)
+	sort.Strings(depsProv)
+	depsScm := scmSources(svc)
+	sort.Strings(depsScm)
 	logger.Log.Debugf("Processing 2 Readme.md file for service %s", svc)
 
-	t := TemplateStrings{svc, Cfg.Branch, ProjectsMap[svcPath].HTTPURLToRepo, slices.Compact(Known_deps[svc]),
+	initScript := false
+	for _, m := range modules {
+		initScript = initScript || m.InitScript
+	}
+	var deps []string
+	for _, d := range slices.Compact(Known_deps[svc]) {
+		deps = append(deps, depWithModules(svc, d))
+	}
+	t := TemplateStrings{svc, Cfg.Branch, repoUrl, initScript, multiModule, modules, readmes, deps,
 		slices.Compact(Without_src_deps[svc]),
 		slices.Compact(Unknown_sx_deps_ver[svc]),
 		slices.Compact(Unknown_deps[svc]),
-		slices.Compact(Unknown_sx_deps[svc])}
+		slices.Compact(Unknown_sx_deps[svc]),
+		graphConflicts(modules),
+		slices.Compact(Classifier_deps[svc]),
+		slices.Compact(Checksum_errors[svc]),
+		Sources_provenance[svc],
+		depsProv,
+		depsScm}
 	logger.Log.Debugf("Processing 3 Readme.md file for service %s", svc)
 	if _, err := os.Stat(tmplFile); os.IsNotExist(err) {
-		logger.Log.Fatalf("Unable to find template, error: %v", err)
+		logger.Log.Errorf("Unable to find template, error: %v", err)
+		return err
 	}
 	tmpl, err := template.ParseFiles(tmplFile)
 	logger.Log.Tracef("Parsed tmpl file %s, %v", tmplFile, tmpl)
 	if err != nil {
-		logger.Log.Fatalf("Template parsing error: %v ", err)
+		logger.Log.Errorf("Template parsing error: %v ", err)
+		return err
 	}
-	w, err := os.Create(path + "/README.md")
-	logger.Log.Tracef("Created README.md file %s", path+"/README.md")
+	w, err := os.Create(file)
+	logger.Log.Tracef("Created README file %s", file)
 	if err != nil {
-		logger.Log.Fatalf("Error create template filer: %v ", err)
+		logger.Log.Errorf("Error create template filer: %v ", err)
+		return err
 	}
 	defer w.Close()
 	logger.Log.Tracef("Execute template engine")
 	err = tmpl.Execute(w, t)
 	if err != nil {
-		logger.Log.Fatalf("Template output error: %v ", err)
-	}
-	if err != nil {
-		logger.Log.Fatalf("Error writing archive file: %v", err)
+		logger.Log.Errorf("Template output error: %v ", err)
+		return err
 	}
 	return nil
 }
@@ -1048,34 +1570,147 @@ func SummaryReport(r string) error {
 	logger.Log.Debugf("Processing summary report file: %s", r)
 	w, err := os.Create(Cfg.Output_dir + "/" + r)
 	if err != nil {
-		logger.Log.Fatalf("Error create summary report file: %v", err)
+		return fmt.Errorf("error create summary report file: %w", err)
 	}
 	defer w.Close()
-	_, err = w.WriteString("Обработанные сервисы:\n\n")
+	_, err = w.WriteString("Результаты обработки сервисов (формат сервис: succeeded, failed at stage <стадия>: ошибка, skipped: причина, interrupted at stage <стадия>):\n\n")
 	if err != nil {
-		logger.Log.Fatalf("Error write to report file: %v", err)
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for _, svc := range outcomeServices() {
+		_, err = w.WriteString(svc + ": " + Outcomes[svc].String() + "\n")
+		if err != nil {
+			return fmt.Errorf("error write to report file: %w", err)
+		}
+	}
+
+	_, err = w.WriteString("\nОбработанные сервисы:\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
 	}
 	for svc, _ := range Known_deps {
 		_, err = w.WriteString(svc + "\n")
 		if err != nil {
-			logger.Log.Fatalf("Error write to report file: %v", err)
+			return fmt.Errorf("error write to report file: %w", err)
+		}
+	}
+
+	_, err = w.WriteString("\nФайлы и стадии сборки (формат сервис/модуль: файл, стадия):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for svc, descriptors := range Build_descriptors {
+		for _, d := range descriptors {
+			_, err = w.WriteString(svc + "/" + d + "\n")
+			if err != nil {
+				return fmt.Errorf("error write to report file: %w", err)
+			}
+		}
+	}
+
+	_, err = w.WriteString("\nОшибки проверки оффлайн сборки (формат сервис: ошибка):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for svc, e := range Verify_errors {
+		_, err = w.WriteString(svc + ": " + e + "\n")
+		if err != nil {
+			return fmt.Errorf("error write to report file: %w", err)
+		}
+	}
+
+	_, err = w.WriteString("\nВоспроизводимость сборки (формат сервис: результат сравнения артефактов онлайн и оффлайн сборки):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for svc, r := range Repro_results {
+		_, err = w.WriteString(svc + ": " + r + "\n")
+		if err != nil {
+			return fmt.Errorf("error write to report file: %w", err)
+		}
+	}
+
+	_, err = w.WriteString("\nПроисхождение исходных кодов (формат сервис[/библиотека]: проект ref [способ поиска версии] (commit SHA, дата, автор)):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	var provSvcs []string
+	for svc := range Sources_provenance {
+		provSvcs = append(provSvcs, svc)
+	}
+	sort.Strings(provSvcs)
+	for _, svc := range provSvcs {
+		_, err = w.WriteString(svc + ": " + Sources_provenance[svc].String() + "\n")
+		if err != nil {
+			return fmt.Errorf("error write to report file: %w", err)
+		}
+		deps := depsProvenance(svc)
+		sort.Strings(deps)
+		for _, d := range deps {
+			_, err = w.WriteString(svc + "/" + d + "\n")
+			if err != nil {
+				return fmt.Errorf("error write to report file: %w", err)
+			}
+		}
+	}
+
+	_, err = w.WriteString("\nИсходные коды сторонних зависимостей из SCM (формат сервис/библиотека: sources from SCM tag тег: проект ref [способ поиска тега] (commit SHA, дата, автор)):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	var scmSvcs []string
+	for svc := range Scm_sources {
+		scmSvcs = append(scmSvcs, svc)
+	}
+	sort.Strings(scmSvcs)
+	for _, svc := range scmSvcs {
+		deps := scmSources(svc)
+		sort.Strings(deps)
+		for _, d := range deps {
+			_, err = w.WriteString(svc + "/" + d + "\n")
+			if err != nil {
+				return fmt.Errorf("error write to report file: %w", err)
+			}
+		}
+	}
+
+	_, err = w.WriteString("\nПрерванные сервисы (формат сервис: стадия, на которой прервана обработка, завершенные стадии сохранены для -resume):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for _, svc := range outcomeServices() {
+		if Outcomes[svc].Status != StatusInterrupted {
+			continue
+		}
+		_, err = w.WriteString(svc + ": " + Outcomes[svc].Stage + "\n")
+		if err != nil {
+			return fmt.Errorf("error write to report file: %w", err)
 		}
 	}
 
 	_, err = w.WriteString("\nНе найденные проекты:\n\n")
 	if err != nil {
-		logger.Log.Fatalf("Error write to report file: %v", err)
+		return fmt.Errorf("error write to report file: %w", err)
 	}
 	for _, svc := range UnknownProjects {
 		_, err = w.WriteString(svc + "\n")
 		if err != nil {
-			logger.Log.Fatalf("Error write to report file: %v", err)
+			return fmt.Errorf("error write to report file: %w", err)
 		}
 	}
 
+	err = writeAmbiguous(w, "\nНеоднозначные пути проектов сервисов (формат путь: полные пути проектов в подгруппах, в service_list нужно указать полный путь, проекты с одинаковым путем из разных подгрупп обрабатываются отдельными запусками с разными output_dir):\n\n", AmbiguousProjects)
+	if err != nil {
+		return err
+	}
+	err = writeAmbiguous(w, "\nНеоднозначные пути проектов источников зависимостей (формат путь: полные пути проектов в группах, в правилах projects источника нужно указать полный путь):\n\n", AmbiguousSources)
+	if err != nil {
+		return err
+	}
+
 	_, err = w.WriteString("\nНе найденные зависимости (формат сервис/библиотека):\n\n")
 	if err != nil {
-		logger.Log.Fatalf("Error write to report file: %v", err)
+		return fmt.Errorf("error write to report file: %w", err)
 	}
 	for svc, dep := range Unknown_deps {
 		for _, d := range dep {
@@ -1093,7 +1728,7 @@ func SummaryReport(r string) error {
 			logger.Log.Tracef("Unkonwn dependecy service: %s", s)
 			_, err = w.WriteString(s + "/" + dep + "\n")
 			if err != nil {
-				logger.Log.Fatalf("Error write to report file: %v", err)
+				return fmt.Errorf("error write to report file: %w", err)
 			}
 		}
 	}
@@ -1102,23 +1737,65 @@ func SummaryReport(r string) error {
 		for _, s := range svc {
 			_, err = w.WriteString(s + "/" + dep + "\n")
 			if err != nil {
-				logger.Log.Fatalf("Error write to report file: %v", err)
+				return fmt.Errorf("error write to report file: %w", err)
+			}
+		}
+	}
+	_, err = w.WriteString("\nАртефакты с классификатором (формат сервис/библиотека:классификатор - исходники):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for svc, deps := range Classifier_deps {
+		for _, d := range deps {
+			_, err = w.WriteString(svc + "/" + d + "\n")
+			if err != nil {
+				return fmt.Errorf("error write to report file: %w", err)
+			}
+		}
+	}
+	_, err = w.WriteString("\nЗависимости с несовпадающей контрольной суммой, не включены в архив (формат сервис/библиотека: файл, ожидаемая сумма и ее источник, фактическая сумма):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for svc, errs := range Checksum_errors {
+		for _, e := range errs {
+			_, err = w.WriteString(svc + "/" + e + "\n")
+			if err != nil {
+				return fmt.Errorf("error write to report file: %w", err)
+			}
+		}
+	}
+
+	_, err = w.WriteString("\nРепозитории, из которых скачаны зависимости (формат сервис/библиотека: репозиторий):\n\n")
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	for svc, origins := range Deps_origin {
+		var deps []string
+		for d := range origins {
+			deps = append(deps, d)
+		}
+		sort.Strings(deps)
+		for _, d := range deps {
+			_, err = w.WriteString(svc + "/" + d + ": " + origins[d] + "\n")
+			if err != nil {
+				return fmt.Errorf("error write to report file: %w", err)
 			}
 		}
 	}
 	_, err = w.WriteString("\nСписок зависимостей (Список зависимостей по каждому сервису):\n\n")
 	if err != nil {
-		logger.Log.Fatalf("Error write to report file: %v", err)
+		return fmt.Errorf("error write to report file: %w", err)
 	}
 	for svc, svc_dep := range Known_deps {
 		_, err = w.WriteString("\n----------------\nСервис: " + svc + "\n")
 		if err != nil {
-			logger.Log.Fatalf("Error write to report file: %v", err)
+			return fmt.Errorf("error write to report file: %w", err)
 		}
 		for _, s := range svc_dep {
-			_, err = w.WriteString(s + "\n")
+			_, err = w.WriteString(depWithModules(svc, s) + "\n")
 			if err != nil {
-				logger.Log.Fatalf("Error write to report file: %v", err)
+				return fmt.Errorf("error write to report file: %w", err)
 			}
 			ds_known = append(ds_known, s)
 		}
@@ -1126,12 +1803,12 @@ func SummaryReport(r string) error {
 	total_ds := slices.Compact(ds_known)
 	_, err = w.WriteString("\nОбщий Список зависимостей (Список зависимостей по всем сервисам сразу):\n\n")
 	if err != nil {
-		logger.Log.Fatalf("Error write to report file: %v", err)
+		return fmt.Errorf("error write to report file: %w", err)
 	}
 	for _, s := range total_ds {
 		_, err = w.WriteString(s + "\n")
 		if err != nil {
-			logger.Log.Fatalf("Error write to report file: %v", err)
+			return fmt.Errorf("error write to report file: %w", err)
 		}
 	}
 	logger.Log.Tracef("Finished processing summary report file: %s", r)
@@ -1139,6 +1816,26 @@ func SummaryReport(r string) error {
 	return nil
 }
 
+// writeAmbiguous Записывает в отчет раздел header с неоднозначными путями проектов в порядке путей
+func writeAmbiguous(w *os.File, header string, ambiguous map[string][]string) error {
+	_, err := w.WriteString(header)
+	if err != nil {
+		return fmt.Errorf("error write to report file: %w", err)
+	}
+	var paths []string
+	for path := range ambiguous {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		_, err = w.WriteString(path + ": " + strings.Join(ambiguous[path], ", ") + "\n")
+		if err != nil {
+			return fmt.Errorf("error write to report file: %w", err)
+		}
+	}
+	return nil
+}
+
 func CopyServiceSources(sourceDir, destDir string) error {
     err := cp.Copy(sourceDir, destDir)
     if err != nil {
@@ -1146,7 +1843,7 @@ func CopyServiceSources(sourceDir, destDir string) error {
     }
     return nil
 }
-func Build(serviceName string) error {
+func Build(ctx context.Context, serviceName string) error {
     // Путь к директории mz-service (предполагается, что он находится в result/mz-service)
     mzServiceDir := filepath.Join("result", "mz-service")
 
@@ -1154,7 +1851,7 @@ func Build(serviceName string) error {
     os.Setenv("MZ_SERVICE_NAME", serviceName) 
 
     // Команда для запуска сборки
-    cmd := exec.Command("sh", "-c", "cd "+mzServiceDir+" && ./gradlew dockerBuild")
+    cmd := exec.CommandContext(ctx, "sh", "-c", "cd "+mzServiceDir+" && ./gradlew dockerBuild")
     cmd.Stdout = os.Stdout
     cmd.Stderr = os.Stderr
 
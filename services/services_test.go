@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sources/config"
+	"sources/scheduler"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	Cfg = &config.Configuration{}
+	tests := []struct {
+		name     string
+		src      string
+		tool     BuildTool
+		wrapper  string
+		stage    string
+		image    string
+		commands []string
+		version  string
+	}{
+		{
+			name: "gradle wrapper with several RUN steps",
+			src: `FROM eclipse-temurin:11-jdk AS builder
+WORKDIR /app
+COPY . .
+RUN chmod +x ./gradlew && ./gradlew clean
+RUN ./gradlew shadowJar \
+    -x test
+FROM eclipse-temurin:11-jre
+COPY --from=builder /app/build/libs/app.jar /app.jar`,
+			tool:     Gradle,
+			wrapper:  "gradle/wrapper/gradle-wrapper.properties",
+			stage:    "builder",
+			image:    "eclipse-temurin:11-jdk",
+			commands: []string{"./gradlew clean", "./gradlew shadowJar      -x test"},
+			version:  "7.5.1",
+		},
+		{
+			name: "unnamed stage with official image",
+			src: `FROM registry.local:5000/maven:3.8.6-openjdk-11
+RUN ["mvn", "-B", "package"]
+RUN echo built; mvn -B verify`,
+			tool:     Maven,
+			stage:    "#0",
+			image:    "registry.local:5000/maven:3.8.6-openjdk-11",
+			commands: []string{"mvn -B package", "mvn -B verify"},
+			version:  "3.8.6",
+		},
+		{
+			name: "build stage from base stage",
+			src: `ARG GRADLE=7.4.1
+FROM gradle:${GRADLE}-jdk11 AS base
+RUN gradle --version
+FROM base AS gradle_build
+RUN gradle build`,
+			tool:     Gradle,
+			stage:    "gradle_build",
+			image:    "gradle:7.4.1-jdk11",
+			commands: []string{"gradle --version", "gradle build"},
+			version:  "7.4.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.wrapper != "" {
+				os.MkdirAll(filepath.Join(dir, filepath.Dir(tt.wrapper)), 0755)
+				props := "distributionUrl=https\\://services.gradle.org/distributions/gradle-7.5.1-bin.zip\n"
+				os.WriteFile(filepath.Join(dir, tt.wrapper), []byte(props), 0644)
+			}
+			file := filepath.Join(dir, "Dockerfile")
+			os.WriteFile(file, []byte(tt.src), 0644)
+			plan, err := ParseDockerfile(file, tt.tool, config.DefaultBuildStagePattern)
+			if err != nil {
+				t.Fatalf("ParseDockerfile: %v", err)
+			}
+			if plan.Stage != tt.stage {
+				t.Errorf("stage = %q, want %q", plan.Stage, tt.stage)
+			}
+			if plan.Image.String() != tt.image {
+				t.Errorf("image = %q, want %q", plan.Image, tt.image)
+			}
+			if !reflect.DeepEqual(plan.Commands, tt.commands) {
+				t.Errorf("commands = %q, want %q", plan.Commands, tt.commands)
+			}
+			if plan.Version != tt.version {
+				t.Errorf("version = %q, want %q", plan.Version, tt.version)
+			}
+		})
+	}
+}
+
+func TestParseDockerfileWithoutBuild(t *testing.T) {
+	Cfg = &config.Configuration{}
+	file := filepath.Join(t.TempDir(), "Dockerfile")
+	os.WriteFile(file, []byte("FROM alpine:3.18\nRUN apk add curl\n"), 0644)
+	if _, err := ParseDockerfile(file, Gradle, config.DefaultBuildStagePattern); err == nil {
+		t.Error("expected error for Dockerfile without build command")
+	}
+}
+
+func TestDownloadDepsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/com/example/broken/"):
+			w.WriteHeader(http.StatusBadGateway)
+		case strings.HasPrefix(r.URL.Path, "/com/example/missing/"):
+			http.NotFound(w, r)
+		case strings.HasSuffix(r.URL.Path, "/tampered-1.0.jar.sha1"):
+			w.Write([]byte("0000000000000000000000000000000000000001"))
+		case strings.HasSuffix(r.URL.Path, ".pom") || strings.HasSuffix(r.URL.Path, ".jar"):
+			w.Write([]byte("content"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	Init()
+	Cfg = &config.Configuration{Archive_format: "tgz", Repositories: []config.Repository{{Name: "test", Url: srv.URL}}}
+	Downloads = scheduler.New(scheduler.Config{Concurrency: 4})
+	deps := []ProjectXml{
+		{GroupId: "com.example", ArtifactId: "lib", Version: "1.0"},
+		{GroupId: "com.example", ArtifactId: "broken", Version: "1.0"},
+		{GroupId: "com.example", ArtifactId: "missing", Version: "1.0"},
+		{GroupId: "com.example", ArtifactId: "tampered", Version: "1.0"},
+	}
+	err := DownloadDeps(context.Background(), deps, t.TempDir(), "svc")
+	// Ошибка репозитория возвращается, отсутствующая зависимость и несовпадение контрольной суммы попадают только в отчет
+	if err == nil || !strings.Contains(err.Error(), "com.example:broken:1.0") {
+		t.Fatalf("err = %v, want error of broken dependency", err)
+	}
+	for _, d := range []string{"lib", "missing", "tampered"} {
+		if strings.Contains(err.Error(), "com.example:"+d+":1.0") {
+			t.Errorf("err = %v, must not contain dependency %s", err, d)
+		}
+	}
+	if !reflect.DeepEqual(Unknown_deps["svc"], []string{"com.example:missing:1.0"}) {
+		t.Errorf("unknown deps = %v", Unknown_deps["svc"])
+	}
+	if len(Checksum_errors["svc"]) != 1 || !strings.HasPrefix(Checksum_errors["svc"][0], "com.example:tampered:1.0: ") {
+		t.Errorf("checksum errors = %v", Checksum_errors["svc"])
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DownloadDeps(ctx, deps, t.TempDir(), "svc"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context canceled", err)
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"os"
+	"sources/logger"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Interrupt Отмечает сервис svc, обработка которого прервана на стадии stage, как прерванный для отчета.
+// Результаты завершенных стадий сохраняются в журнале для продолжения обработки с ключом -resume.
+func Interrupt(svc *gitlab.Project, stage string) {
+	svcName := strings.TrimSpace(svc.Name)
+	logger.Log.Warnf("Processing of service %s interrupted at stage %s, completed stages are kept for -resume", svcName, stage)
+	// Результаты ключуются путем проекта, как в service_list
+	SetOutcome(strings.TrimSpace(svc.Path), Outcome{Status: StatusInterrupted, Stage: stage})
+}
+
+// cleanupService Удаляет результаты предыдущей обработки сервиса перед обработкой с начала: архив исходных кодов,
+// каталог сервиса, итоговый архив и временные каталоги проверки сборки
+func cleanupService(svc *gitlab.Project) {
+	svcName := strings.TrimSpace(svc.Name)
+	svcPath := strings.TrimSpace(svc.Path)
+	partial := []string{
+		Cfg.Output_dir + "/" + svcPath + "." + Cfg.Archive_format,
+		Cfg.Output_dir + "/" + svcPath + "." + Cfg.Archive_format + ".part",
+		Cfg.Output_dir + "/" + svcName,
+		Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format,
+		Cfg.Output_dir + "/" + svcName + "." + Cfg.Archive_format + ".gost",
+		Cfg.Output_dir + "/" + verifyDir + "/" + svcName,
+		Cfg.Output_dir + "/" + artifactsRoot + "/" + svcName,
+		Cfg.Output_dir + "/" + cloneDir + "/" + svcPath,
+	}
+	for _, p := range partial {
+		err := os.RemoveAll(p)
+		if err != nil {
+			logger.Log.Errorf("Unable to remove partial result %s of service %s: %v", p, svcName, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sources/config"
+	"sources/container"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestProcessServiceInterrupted(t *testing.T) {
+	inExecutableDir(t, "out dir")
+	Init()
+	Cfg = &config.Configuration{Output_dir: "out dir", Archive_format: "tgz", BuildDescriptors: []string{"Dockerfile"}, BuildStagePattern: config.DefaultBuildStagePattern}
+	svc := &gitlab.Project{Name: "my service", Path: "my-svc"}
+	partial := []string{"out dir/my-svc.tgz", "out dir/my service/src/Dockerfile", "out dir/my service/src/build.gradle"}
+	writeFile(t, partial[0], "archive")
+	writeFile(t, partial[1], "FROM gradle:7.4.1-jdk11 AS build\nRUN gradle build\n")
+	writeFile(t, partial[2], "plugins { id 'java' }\n")
+	journal = &Journal{Services: map[string]*ServiceState{
+		"my-svc": {Stages: []StageRecord{{Stage: StageFetch}, {Stage: StageExtract}}},
+	}}
+	if err := saveJournal(); err != nil {
+		t.Fatal(err)
+	}
+	// Сигнал приходит во время сборки: контекст отменяется, контейнер возвращает ошибку отмены
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake := newFakeRuntime("gradle:7.4.1-jdk11")
+	fake.OnRun = func(opts container.RunOptions) error {
+		opts.Stdout.Write([]byte("> Task :compileJava\n"))
+		cancel()
+		return ctx.Err()
+	}
+	Runtime = fake
+
+	err := ProcessService(ctx, partial[0], svc)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProcessService = %v, want context.Canceled", err)
+	}
+	if want := (Outcome{Status: StatusInterrupted, Stage: StageBuild}); Outcomes["my-svc"] != want {
+		t.Errorf("outcome = %+v, want %+v", Outcomes["my-svc"], want)
+	}
+	if code := ExitCode(); code != ExitInterrupted {
+		t.Errorf("exit code = %d, want %d", code, ExitInterrupted)
+	}
+	if len(fake.Runs) != 1 {
+		t.Errorf("runs = %d, want 1", len(fake.Runs))
+	}
+	for _, f := range partial {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("partial output %s removed: %v", f, err)
+		}
+	}
+
+	// Завершенные стадии сохранены в журнале, с ключом -resume обработка продолжается со сборки
+	if err := LoadJournal(); err != nil {
+		t.Fatal(err)
+	}
+	setFlags(t, true, "", "")
+	start, err := PrepareResume(svc)
+	if err != nil || start != StageBuild {
+		t.Errorf("PrepareResume = %q, %v, want %q", start, err, StageBuild)
+	}
+	for _, f := range partial {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("partial output %s removed on resume: %v", f, err)
+		}
+	}
+}
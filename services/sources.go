@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sources/config"
+	"sources/logger"
+	"sources/scm"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+	"golang.org/x/exp/slices"
+)
+
+// sourceMapping Источник исходных кодов внутренних зависимостей с его хранилищем и проектами его групп
+type sourceMapping struct {
+	config.SourceMapping
+	group     *regexp.Regexp
+	rules     []*regexp.Regexp
+	provider  scm.SourceProvider
+	projects  map[string]*scm.Project
+	ambiguous map[string][]string
+}
+
+var (
+	sourceMappings []*sourceMapping              //sourceMappings источники в порядке конфигурации, зависимость берется из первого подходящего
+	gitlabSource   scm.SourceProvider            //gitlabSource хранилище gitlab, из которого получаются исходные коды сервисов
+	providers      map[string]scm.SourceProvider //providers хранилища источников и зеркал по типу, адресу и токену
+)
+
+// providerFor Возвращает хранилище типа kind с адресом url и токеном из переменной среды tokenEnv.
+// Хранилище с теми же параметрами создается один раз, gitlab - общее с получением сервисов.
+func providerFor(kind string, url string, tokenEnv string) (scm.SourceProvider, error) {
+	if kind == "gitlab" {
+		return gitlabSource, nil
+	}
+	key := kind + ":" + url + ":" + tokenEnv
+	if p, ok := providers[key]; ok {
+		return p, nil
+	}
+	p, err := scm.New(kind, scm.Options{Url: url, Token: os.Getenv(tokenEnv), SkipTLS: CloneAuth.SkipTLS})
+	if err != nil {
+		return nil, err
+	}
+	providers[key] = p
+	return p, nil
+}
+
+// InitSources Создает хранилища источников sources и зеркал scm_fallback, компилирует их шаблоны и загружает проекты
+// групп источников. Проекты одной группы загружаются один раз и ищутся по полному пути, по пути относительно группы
+// и по имени, если оно однозначно.
+func InitSources(ctx context.Context) error {
+	gitlabSource = scm.NewGitlab(GitClient)
+	providers = make(map[string]scm.SourceProvider)
+	loaded := make(map[string][]*scm.Project)
+	sourceMappings = nil
+	for _, sm := range Cfg.Sources {
+		m := &sourceMapping{SourceMapping: sm}
+		var err error
+		m.provider, err = providerFor(sm.Provider, sm.Url, sm.TokenEnv)
+		if err != nil {
+			return fmt.Errorf("source mapping %q: %w", sm.Group, err)
+		}
+		switch sm.Match {
+		case "prefix":
+			m.group = regexp.MustCompile("^" + regexp.QuoteMeta(sm.Group))
+		case "regex":
+			m.group, err = regexp.Compile(sm.Group)
+		default:
+			m.group = regexp.MustCompile("^" + regexp.QuoteMeta(sm.Group) + "$")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid group pattern of source mapping %q: %w", sm.Group, err)
+		}
+		for _, r := range sm.Projects {
+			re, err := regexp.Compile(r.Artifact)
+			if err != nil {
+				return fmt.Errorf("invalid artifact pattern %q of source mapping %q: %w", r.Artifact, sm.Group, err)
+			}
+			m.rules = append(m.rules, re)
+		}
+		var all []*scm.Project
+		for _, g := range sm.Groups {
+			groupKey := fmt.Sprintf("%s:%s:%s:%s:%t", sm.Provider, sm.Url, sm.TokenEnv, g, sm.Subgroups)
+			projects, ok := loaded[groupKey]
+			if !ok {
+				projects, err = m.provider.ListProjects(ctx, g, sm.Subgroups)
+				if err != nil {
+					return err
+				}
+				if projects == nil {
+					logger.Log.Warnf("No projects found in %s group %s of source mapping %s", m.provider.Name(), g, sm.Group)
+				}
+				loaded[groupKey] = projects
+			}
+			all = append(all, projects...)
+		}
+		m.projects, m.ambiguous = scm.ProjectsMap(all)
+		for _, g := range sm.Groups {
+			for _, p := range all {
+				if rel, ok := strings.CutPrefix(p.FullPath, g+"/"); ok {
+					if _, ok := m.projects[rel]; !ok {
+						m.projects[rel] = p
+					}
+				}
+			}
+		}
+		MapMutex.Lock()
+		AmbiguousSources = mergeAmbiguous(AmbiguousSources, m.ambiguous)
+		MapMutex.Unlock()
+		logger.Log.Debugf("Source mapping %s (%s): %d projects in %s groups %v", sm.Group, sm.Match, len(m.projects), m.provider.Name(), sm.Groups)
+		sourceMappings = append(sourceMappings, m)
+	}
+	return initScmMirrors()
+}
+
+// AddAmbiguousProjects Добавляет в отчет пути проектов сервисов, которые есть в нескольких подгруппах
+func AddAmbiguousProjects(ambiguous map[string][]string) {
+	MapMutex.Lock()
+	defer MapMutex.Unlock()
+	AmbiguousProjects = mergeAmbiguous(AmbiguousProjects, ambiguous)
+}
+
+// PathCollisions Возвращает пути проектов, которые в service_list выбраны из нескольких подгрупп, с полными путями проектов.
+// Каталоги, архивы, журнал и результат сервиса ключуются путем проекта в группе, поэтому такие сервисы неоднозначны.
+func PathCollisions(projects []*gitlab.Project) map[string][]string {
+	full := make(map[string][]string)
+	for _, p := range projects {
+		path := strings.TrimSpace(p.Path)
+		if !slices.Contains(full[path], p.PathWithNamespace) {
+			full[path] = append(full[path], p.PathWithNamespace)
+		}
+	}
+	collisions := make(map[string][]string)
+	for path, f := range full {
+		if len(f) > 1 {
+			sort.Strings(f)
+			collisions[path] = f
+		}
+	}
+	return collisions
+}
+
+// mergeAmbiguous Добавляет в dst полные пути неоднозначных путей проектов из ambiguous без повторов
+func mergeAmbiguous(dst map[string][]string, ambiguous map[string][]string) map[string][]string {
+	if dst == nil {
+		dst = make(map[string][]string)
+	}
+	for path, full := range ambiguous {
+		for _, f := range full {
+			if !slices.Contains(dst[path], f) {
+				dst[path] = append(dst[path], f)
+			}
+		}
+		sort.Strings(dst[path])
+	}
+	return dst
+}
+
+// sourceFor Возвращает первый источник, шаблон которого подходит под groupId, или nil
+func sourceFor(groupId string) *sourceMapping {
+	for _, m := range sourceMappings {
+		if m.group.MatchString(groupId) {
+			return m
+		}
+	}
+	return nil
+}
+
+// projectPath Возвращает путь проекта gitlab для artifactId по первому подходящему правилу источника, без правил - artifactId
+func (m *sourceMapping) projectPath(artifactId string) string {
+	for i, re := range m.rules {
+		if idx := re.FindStringSubmatchIndex(artifactId); idx != nil {
+			return string(re.ExpandString(nil, m.Projects[i].Project, artifactId, idx))
+		}
+	}
+	return artifactId
+}
+
+// downloadFromSource Скачивает архив исходных кодов внутренней зависимости d из проекта хранилища источника m
+// по ref ее версии и сохраняет его происхождение. Ненайденные проект или версия попадают в отчет.
+func downloadFromSource(ctx context.Context, m *sourceMapping, d ProjectXml, saveto string, svcName string) error {
+	path := m.projectPath(d.ArtifactId)
+	project := m.projects[path]
+	if project == nil {
+		if full, ambiguous := m.ambiguous[path]; ambiguous {
+			logger.Log.Warnf("Dependency %s matches several %s projects %v, add a projects rule with the full path to source mapping %s", d.Key(), m.provider.Name(), full, m.Group)
+		} else {
+			logger.Log.Warnf("Dependency %s not found in %s groups %v as project %s", d.Key(), m.provider.Name(), m.Groups, path)
+		}
+		MapMutex.Lock()
+		Unknown_sx_deps[svcName] = append(Unknown_sx_deps[svcName], d.Key())
+		Without_src_deps[svcName] = append(Without_src_deps[svcName], d.Key())
+		MapMutex.Unlock()
+		return nil
+	}
+	logger.Log.Tracef("Found dependency %s in %s, project: %s", d.Key(), m.provider.Name(), project.FullPath)
+	ver, match, err := FindRef(ctx, m.provider, project, d, m.RefLookup)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		logger.Log.Errorf("Unable to find version of dependency %s in %s: %v", d.Key(), m.provider.Name(), err)
+	}
+	if ver == "" {
+		logger.Log.Warnf("Not found version of dependency %s in %s project %s", d.Key(), m.provider.Name(), project.FullPath)
+		MapMutex.Lock()
+		Without_src_deps[svcName] = append(Without_src_deps[svcName], d.Key())
+		Unknown_sx_deps_ver[svcName] = append(Unknown_sx_deps_ver[svcName], d.Key())
+		MapMutex.Unlock()
+		return nil
+	}
+	logger.Log.Tracef("Trying to download dependency %s from %s", d.Key(), m.provider.Name())
+	archive, prov, err := FetchArchive(ctx, m.provider, project, ver)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Log.Errorf("Unable to download archive of %s from %s: %v", d.Key(), m.provider.Name(), err)
+		}
+		return err
+	}
+	dir := saveto + "/" + d.GroupId + "/" + d.ArtifactId + "/" + d.Version
+	file := d.ArtifactId + "." + Cfg.Archive_format
+	err = os.WriteFile(dir+"/"+file, archive, 0644)
+	if err != nil {
+		logger.Log.Errorf("Error writing archive file: %v", err)
+		return err
+	}
+	err = hashFile(dir + "/" + file)
+	if err != nil {
+		logger.Log.Errorf("Error calc hash for file: %v", err)
+	}
+	prov.Match = match
+	setDepProvenance(svcName, d.Key(), prov)
+	err = writeProvenance(dir, prov)
+	if err != nil {
+		logger.Log.Errorf("Error writing provenance of %s: %v", d.Key(), err)
+	}
+	if Cfg.Cache {
+		for _, f := range []string{provenanceFile, file, file + ".gost"} {
+			err = SaveToCache(d.GroupId+"/"+d.ArtifactId+"/"+d.Version, f, dir+"/"+f)
+			if err != nil {
+				logger.Log.Errorf("Could not save %s file to cache dir: %v", d.Key(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestMergeAmbiguous(t *testing.T) {
+	dst := mergeAmbiguous(nil, map[string][]string{"auth": {"pgs/b/auth", "pgs/a/auth"}})
+	dst = mergeAmbiguous(dst, map[string][]string{"auth": {"pgs/a/auth", "pgs/c/auth"}, "idm": {"core/idm", "api/idm"}})
+	want := map[string][]string{
+		"auth": {"pgs/a/auth", "pgs/b/auth", "pgs/c/auth"},
+		"idm":  {"api/idm", "core/idm"},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("merged = %v, want %v", dst, want)
+	}
+}
+
+func TestAmbiguousKeptSeparately(t *testing.T) {
+	AmbiguousProjects, AmbiguousSources = nil, nil
+	t.Cleanup(func() { AmbiguousProjects, AmbiguousSources = nil, nil })
+	// Неоднозначный путь сервиса не делает неоднозначным проект источника зависимостей с тем же путем
+	AddAmbiguousProjects(map[string][]string{"auth": {"services/a/auth", "services/b/auth"}})
+	if _, ok := AmbiguousSources["auth"]; ok {
+		t.Errorf("service ambiguity leaked into sources: %v", AmbiguousSources)
+	}
+	if !reflect.DeepEqual(AmbiguousProjects["auth"], []string{"services/a/auth", "services/b/auth"}) {
+		t.Errorf("ambiguous projects = %v", AmbiguousProjects)
+	}
+}
+
+func TestPathCollisions(t *testing.T) {
+	core := &gitlab.Project{Path: "idm", PathWithNamespace: "pgs2/core/idm"}
+	api := &gitlab.Project{Path: "idm", PathWithNamespace: "pgs2/api/idm"}
+	auth := &gitlab.Project{Path: "auth", PathWithNamespace: "pgs2/core/auth"}
+	// Один проект, указанный в service_list путем и полным путем, коллизией не считается
+	got := PathCollisions([]*gitlab.Project{core, auth, api, auth, core})
+	if want := map[string][]string{"idm": {"pgs2/api/idm", "pgs2/core/idm"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("collisions = %v, want %v", got, want)
+	}
+	if got := PathCollisions([]*gitlab.Project{core, auth}); len(got) != 0 {
+		t.Errorf("collisions = %v, want none", got)
+	}
+}
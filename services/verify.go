@@ -0,0 +1,204 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sources/container"
+	"sources/logger"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+	"golang.org/x/exp/slices"
+)
+
+// verifyDir Каталог для распаковки итогового архива сервиса при проверке оффлайн сборки
+const verifyDir = ".verify"
+
+// extractFile Распаковывает tgz архив file в каталог output
+func extractFile(file string, output string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return ExtractTgz(r, output)
+}
+
+// VerifyBuild Проверяет, что каталог итогового архива сервиса самодостаточен: распаковывает вложенные архивы во временный каталог,
+// загружает образы из docker_images и запускает описанную в README оффлайн сборку каждого модуля
+// в контейнере без сети. Ошибка сборки сохраняется в Verify_errors для отчета.
+// Вызывается до упаковки каталога сервиса в итоговый архив, содержимое каталога не изменяется.
+func VerifyBuild(ctx context.Context, svc *gitlab.Project, modules []Module) error {
+	svcName := strings.TrimSpace(svc.Name)
+	svcPath := strings.TrimSpace(svc.Path)
+	bundle := Cfg.Output_dir + "/" + svcName
+	scratch := Cfg.Output_dir + "/" + verifyDir + "/" + svcName
+	err := os.RemoveAll(scratch)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(scratch, 0755)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	// Вложенные архивы содержат полный путь вместе с output_dir
+	unpacked := scratch + "/" + Cfg.Output_dir + "/" + svcName
+	parts := []string{"docker_images"}
+	for _, m := range modules {
+		if !slices.Contains(parts, m.Tool.DepsDir()) {
+			parts = append(parts, m.Tool.DepsDir())
+		}
+		if m.Tool == Gradle && !slices.Contains(parts, "gradle_configs") {
+			parts = append(parts, "gradle_configs")
+		}
+	}
+	for _, p := range parts {
+		err = extractFile(bundle+"/"+p+"."+Cfg.Archive_format, scratch)
+		if err != nil {
+			return err
+		}
+	}
+	src := scratch + "/sources"
+	err = extractFile(bundle+"/"+svcPath+"."+Cfg.Archive_format, src)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return errors.New("Unexpected layout of sources archive " + svcPath + "." + Cfg.Archive_format)
+	}
+	srcRoot := src + "/" + entries[0].Name()
+	images, err := filepath.Glob(unpacked + "/docker_images/*.tar")
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		logger.Log.Debugf("Load docker image %s", img)
+		err = Runtime.Load(ctx, img)
+		if err != nil {
+			return err
+		}
+	}
+	unpackedAbs, err := filepath.Abs(unpacked)
+	if err != nil {
+		return err
+	}
+	for _, m := range modules {
+		moduleDir := srcRoot
+		if m.Name != "root" {
+			moduleDir = srcRoot + "/" + m.Name
+		}
+		deps := unpacked + "/" + m.Tool.DepsDir()
+		if len(modules) > 1 {
+			deps = deps + "/" + m.Name
+		}
+		err = os.Rename(deps, moduleDir+"/"+m.Tool.DepsDir())
+		if err != nil {
+			return err
+		}
+		plan, err := ParseDockerfile(moduleDir+"/"+m.Descriptor, m.Tool, Cfg.StagePattern(svcPath))
+		if err != nil {
+			return err
+		}
+		initScript := ""
+		if m.InitScript {
+			initScript = unpackedAbs + "/gradle_configs/init.gradle"
+		}
+		moduleAbs, err := filepath.Abs(moduleDir)
+		if err != nil {
+			return err
+		}
+		opts := offlineRunOptions(m.Tool, moduleAbs, plan, initScript)
+		var outb, errb bytes.Buffer
+		logger.Log.Debugf("Run offline build for service %s, module %s", svcName, m.Name)
+		if err := runBuild(ctx, opts, &outb, &errb); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			e := buildError(m.Tool, outb.String()+"\n"+errb.String())
+			MapMutex.Lock()
+			Verify_errors[svcName] = m.Name + ": " + e
+			MapMutex.Unlock()
+			logger.Log.Tracef("Offline build stdout: %s, stderr: %s", outb.String(), errb.String())
+			return errors.New("Offline build of module " + m.Name + " failed: " + e)
+		}
+		logger.Log.Debugf("Offline build for service %s, module %s succeeded", svcName, m.Name)
+		if Cfg.ReproducibilityCheck {
+			err = captureArtifacts(moduleDir, m.Tool, artifactsDir(svcName, "offline", m.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// offlineRunOptions Формирует параметры оффлайн сборки из архива так, как она описана в README: кеш из архива,
+// ключ --offline для gradle или -o для maven, сеть в контейнере отключена
+func offlineRunOptions(tool BuildTool, dir string, plan *BuildPlan, initScript string) container.RunOptions {
+	opts := container.RunOptions{
+		Image:   plan.Image.String(),
+		User:    "1000",
+		Network: "none",
+	}
+	if tool == Maven {
+		opts.WorkDir = "/build"
+		opts.Mounts = []container.Mount{{Source: dir, Target: "/build"}}
+		opts.Cmd = []string{"bash", "-c", plan.Command(" -o -Dmaven.repo.local=/build/" + tool.DepsDir())}
+		return opts
+	}
+	opts.WorkDir = "/home/gradle"
+	opts.Env = []string{"GRADLE_USER_HOME=" + tool.DepsDir()}
+	opts.Mounts = []container.Mount{{Source: dir, Target: "/home/gradle"}}
+	flags := " -g " + tool.DepsDir() + " --offline --no-build-cache"
+	if initScript != "" {
+		opts.Mounts = append(opts.Mounts, container.Mount{Source: initScript, Target: "/tmp/init.gradle", ReadOnly: true})
+		flags = flags + " -I /tmp/init.gradle"
+	}
+	opts.Cmd = []string{"bash", "-c", plan.Command(flags)}
+	return opts
+}
+
+// buildError Возвращает краткое описание ошибки сборки: блок "What went wrong" gradle или строки [ERROR] maven
+func buildError(tool BuildTool, output string) string {
+	var lines []string
+	if tool == Maven {
+		for _, l := range strings.Split(output, "\n") {
+			if strings.HasPrefix(l, "[ERROR]") && strings.TrimSpace(strings.TrimPrefix(l, "[ERROR]")) != "" {
+				lines = append(lines, strings.TrimSpace(strings.TrimPrefix(l, "[ERROR]")))
+			}
+			if len(lines) == 5 {
+				break
+			}
+		}
+	} else {
+		started := false
+		for _, l := range strings.Split(output, "\n") {
+			if strings.HasPrefix(l, "* What went wrong:") {
+				started = true
+				continue
+			}
+			if started && strings.HasPrefix(l, "* ") {
+				break
+			}
+			if started && strings.TrimSpace(l) != "" {
+				lines = append(lines, strings.TrimSpace(l))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		out := strings.TrimSpace(output)
+		if len(out) > 500 {
+			out = out[len(out)-500:]
+		}
+		return strings.ReplaceAll(out, "\n", " ")
+	}
+	return strings.Join(lines, " ")
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sources/config"
+	"sources/container"
+	"sources/dockerfile"
+	"strings"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestOfflineRunOptions(t *testing.T) {
+	gradlePlan := &BuildPlan{
+		Image:    dockerfile.ParseImageRef("registry.local:5000/gradle:7.4.1-jdk11"),
+		Commands: []string{"./gradlew clean", "./gradlew build -x test"},
+	}
+	mvnPlan := &BuildPlan{
+		Image:    dockerfile.ParseImageRef("maven:3.8.6-openjdk-11"),
+		Commands: []string{"mvn -B package -DskipTests"},
+	}
+	tests := []struct {
+		name       string
+		tool       BuildTool
+		plan       *BuildPlan
+		initScript string
+		want       container.RunOptions
+	}{
+		{
+			name:       "gradle with init script",
+			tool:       Gradle,
+			plan:       gradlePlan,
+			initScript: "/work/init.gradle",
+			want: container.RunOptions{
+				Image:   "registry.local:5000/gradle:7.4.1-jdk11",
+				User:    "1000",
+				WorkDir: "/home/gradle",
+				Env:     []string{"GRADLE_USER_HOME=gradle_dependencies"},
+				Network: "none",
+				Mounts: []container.Mount{
+					{Source: "/work/my svc", Target: "/home/gradle"},
+					{Source: "/work/init.gradle", Target: "/tmp/init.gradle", ReadOnly: true},
+				},
+				Cmd: []string{"bash", "-c", "./gradlew clean -g gradle_dependencies --offline --no-build-cache -I /tmp/init.gradle && ./gradlew build -x test -g gradle_dependencies --offline --no-build-cache -I /tmp/init.gradle"},
+			},
+		},
+		{
+			name: "gradle without init script",
+			tool: Gradle,
+			plan: gradlePlan,
+			want: container.RunOptions{
+				Image:   "registry.local:5000/gradle:7.4.1-jdk11",
+				User:    "1000",
+				WorkDir: "/home/gradle",
+				Env:     []string{"GRADLE_USER_HOME=gradle_dependencies"},
+				Network: "none",
+				Mounts:  []container.Mount{{Source: "/work/my svc", Target: "/home/gradle"}},
+				Cmd:     []string{"bash", "-c", "./gradlew clean -g gradle_dependencies --offline --no-build-cache && ./gradlew build -x test -g gradle_dependencies --offline --no-build-cache"},
+			},
+		},
+		{
+			name: "maven",
+			tool: Maven,
+			plan: mvnPlan,
+			want: container.RunOptions{
+				Image:   "maven:3.8.6-openjdk-11",
+				User:    "1000",
+				WorkDir: "/build",
+				Network: "none",
+				Mounts:  []container.Mount{{Source: "/work/my svc", Target: "/build"}},
+				Cmd:     []string{"bash", "-c", "mvn -B package -DskipTests -o -Dmaven.repo.local=/build/maven_dependencies"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offlineRunOptions(tt.tool, "/work/my svc", tt.plan, tt.initScript); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildError(t *testing.T) {
+	gradleOut := `> Task :compileJava FAILED
+
+FAILURE: Build failed with an exception.
+
+* What went wrong:
+Execution failed for task ':compileJava'.
+> Could not resolve all files for configuration ':compileClasspath'.
+
+* Try:
+> Run with --stacktrace option to get the stack trace.`
+	mavenOut := `[INFO] BUILD FAILURE
+[ERROR] Failed to execute goal on project app: Could not resolve dependencies
+[ERROR] 
+[ERROR] -> [Help 1]
+[ERROR] one
+[ERROR] two
+[ERROR] three
+[ERROR] four`
+	long := "first line\n" + strings.Repeat("x", 600)
+	tests := []struct {
+		name   string
+		tool   BuildTool
+		output string
+		want   string
+	}{
+		{"gradle what went wrong", Gradle, gradleOut, "Execution failed for task ':compileJava'. > Could not resolve all files for configuration ':compileClasspath'."},
+		{"maven error lines", Maven, mavenOut, "Failed to execute goal on project app: Could not resolve dependencies -> [Help 1] one two three"},
+		{"gradle without block", Gradle, "exec: ./gradlew: not found\n", "exec: ./gradlew: not found"},
+		{"tail of long output", Maven, long, strings.Repeat("x", 500)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildError(tt.tool, tt.output); got != tt.want {
+				t.Errorf("buildError = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// packBundle Создает каталог итогового архива сервиса svcName из двух gradle модулей app и lib, как после стадии pack:
+// вложенные архивы образов, кешей модулей, init скрипта и исходных кодов
+func packBundle(t *testing.T, svcName string, svcPath string) {
+	t.Helper()
+	bundle := Cfg.Output_dir + "/" + svcName
+	writeFile(t, bundle+"/docker_images/gradle_7_4_1_jdk11.tar", "gradle:7.4.1-jdk11")
+	writeFile(t, bundle+"/gradle_dependencies/app/caches/app.jar", "app")
+	writeFile(t, bundle+"/gradle_dependencies/lib/caches/lib.jar", "lib")
+	writeFile(t, bundle+"/gradle_configs/init.gradle", "allprojects {}")
+	for _, p := range []string{"docker_images", "gradle_dependencies", "gradle_configs"} {
+		if err := packFolder(context.Background(), bundle+"/"+p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := t.TempDir()
+	root := src + "/" + svcPath + "-abc-abc"
+	for _, m := range []string{"app", "lib"} {
+		writeFile(t, root+"/"+m+"/Dockerfile.pgs2", "FROM gradle:7.4.1-jdk11 AS build\nRUN gradle build\n")
+	}
+	f, err := os.Create(bundle + "/" + svcPath + ".tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := createTgz(context.Background(), root, src, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyBuild(t *testing.T) {
+	inExecutableDir(t, "verify out")
+	Init()
+	Cfg = &config.Configuration{Output_dir: "verify out", Archive_format: "tgz", BuildStagePattern: config.DefaultBuildStagePattern}
+	svc := &gitlab.Project{Name: "my svc", Path: "my-svc"}
+	packBundle(t, svc.Name, svc.Path)
+	modules := []Module{
+		{Name: "app", Descriptor: "Dockerfile.pgs2", Tool: Gradle, InitScript: true},
+		{Name: "lib", Descriptor: "Dockerfile.pgs2", Tool: Gradle},
+	}
+	fake := newFakeRuntime()
+	// Кеш модуля из архива перенесен в каталог модуля в исходных кодах
+	deps := map[string]string{}
+	fake.OnRun = func(opts container.RunOptions) error {
+		b, err := os.ReadFile(opts.Mounts[0].Source + "/gradle_dependencies/caches/" + filepath.Base(opts.Mounts[0].Source) + ".jar")
+		if err != nil {
+			return err
+		}
+		deps[filepath.Base(opts.Mounts[0].Source)] = string(b)
+		return nil
+	}
+	Runtime = fake
+	if err := VerifyBuild(context.Background(), svc, modules); err != nil {
+		t.Fatalf("VerifyBuild: %v", err)
+	}
+	if !fake.Images["gradle:7.4.1-jdk11"] || len(fake.Loads) != 1 {
+		t.Errorf("loads = %v", fake.Loads)
+	}
+	if !reflect.DeepEqual(deps, map[string]string{"app": "app", "lib": "lib"}) {
+		t.Errorf("module deps = %v", deps)
+	}
+	if len(fake.Runs) != 2 {
+		t.Fatalf("runs = %d, want 2", len(fake.Runs))
+	}
+	for i, r := range fake.Runs {
+		if r.Network != "none" || !strings.Contains(r.Cmd[2], "gradle build -g gradle_dependencies --offline") {
+			t.Errorf("run %d = %+v", i, r)
+		}
+		if init := len(r.Mounts) == 2 && strings.HasSuffix(r.Mounts[1].Source, "/verify out/.verify/my svc/verify out/my svc/gradle_configs/init.gradle"); init != modules[i].InitScript {
+			t.Errorf("run %d mounts = %+v", i, r.Mounts)
+		}
+	}
+	// Каталог итогового архива не изменяется, временный каталог удален
+	for _, f := range []string{"docker_images.tgz", "gradle_dependencies.tgz", "gradle_configs.tgz", "my-svc.tgz"} {
+		if _, err := os.Stat(Cfg.Output_dir + "/my svc/" + f); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(Cfg.Output_dir + "/" + verifyDir + "/my svc"); !os.IsNotExist(err) {
+		t.Errorf("scratch directory is not removed: %v", err)
+	}
+}
+
+func TestVerifyBuildFailed(t *testing.T) {
+	inExecutableDir(t, "verify out")
+	Init()
+	Cfg = &config.Configuration{Output_dir: "verify out", Archive_format: "tgz", BuildStagePattern: config.DefaultBuildStagePattern}
+	svc := &gitlab.Project{Name: "my svc", Path: "my-svc"}
+	packBundle(t, svc.Name, svc.Path)
+	fake := newFakeRuntime()
+	fake.OnRun = func(opts container.RunOptions) error {
+		if strings.HasSuffix(opts.Mounts[0].Source, "/lib") {
+			opts.Stdout.Write([]byte("* What went wrong:\nCould not resolve com.example:missing:1.0.\n* Try:\n"))
+			return &container.ExitError{Code: 1}
+		}
+		return nil
+	}
+	Runtime = fake
+	modules := []Module{
+		{Name: "app", Descriptor: "Dockerfile.pgs2", Tool: Gradle},
+		{Name: "lib", Descriptor: "Dockerfile.pgs2", Tool: Gradle},
+	}
+	err := VerifyBuild(context.Background(), svc, modules)
+	if err == nil || !strings.Contains(err.Error(), "module lib") {
+		t.Fatalf("err = %v, want offline build error of module lib", err)
+	}
+	if want := "lib: Could not resolve com.example:missing:1.0."; Verify_errors["my svc"] != want {
+		t.Errorf("verify error = %q, want %q", Verify_errors["my svc"], want)
+	}
+	// Отмена не считается ошибкой оффлайн сборки
+	Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	fake.OnRun = func(opts container.RunOptions) error {
+		cancel()
+		return &container.ExitError{Code: 137}
+	}
+	if err := VerifyBuild(ctx, svc, modules); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context canceled", err)
+	}
+	if _, ok := Verify_errors["my svc"]; ok {
+		t.Errorf("verify error recorded for canceled build: %q", Verify_errors["my svc"])
+	}
+}